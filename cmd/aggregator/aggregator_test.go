@@ -1,16 +1,22 @@
 package main
 
 import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/circuitbreaker"
 	"distributed-system/pkg/model"
 	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/ring"
 	"encoding/json"
+	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
 
-// TestGetHistoryFromShard_Resilience valida se o cliente lida com erros de rede e dados.
-func TestGetHistoryFromShard_Resilience(t *testing.T) {
+// TestQueryShard_Resilience valida se o cliente lida com erros de rede e dados.
+func TestQueryShard_Resilience(t *testing.T) {
 	// Setup: Servidor Mock de Shard na porta aleatória
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
@@ -33,7 +39,7 @@ func TestGetHistoryFromShard_Resilience(t *testing.T) {
 
 		// Responder com sucesso simulado
 		txs := []model.Transaction{{ID: "tx1", Price: 100.50, Symbol: "TEST"}}
-		payload, _ := json.Marshal(txs)
+		payload, _ := json.Marshal(query.Page{Transactions: txs})
 		resp := protocol.Message{Type: protocol.MsgReqHistory, Payload: payload}
 		
 		// Simular latência leve
@@ -42,25 +48,420 @@ func TestGetHistoryFromShard_Resilience(t *testing.T) {
 	}()
 
 	// Executar a função alvo (do main.go)
-	// Nota: getHistoryFromShard faz dial tcp.
-	txs, err := getHistoryFromShard(mockAddr)
+	// Nota: queryShard faz dial tcp.
+	page, err := queryShard(mockAddr, query.Query{Symbol: "TEST"})
 
 	if err != nil {
 		t.Fatalf("Falha ao buscar histórico do mock: %v", err)
 	}
 
-	if len(txs) != 1 || txs[0].ID != "tx1" {
-		t.Errorf("Dados recebidos incorretos. Recebido: %v", txs)
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != "tx1" {
+		t.Errorf("Dados recebidos incorretos. Recebido: %v", page.Transactions)
 	}
 }
 
-// TestGetHistoryFromShard_ConnectionRefused valida comportamento quando o shard está down
-func TestGetHistoryFromShard_ConnectionRefused(t *testing.T) {
+// TestQueryShard_ConnectionRefused valida comportamento quando o shard está down
+func TestQueryShard_ConnectionRefused(t *testing.T) {
 	// Tentar conectar em uma porta onde esperamos que nada esteja rodando
 	// Usando porta alta aleatória e localhost
-	_, err := getHistoryFromShard("localhost:45821")
+	_, err := queryShard("localhost:45821", query.Query{})
 	
 	if err == nil {
 		t.Error("Esperava erro de conexão recusada, recebeu nil")
 	}
 }
+
+// TestQueryPartition_FailsOverToReplica valida que, com o primário fora do ar,
+// o histórico da partição vem da réplica.
+func TestQueryPartition_FailsOverToReplica(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req protocol.Message
+		protocol.ReceiveJSON(conn, &req)
+		page := query.Page{Transactions: []model.Transaction{{ID: "replicated", Symbol: "PETR4"}}}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespHistory, page))
+	}()
+
+	p := ring.Partition{Primary: "localhost:45822", Replicas: []string{listener.Addr().String()}}
+	page, err := queryPartition(p, query.Query{Symbol: "PETR4"})
+	if err != nil {
+		t.Fatalf("Esperava failover para a réplica, recebeu erro: %v", err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].ID != "replicated" {
+		t.Errorf("Histórico deveria vir da réplica, recebido: %v", page.Transactions)
+	}
+
+	// Sem nenhum nó disponível, o erro cita todos eles
+	_, err = queryPartition(ring.Partition{Primary: "localhost:45822", Replicas: []string{"localhost:45823"}}, query.Query{})
+	if err == nil || !strings.Contains(err.Error(), "45823") {
+		t.Errorf("Esperava erro cobrindo primário e réplica, recebeu %v", err)
+	}
+}
+
+// mockShard responde REQ_HIST com page, REQ_AGGREGATE com os parciais de page e repassa as gravações (STORE_TX) recebidas em stored.
+func mockShard(t *testing.T, page query.Page, stored chan<- model.Transaction) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var req protocol.Message
+				if err := protocol.ReceiveJSON(conn, &req); err != nil {
+					return
+				}
+				switch req.Type {
+				case protocol.MsgReqHistory:
+					protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespHistory, page))
+				case protocol.MsgReqAggregate:
+					var ar aggregate.Request
+					json.Unmarshal(req.Payload, &ar)
+					partials, _ := aggregate.Compute(func(q query.Query) (query.Page, error) {
+						return query.Run(func(fn func(model.Transaction) bool) {
+							for _, tx := range page.Transactions {
+								if !fn(tx) {
+									return
+								}
+							}
+						}, q)
+					}, ar)
+					protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAggregate, partials))
+				case protocol.MsgStoreTx:
+					var tx model.Transaction
+					json.Unmarshal(req.Payload, &tx)
+					protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespStore, tx))
+					stored <- tx
+				}
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestQuorumQuery_ResolvesVersionsAndRepairs valida a leitura com R respostas,
+// a escolha da maior versão e o reparo do nó desatualizado.
+func TestQuorumQuery_ResolvesVersionsAndRepairs(t *testing.T) {
+	now := time.Now()
+	current := model.Transaction{ID: "tx1", Symbol: "PETR4", Price: 26, Timestamp: now, Version: 2}
+	old := current
+	old.Price, old.Version = 25, 1
+
+	repaired := make(chan model.Transaction, 1)
+	fresh := mockShard(t, query.Page{Transactions: []model.Transaction{current}}, nil)
+	stale := mockShard(t, query.Page{Transactions: []model.Transaction{old}}, repaired)
+
+	p := ring.Partition{Primary: fresh, Replicas: []string{stale}}
+	page, err := quorumQuery(p, query.Query{Symbol: "PETR4"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Transactions) != 1 || page.Transactions[0].Price != 26 {
+		t.Errorf("Esperava a versão mais nova de tx1, recebido: %+v", page.Transactions)
+	}
+
+	select {
+	case tx := <-repaired:
+		if tx.Version != 2 {
+			t.Errorf("Reparo deveria gravar a versão 2, gravou %+v", tx)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Nó desatualizado não recebeu o reparo de leitura")
+	}
+
+	if _, err := quorumQuery(p, query.Query{}, 3); err == nil {
+		t.Error("R maior que o número de nós deveria ser rejeitado")
+	}
+}
+
+// TestHandleAggregate_MergesPartialsAndAvoidsDoubleCounting valida a junção dos
+// parciais dos shards e, durante uma migração, a agregação a partir das
+// transações sem contar duas vezes as que estão no dono antigo e no novo.
+func TestHandleAggregate_MergesPartialsAndAvoidsDoubleCounting(t *testing.T) {
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	a := []model.Transaction{
+		{ID: "a1", Symbol: "PETR4", Price: 10, Quantity: 100, Timestamp: base},
+		{ID: "a2", Symbol: "PETR4", Price: 30, Quantity: 100, Timestamp: base.Add(90 * time.Second)},
+	}
+	b := []model.Transaction{{ID: "b1", Symbol: "PETR4", Price: 20, Quantity: 300, Timestamp: base.Add(10 * time.Second)}}
+	shardA := mockShard(t, query.Page{Transactions: a}, nil)
+	shardB := mockShard(t, query.Page{Transactions: b}, nil)
+	shardC := mockShard(t, query.Page{Transactions: a[:1]}, nil) // Cópia de a1 já migrada
+
+	var err error
+	router, err = ring.NewPartitionedRouter([]ring.Partition{{Primary: shardA}, {Primary: shardB}}, ring.KeyByID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregateVia := func() AggregateResponse {
+		client, server := net.Pipe()
+		defer client.Close()
+		go handleAggregate(server, protocol.NewMessage(protocol.MsgReqAggregate, aggregate.Request{Query: query.Query{Symbol: "PETR4"}, Bucket: time.Minute}))
+		var msg protocol.Message
+		if err := protocol.ReceiveJSON(client, &msg); err != nil {
+			t.Fatal(err)
+		}
+		var resp AggregateResponse
+		json.Unmarshal(msg.Payload, &resp)
+		return resp
+	}
+
+	for _, pushdown := range []bool{true, false} {
+		if !pushdown {
+			next := []ring.Partition{{Primary: shardA}, {Primary: shardB}, {Primary: shardC}}
+			if err := router.Begin(next); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp := aggregateVia()
+		if resp.Pushdown != pushdown || len(resp.Errors) != 0 {
+			t.Fatalf("Esperava pushdown=%v sem erros, recebido %+v", pushdown, resp)
+		}
+		if len(resp.Buckets) != 2 {
+			t.Fatalf("Esperava 2 faixas de 1m, recebido %+v", resp.Buckets)
+		}
+		first := resp.Buckets[0]
+		if first.Count != 2 || first.Quantity != 400 || first.VWAP != 17.5 || first.MinPrice != 10 || first.MaxPrice != 20 {
+			t.Errorf("Primeira faixa incorreta (pushdown=%v): %+v", pushdown, first)
+		}
+		if second := resp.Buckets[1]; second.Count != 1 || !second.Start.Equal(base.Add(time.Minute)) {
+			t.Errorf("Segunda faixa incorreta (pushdown=%v): %+v", pushdown, second)
+		}
+	}
+}
+
+// compactedShard responde REQ_HIST com txs e recusa os pedidos de agregação
+// que conferem as transações brutas, como um shard com histórico compactado.
+func compactedShard(t *testing.T, txs []model.Transaction) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var req protocol.Message
+				if err := protocol.ReceiveJSON(conn, &req); err != nil {
+					return
+				}
+				if req.Type == protocol.MsgReqAggregate {
+					protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "compacted history of PETR4 is only in the rollups"))
+					return
+				}
+				protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespHistory, query.Page{Transactions: txs}))
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestHandleAggregate_ReshardReportsCompactedHistory valida que, durante uma
+// migração, uma partição com histórico compactado entra como erro em vez de
+// só com as transações brutas, e que a recusa não abre o breaker do nó.
+func TestHandleAggregate_ReshardReportsCompactedHistory(t *testing.T) {
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	a := []model.Transaction{{ID: "a1", Symbol: "PETR4", Price: 10, Quantity: 100, Timestamp: base}}
+	old := []model.Transaction{{ID: "o1", Symbol: "PETR4", Price: 20, Quantity: 100, Timestamp: base}}
+	shardA := mockShard(t, query.Page{Transactions: a}, nil)
+	shardOld := compactedShard(t, old)
+	shardC := mockShard(t, query.Page{}, nil)
+
+	var err error
+	router, err = ring.NewPartitionedRouter([]ring.Partition{{Primary: shardA}, {Primary: shardOld}}, ring.KeyByID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Begin([]ring.Partition{{Primary: shardA}, {Primary: shardOld}, {Primary: shardC}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		client, server := net.Pipe()
+		go handleAggregate(server, protocol.NewMessage(protocol.MsgReqAggregate, aggregate.Request{Query: query.Query{Symbol: "PETR4"}}))
+		var msg protocol.Message
+		if err := protocol.ReceiveJSON(client, &msg); err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+		var resp AggregateResponse
+		json.Unmarshal(msg.Payload, &resp)
+		if resp.Pushdown || len(resp.Errors) != 1 || !strings.Contains(resp.Errors[0], shardOld) || !strings.Contains(resp.Errors[0], "compacted") {
+			t.Fatalf("Esperava um erro de histórico compactado de %s, recebido %+v", shardOld, resp)
+		}
+		if len(resp.Buckets) != 1 || resp.Buckets[0].Count != 1 {
+			t.Errorf("Esperava só a transação de a no agregado, recebido %+v", resp.Buckets)
+		}
+	}
+	if state := breakers.Get(shardBreakerName(shardOld)).State(); state != circuitbreaker.StateClosed {
+		t.Errorf("Esperava o breaker de %s fechado após as recusas, recebido %v", shardOld, state)
+	}
+}
+
+// historyShard responde REQ_HIST avaliando a consulta sobre txs, como um shard de verdade.
+func historyShard(t *testing.T, txs []model.Transaction) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				var req protocol.Message
+				if err := protocol.ReceiveJSON(conn, &req); err != nil {
+					return
+				}
+				var q query.Query
+				json.Unmarshal(req.Payload, &q)
+				page, err := query.Run(func(fn func(model.Transaction) bool) {
+					for _, tx := range txs {
+						if !fn(tx) {
+							return
+						}
+					}
+				}, q)
+				if err != nil {
+					protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+					return
+				}
+				protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespHistory, page))
+			}(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestHandleReport_GlobalOrderLimitAndCursor valida que o relatório devolve as
+// últimas N transações do cluster inteiro, em ordem, e que o cursor percorre o
+// histórico sem buracos nem repetições.
+func TestHandleReport_GlobalOrderLimitAndCursor(t *testing.T) {
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	var all []model.Transaction
+	shardTxs := make([][]model.Transaction, 3)
+	for i := 0; i < 250; i++ {
+		tx := model.Transaction{ID: fmt.Sprintf("tx-%03d", i), Symbol: "PETR4", Price: 25, Quantity: 1, Timestamp: base.Add(time.Duration(i) * time.Second)}
+		all = append(all, tx)
+		// Distribuição desigual: o shard 0 tem as mais recentes
+		s := 0
+		if i < 200 {
+			s = 1 + i%2
+		}
+		shardTxs[s] = append(shardTxs[s], tx)
+	}
+	var parts []ring.Partition
+	for _, txs := range shardTxs {
+		parts = append(parts, ring.Partition{Primary: historyShard(t, txs)})
+	}
+	var err error
+	router, err = ring.NewPartitionedRouter(parts, ring.KeyByID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := func(req protocol.ReportRequest) AggregatedResponse {
+		client, server := net.Pipe()
+		defer client.Close()
+		go func() {
+			defer server.Close()
+			handleReport(server, req)
+		}()
+		var resp AggregatedResponse
+		if err := json.NewDecoder(client).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := report(protocol.ReportRequest{Symbol: "PETR4", Limit: 100})
+	if len(resp.History) != 100 || !resp.HasMore || resp.NextCursor == "" {
+		t.Fatalf("Esperava 100 transações e mais páginas, recebido %d (has_more=%v)", len(resp.History), resp.HasMore)
+	}
+	for i, tx := range resp.History {
+		if want := all[249-i].ID; tx.ID != want {
+			t.Fatalf("Posição %d: esperava %s (últimas 100 do cluster), recebido %s", i, want, tx.ID)
+		}
+	}
+
+	// Paginando em ordem crescente, cada transação aparece exatamente uma vez
+	var ids []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("Paginação não terminou")
+		}
+		resp := report(protocol.ReportRequest{Symbol: "PETR4", Limit: 60, Order: query.OrderAsc, Cursor: cursor})
+		for _, tx := range resp.History {
+			ids = append(ids, tx.ID)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	if len(ids) != len(all) {
+		t.Fatalf("Esperava %d transações paginando, recebido %d", len(all), len(ids))
+	}
+	for i, id := range ids {
+		if id != all[i].ID {
+			t.Fatalf("Posição %d: esperava %s, recebido %s", i, all[i].ID, id)
+		}
+	}
+
+	if resp := report(protocol.ReportRequest{Order: "sideways"}); len(resp.Errors) == 0 || len(resp.History) != 0 {
+		t.Errorf("Ordem inválida deveria ser rejeitada, recebido %+v", resp)
+	}
+}
+
+// TestHandleClient_BareConnectGetsDefaultReport valida a compatibilidade com
+// clientes antigos: quem só conecta, sem enviar pedido, recebe o relatório padrão.
+func TestHandleClient_BareConnectGetsDefaultReport(t *testing.T) {
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	var txs []model.Transaction
+	for i := 0; i < 3; i++ {
+		txs = append(txs, model.Transaction{ID: fmt.Sprintf("tx-%d", i), Symbol: "PETR4", Price: 25, Quantity: 1, Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	var err error
+	router, err = ring.NewPartitionedRouter([]ring.Partition{{Primary: historyShard(t, txs)}}, ring.KeyByID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go handleClient(server)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp AggregatedResponse
+	if err := json.NewDecoder(client).Decode(&resp); err != nil {
+		t.Fatalf("Esperava o relatório padrão sem enviar pedido, recebido erro %v", err)
+	}
+	if len(resp.History) != len(txs) || resp.History[0].ID != "tx-2" {
+		t.Errorf("Esperava as %d transações do shard, mais recente primeiro, recebido %+v", len(txs), resp.History)
+	}
+}
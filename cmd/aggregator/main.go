@@ -1,29 +1,96 @@
 package main
 
 import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/circuitbreaker"
 	"distributed-system/pkg/model"
 	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/quorum"
+	"distributed-system/pkg/ring"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"time"
 )
 
 // Configuração
-var shards = []string{"localhost:9001", "localhost:9002", "localhost:9003"}
+var (
+	shardList = flag.String("shards", "localhost:9001,localhost:9002,localhost:9003", "Comma-separated history shards, each as primary|replica|... (must match the core)")
+	routeBy   = flag.String("route-by", ring.KeyBySymbol, "Shard routing key: symbol or id (must match the core)")
+
+	replication = flag.String("replication", "primary", "Shard replication mode: primary (read with failover) or quorum (read R nodes and repair)")
+	readQuorum  = flag.Int("r", 2, "Read responses required with -replication=quorum")
+)
+
+// router decide quais shards consultar: com particionamento por símbolo, um
+// relatório de um único símbolo vai apenas ao shard dono. Também liga cada
+// primário às suas réplicas, usadas quando ele não responde.
+var router *ring.Router
+
 const (
 	coreAddr       = "localhost:8082"
 	requestTimeout = 2 * time.Second // Timeout rigoroso para evitar travamentos
+
+	// Clientes antigos só conectam e esperam o relatório, sem enviar mensagem:
+	// sem pedido dentro deste prazo, a conexão recebe o relatório padrão.
+	bareConnectWait = 500 * time.Millisecond
 )
 
+// Um breaker por shard: um nó com problema deixa de ser consultado até se recuperar
+// (ou até o operador reabrir/fechar o breaker via MsgAdminBreaker).
+var breakers = circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 3, ResetTimeout: 10 * time.Second})
+
 type AggregatedResponse struct {
 	CurrentPrice model.Quote         `json:"current_price"`
 	History      []model.Transaction `json:"history"`
+	HasMore      bool                `json:"has_more,omitempty"`    // Há transações além do limite
+	NextCursor   string              `json:"next_cursor,omitempty"` // Cursor da próxima página do relatório
 	Errors       []string            `json:"errors,omitempty"`
 }
 
+// AggregateResponse é o payload de MsgRespAggregate devolvido ao cliente.
+// Pushdown indica que os shards agregaram localmente; durante uma migração de
+// topologia as transações são lidas e agregadas aqui para não contar em dobro
+// as que ainda estão no dono antigo e no novo.
+type AggregateResponse struct {
+	Buckets  []aggregate.Partial `json:"buckets"`
+	Pushdown bool                `json:"pushdown"`
+	Errors   []string            `json:"errors,omitempty"`
+}
+
 func main() {
+	flag.Parse()
+
+	parts, err := ring.ParsePartitions(*shardList)
+	if err != nil {
+		panic(err)
+	}
+	router, err = ring.NewPartitionedRouter(parts, *routeBy)
+	if err != nil {
+		panic(err)
+	}
+	// Breakers registrados já na partida: o operador pode tirar um shard de rotação antes da primeira consulta
+	for _, p := range parts {
+		for _, node := range p.Nodes() {
+			breakers.Get(shardBreakerName(node))
+		}
+	}
+	switch *replication {
+	case "primary":
+	case "quorum":
+		for _, p := range parts {
+			if err := quorum.Validate(len(p.Nodes()), 1, *readQuorum); err != nil {
+				panic(fmt.Sprintf("partition %s: %v", p.Primary, err))
+			}
+		}
+	default:
+		panic(fmt.Sprintf("unknown replication mode %q", *replication))
+	}
+
 	listener, err := net.Listen("tcp", ":8000")
 	if err != nil {
 		panic(err)
@@ -41,6 +108,90 @@ func main() {
 
 func handleClient(conn net.Conn) {
 	defer conn.Close()
+
+	// A conexão começa com uma mensagem dizendo o que o cliente quer; sem ela
+	// (cliente antigo), vale o relatório padrão de antes do protocolo de pedidos
+	conn.SetReadDeadline(time.Now().Add(bareConnectWait))
+	var req protocol.Message
+	if err := protocol.ReceiveJSON(conn, &req); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			conn.SetReadDeadline(time.Time{})
+			handleReport(conn, protocol.ReportRequest{})
+			return
+		}
+		fmt.Println("Error reading client request:", err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	switch req.Type {
+	case protocol.MsgAdminBreaker:
+		handleAdmin(conn, req)
+	case protocol.MsgAdminTopology:
+		handleTopology(conn, req)
+	case protocol.MsgReqAggregate:
+		handleAggregate(conn, req)
+	case protocol.MsgReqReport:
+		var reportReq protocol.ReportRequest
+		json.Unmarshal(req.Payload, &reportReq)
+		handleReport(conn, reportReq)
+	default:
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, fmt.Sprintf("unknown request type %q", req.Type)))
+	}
+}
+
+// handleAdmin aplica um comando de operador em um dos breakers de shard.
+func handleAdmin(conn net.Conn, msg protocol.Message) {
+	var cmd protocol.BreakerCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+
+	statuses, err := breakers.Apply(cmd.Action, cmd.Name)
+	if err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+	fmt.Printf("Admin command %q applied to breaker %q\n", cmd.Action, cmd.Name)
+	protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAdmin, statuses))
+}
+
+// handleTopology consulta ou muda a topologia dos shards consultados. Durante
+// uma migração, relatórios consultam os donos atual e de destino.
+func handleTopology(conn net.Conn, msg protocol.Message) {
+	var cmd protocol.TopologyCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+	if cmd.Action == ring.ActionBegin && *replication == "quorum" {
+		next, err := ring.ParsePartitions(cmd.Shards)
+		if err == nil {
+			for _, p := range next {
+				if err = quorum.Validate(len(p.Nodes()), 1, *readQuorum); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+	}
+
+	status, err := router.Apply(cmd.Action, cmd.Shards)
+	if err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+	if cmd.Action != ring.ActionShow {
+		fmt.Printf("Topology %s: shards=%s next=%s\n", cmd.Action, status.Shards, status.Next)
+	}
+	protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAdmin, status))
+}
+
+func handleReport(conn net.Conn, req protocol.ReportRequest) {
 	fmt.Println("Received client request, starting Scatter/Gather...")
 
 	start := time.Now()
@@ -53,7 +204,7 @@ func handleClient(conn net.Conn) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		quote, err := getQuoteFromCore()
+		quote, err := getQuoteFromCore(req.Symbol)
 		mu.Lock()
 		if err != nil {
 			// Falha parcial aceitável
@@ -66,28 +217,50 @@ func handleClient(conn net.Conn) {
 		mu.Unlock()
 	}()
 
-	// 2. Scatter: Shards de Histórico
-	for _, shardAddr := range shards {
-		wg.Add(1)
-		go func(addr string) {
-			defer wg.Done()
-			txs, err := getHistoryFromShard(addr)
-			mu.Lock()
+	// 2. Scatter: Shards de Histórico (apenas os que podem ter o símbolo).
+	// Os filtros são avaliados nos shards; cada um devolve as suas transações já
+	// ordenadas e o merge por (Timestamp, ID) monta a página global, pedindo mais
+	// páginas a um shard só quando as anteriores dele entraram no relatório.
+	if req.Order == "" {
+		req.Order = query.OrderDesc
+	}
+	q, err := query.Query{Symbol: req.Symbol, From: req.From, To: req.To, Order: req.Order, Limit: req.Limit, Cursor: req.Cursor}.Normalize()
+	if err != nil {
+		mu.Lock()
+		resp.Errors = append(resp.Errors, fmt.Sprintf("Query: %v", err))
+		mu.Unlock()
+	} else {
+		addrs := router.ShardsFor(req.Symbol)
+		sources := make([]query.Source, len(addrs))
+		for i, addr := range addrs {
+			p := router.Partition(addr)
+			sources[i] = func(cursor string) (query.Page, error) {
+				qq := q
+				qq.Cursor = cursor
+				if *replication == "quorum" {
+					return quorumQuery(p, qq, req.R)
+				}
+				return queryPartition(p, qq)
+			}
+		}
+		page, errs := query.Merge(q, sources)
+		mu.Lock()
+		for i, err := range errs {
 			if err != nil {
 				// Falha parcial aceitável
-				errMsg := fmt.Sprintf("Shard(%s): %v", addr, err)
+				errMsg := fmt.Sprintf("Shard(%s): %v", addrs[i], err)
 				fmt.Println("Error fetching from Shard:", errMsg)
 				resp.Errors = append(resp.Errors, errMsg)
-			} else {
-				resp.History = append(resp.History, txs...)
 			}
-			mu.Unlock()
-		}(shardAddr)
+		}
+		resp.History = page.Transactions
+		resp.NextCursor = page.NextCursor
+		resp.HasMore = page.NextCursor != ""
+		mu.Unlock()
 	}
 
-	// 3. Gather: Aguardar todos
+	// 3. Gather: Aguardar o Core
 	wg.Wait()
-	
 	duration := time.Since(start)
 	fmt.Printf("Scatter/Gather finished in %v. Errors: %d\n", duration, len(resp.Errors))
 
@@ -97,7 +270,235 @@ func handleClient(conn net.Conn) {
 	}
 }
 
-func getQuoteFromCore() (model.Quote, error) {
+// handleAggregate espalha o pedido de agregação pelos shards que podem ter o
+// símbolo e junta os parciais em um resultado global.
+//
+// Durante um reshard a agregação é feita aqui, sobre as transações brutas,
+// para não contar duas vezes as que estão no dono antigo e no novo. O
+// histórico compactado pela retenção não está nelas (só nos agregados do
+// primário, que o reshard não move), então uma partição com histórico
+// compactado no período entra como erro, e não como um agregado parcial.
+func handleAggregate(conn net.Conn, msg protocol.Message) {
+	var req aggregate.Request
+	if err := json.Unmarshal(msg.Payload, &req); err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+
+	start := time.Now()
+	_, next := router.Topology()
+	resp := AggregateResponse{Pushdown: next == nil}
+	acc := aggregate.NewAccumulator(req.Bucket)
+	var raw []model.Transaction
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, shardAddr := range router.ShardsFor(req.Query.Symbol) {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			var partials []aggregate.Partial
+			var txs []model.Transaction
+			var err error
+			if resp.Pushdown {
+				partials, err = aggregatePartition(router.Partition(addr), req)
+			} else {
+				check := req
+				check.CheckRaw = true
+				if _, err = aggregatePartition(router.Partition(addr), check); err == nil {
+					txs, err = scanPartition(router.Partition(addr), req.Query)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errMsg := fmt.Sprintf("Shard(%s): %v", addr, err)
+				fmt.Println("Error aggregating on Shard:", errMsg)
+				resp.Errors = append(resp.Errors, errMsg)
+				return
+			}
+			for _, p := range partials {
+				acc.Merge(p)
+			}
+			raw = append(raw, txs...)
+		}(shardAddr)
+	}
+	wg.Wait()
+
+	for _, tx := range latestVersions(raw) {
+		acc.Add(tx)
+	}
+	resp.Buckets = acc.Partials()
+	fmt.Printf("Aggregation finished in %v (pushdown: %v). Buckets: %d, errors: %d\n", time.Since(start), resp.Pushdown, len(resp.Buckets), len(resp.Errors))
+	protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAggregate, resp))
+}
+
+// aggregatePartition pede os parciais ao primário da partição e, se ele
+// falhar, a cada réplica em ordem. No modo quorum os nós convergem pela
+// anti-entropia, então um nó por partição basta para agregados. Uma réplica
+// não tem os agregados da retenção e recusa períodos com histórico
+// compactado, então nesse caso a partição falha em vez de ficar incompleta.
+func aggregatePartition(p ring.Partition, req aggregate.Request) ([]aggregate.Partial, error) {
+	var errs []string
+	for _, node := range p.Nodes() {
+		var refused error // O nó respondeu recusando o pedido: não conta como falha no breaker
+		result, err := breakers.Get(shardBreakerName(node)).Execute(func() (interface{}, error) {
+			partials, err := aggregateShard(node, req)
+			if _, ok := err.(shardRefusal); ok {
+				refused = err
+				return nil, nil
+			}
+			return partials, err
+		})
+		if err == nil && refused == nil {
+			return result.([]aggregate.Partial), nil
+		}
+		if refused != nil {
+			err = refused
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", node, err))
+	}
+	return nil, fmt.Errorf("all nodes failed (%s)", strings.Join(errs, "; "))
+}
+
+// shardRefusal é um erro devolvido pelo shard (MsgError) em vez de uma falha de rede.
+type shardRefusal string
+
+func (e shardRefusal) Error() string { return string(e) }
+
+// scanPartition lê todas as páginas da partição que passam pelos filtros.
+func scanPartition(p ring.Partition, q query.Query) ([]model.Transaction, error) {
+	q.Order, q.Limit, q.Cursor = query.OrderAsc, query.MaxLimit, ""
+	var txs []model.Transaction
+	for {
+		page, err := queryPartition(p, q)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, page.Transactions...)
+		if page.NextCursor == "" {
+			return txs, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// latestVersions deixa uma cópia de cada transação, a que prevalece. Durante
+// uma migração a mesma transação pode vir do dono antigo e do novo.
+func latestVersions(txs []model.Transaction) []model.Transaction {
+	pos := make(map[string]int, len(txs))
+	out := txs[:0]
+	for _, tx := range txs {
+		if i, ok := pos[tx.ID]; ok {
+			if model.Supersedes(tx, out[i]) {
+				out[i] = tx
+			}
+			continue
+		}
+		pos[tx.ID] = len(out)
+		out = append(out, tx)
+	}
+	return out
+}
+
+// queryPartition consulta o primário da partição e, se ele falhar (ou estiver
+// com o breaker aberto), cada réplica em ordem. Réplicas podem estar um pouco
+// atrasadas, mas um nó fora do ar não significa mais histórico faltando.
+func queryPartition(p ring.Partition, q query.Query) (query.Page, error) {
+	var errs []string
+	for _, node := range p.Nodes() {
+		result, err := breakers.Get(shardBreakerName(node)).Execute(func() (interface{}, error) {
+			return queryShard(node, q)
+		})
+		if err == nil {
+			if node != p.Primary {
+				fmt.Printf("Shard(%s) unavailable, history served by replica %s\n", p.Primary, node)
+			}
+			return result.(query.Page), nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", node, err))
+	}
+	return query.Page{}, fmt.Errorf("all nodes failed (%s)", strings.Join(errs, "; "))
+}
+
+// quorumQuery consulta os nós da partição e usa as R primeiras respostas: de
+// cada transação fica a versão que prevalece, e os nós que devolveram uma versão
+// antiga (ou nenhuma) recebem a atual em segundo plano (reparo de leitura).
+func quorumQuery(p ring.Partition, q query.Query, r int) (query.Page, error) {
+	nodes := p.Nodes()
+	if r == 0 {
+		r = *readQuorum
+	}
+	if err := quorum.Validate(len(nodes), 1, r); err != nil {
+		return query.Page{}, err
+	}
+	q, err := q.Normalize()
+	if err != nil {
+		return query.Page{}, err
+	}
+
+	responses, err := quorum.Read(nodes, r, func(node string) (query.Page, error) {
+		result, err := breakers.Get(shardBreakerName(node)).Execute(func() (interface{}, error) {
+			return queryShard(node, q)
+		})
+		if err != nil {
+			return query.Page{}, err
+		}
+		return result.(query.Page), nil
+	})
+	if err != nil {
+		return query.Page{}, err
+	}
+
+	page, repairs := quorum.Merge(responses, q)
+	for node, txs := range repairs {
+		go repairNode(node, txs)
+	}
+	return page, nil
+}
+
+// repairNode regrava no nó as versões atuais das transações que ele não tinha.
+func repairNode(addr string, txs []model.Transaction) {
+	repaired := 0
+	for _, tx := range txs {
+		if err := storeOnShard(addr, tx); err != nil {
+			fmt.Printf("Read repair of %s on %s failed: %v\n", tx.ID, addr, err)
+			return
+		}
+		repaired++
+	}
+	fmt.Printf("Read repair: %d transactions written to %s\n", repaired, addr)
+}
+
+func storeOnShard(addr string, tx model.Transaction) error {
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgStoreTx, tx)); err != nil {
+		return err
+	}
+	var msg protocol.Message
+	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		return err
+	}
+	if msg.Type == protocol.MsgError {
+		return fmt.Errorf("%s", msg.Payload)
+	}
+	return nil
+}
+
+func shardBreakerName(addr string) string {
+	return "shard:" + addr
+}
+
+func getQuoteFromCore(symbol string) (model.Quote, error) {
 	// Usar DialTimeout para evitar hang na conexão inicial (TCP handshake)
 	conn, err := net.DialTimeout("tcp", coreAddr, requestTimeout)
 	if err != nil {
@@ -108,7 +509,7 @@ func getQuoteFromCore() (model.Quote, error) {
 	// Definir Deadline total para a operação (escrita + leitura)
 	conn.SetDeadline(time.Now().Add(requestTimeout))
 
-	req := protocol.NewMessage(protocol.MsgRequestQuote, nil)
+	req := protocol.NewMessage(protocol.MsgRequestQuote, protocol.QuoteRequest{Symbol: symbol})
 	if err := protocol.SendJSON(conn, req); err != nil {
 		return model.Quote{}, err
 	}
@@ -119,7 +520,7 @@ func getQuoteFromCore() (model.Quote, error) {
 	}
 	
 	if msg.Type == protocol.MsgError {
-		return model.Quote{}, fmt.Errorf(string(msg.Payload))
+		return model.Quote{}, fmt.Errorf("%s", msg.Payload)
 	}
 
 	var quote model.Quote
@@ -129,30 +530,61 @@ func getQuoteFromCore() (model.Quote, error) {
 	return quote, nil
 }
 
-func getHistoryFromShard(addr string) ([]model.Transaction, error) {
-	// Usar DialTimeout
+// aggregateShard pede ao shard os agregados parciais do pedido.
+func aggregateShard(addr string, req aggregate.Request) ([]aggregate.Partial, error) {
 	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReqAggregate, req)); err != nil {
+		return nil, err
+	}
+	var msg protocol.Message
+	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Type == protocol.MsgError {
+		return nil, shardRefusal(msg.Payload)
+	}
+
+	var partials []aggregate.Partial
+	if err := json.Unmarshal(msg.Payload, &partials); err != nil {
+		return nil, err
+	}
+	return partials, nil
+}
+
+// queryShard pede ao shard uma página do histórico filtrada por q.
+func queryShard(addr string, q query.Query) (query.Page, error) {
+	// Usar DialTimeout
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return query.Page{}, err
+	}
+	defer conn.Close()
 
 	// Definir Deadline
 	conn.SetDeadline(time.Now().Add(requestTimeout))
 
-	req := protocol.NewMessage(protocol.MsgReqHistory, nil)
+	req := protocol.NewMessage(protocol.MsgReqHistory, q)
 	if err := protocol.SendJSON(conn, req); err != nil {
-		return nil, err
+		return query.Page{}, err
 	}
 
 	var msg protocol.Message
 	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
-		return nil, err
+		return query.Page{}, err
+	}
+	if msg.Type == protocol.MsgError {
+		return query.Page{}, fmt.Errorf("%s", msg.Payload)
 	}
 
-	var txs []model.Transaction
-	if err := json.Unmarshal(msg.Payload, &txs); err != nil {
-		return nil, err
+	var page query.Page
+	if err := json.Unmarshal(msg.Payload, &page); err != nil {
+		return query.Page{}, err
 	}
-	return txs, nil
-}
\ No newline at end of file
+	return page, nil
+}
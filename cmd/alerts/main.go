@@ -0,0 +1,113 @@
+package main
+
+import (
+	"distributed-system/pkg/alerts"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/pubsub"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+)
+
+var (
+	port       = flag.String("port", "8085", "Port for rule registration")
+	brokerAddr = flag.String("broker", "localhost:8081", "Broker address")
+	rulesFile  = flag.String("rules", "alerts.json", "File where alert rules are persisted")
+)
+
+func main() {
+	flag.Parse()
+
+	engine, err := alerts.NewEngine(*rulesFile)
+	if err != nil {
+		panic(err)
+	}
+	publisher := pubsub.NewPublisher(*brokerAddr)
+
+	// Assinar os tópicos dos símbolos que têm regras (novas regras assinam sob demanda)
+	subscriber := pubsub.NewSubscriber(*brokerAddr, engine.Symbols(), func(msg protocol.Message) {
+		var quote model.Quote
+		if err := json.Unmarshal(msg.Payload, &quote); err != nil || quote.Symbol == "" {
+			return
+		}
+		for _, alert := range engine.Evaluate(quote) {
+			payload, _ := json.Marshal(alert)
+			if err := publisher.Publish(alertTopic(alert.User), payload); err != nil {
+				fmt.Println("Error delivering alert:", err)
+				continue
+			}
+			fmt.Printf("Alert %s fired for %s: %s\n", alert.RuleID, alert.User, alert.Message)
+		}
+	})
+	go subscriber.Run()
+
+	listener, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Alert Service running on :%s with %d rules\n", *port, len(engine.List("")))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			continue
+		}
+		go handleRequest(conn, engine, subscriber)
+	}
+}
+
+// alertTopic é o tópico onde o usuário recebe seus alertas.
+func alertTopic(user string) string {
+	return "alerts." + user
+}
+
+func handleRequest(conn net.Conn, engine *alerts.Engine, subscriber *pubsub.Subscriber) {
+	defer conn.Close()
+
+	var msg protocol.Message
+	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		return
+	}
+
+	switch msg.Type {
+	case protocol.MsgAlertRegister:
+		var rule model.AlertRule
+		if err := json.Unmarshal(msg.Payload, &rule); err != nil {
+			sendError(conn, err)
+			return
+		}
+		rule, err := engine.Register(rule)
+		if err != nil {
+			sendError(conn, err)
+			return
+		}
+		if err := subscriber.Subscribe(rule.Symbol); err != nil {
+			fmt.Println("Error subscribing to", rule.Symbol, err)
+		}
+		fmt.Printf("Registered rule %s (%s %s)\n", rule.ID, rule.Kind, rule.Symbol)
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAlerts, []model.AlertRule{rule}))
+
+	case protocol.MsgAlertList, protocol.MsgAlertDelete:
+		var query protocol.AlertQuery
+		if err := json.Unmarshal(msg.Payload, &query); err != nil {
+			sendError(conn, err)
+			return
+		}
+		if msg.Type == protocol.MsgAlertDelete {
+			if err := engine.Delete(query.User, query.ID); err != nil {
+				sendError(conn, err)
+				return
+			}
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAlerts, engine.List(query.User)))
+
+	default:
+		sendError(conn, fmt.Errorf("unknown request type %q", msg.Type))
+	}
+}
+
+func sendError(conn net.Conn, err error) {
+	protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+}
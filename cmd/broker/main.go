@@ -2,6 +2,7 @@ package main
 
 import (
 	"distributed-system/pkg/protocol"
+	"encoding/json"
 	"fmt"
 	"net"
 	"sync"
@@ -95,9 +96,12 @@ func handleClient(conn net.Conn, broker *Broker) {
 	// Fechar apenas no final da sessão
 	defer conn.Close()
 
+	// Um único decoder por conexão: publicadores (ex: Outbox do Core) enviam rajadas
+	// de mensagens, e um decoder novo a cada leitura perderia o que já foi bufferizado.
+	decoder := json.NewDecoder(conn)
 	for {
 		var msg protocol.Message
-		if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		if err := decoder.Decode(&msg); err != nil {
 			// Se não conseguirmos ler, o cliente se foi.
 			// Nota: Idealmente deveríamos limpar as inscrições aqui também se eles se inscreveram,
 			// mas para esta arquitetura simples, a falha de Escrita no Publish limpará eventualmente.
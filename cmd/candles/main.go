@@ -0,0 +1,65 @@
+package main
+
+import (
+	"distributed-system/pkg/candles"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/pubsub"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	brokerAddr = flag.String("broker", "localhost:8081", "Broker address")
+	symbols    = flag.String("symbols", "PETR4", "Comma-separated symbols to build candles for")
+	intervals  = flag.String("intervals", "1s,1m,5m", "Comma-separated candle intervals")
+	grace      = flag.Duration("grace", 2*time.Second, "How long to wait for late ticks after a window ends")
+)
+
+func main() {
+	flag.Parse()
+
+	var durations []time.Duration
+	for _, raw := range strings.Split(*intervals, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil || d < time.Second {
+			panic(fmt.Sprintf("invalid interval %q", raw))
+		}
+		durations = append(durations, d)
+	}
+	topics := strings.Split(*symbols, ",")
+
+	builder := candles.NewBuilder(durations, *grace)
+	publisher := pubsub.NewPublisher(*brokerAddr)
+
+	// Consumir o fluxo de cotações do Broker
+	subscriber := pubsub.NewSubscriber(*brokerAddr, topics, func(msg protocol.Message) {
+		var quote model.Quote
+		if err := json.Unmarshal(msg.Payload, &quote); err != nil || quote.Symbol == "" {
+			return
+		}
+		builder.Add(quote)
+	})
+	go subscriber.Run()
+
+	fmt.Printf("Candle Service building %v candles for %v\n", *intervals, topics)
+
+	// Finalizar janelas periodicamente e publicar nos tópicos derivados
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, candle := range builder.Flush(now) {
+			interval := candle.End.Sub(candle.Start)
+			payload, _ := json.Marshal(candle)
+			if err := publisher.Publish(candles.Topic(interval, candle.Symbol), payload); err != nil {
+				fmt.Println("Error publishing candle:", err)
+				continue
+			}
+			fmt.Printf("Published %s candle for %s (O:%.2f H:%.2f L:%.2f C:%.2f V:%d)\n",
+				candle.Interval, candle.Symbol, candle.Open, candle.High, candle.Low, candle.Close, candle.Volume)
+		}
+	}
+}
@@ -1,20 +1,65 @@
 package main
 
 import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/model"
 	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
+	"time"
+)
+
+var (
+	mode   = flag.String("mode", "aggregator", "Mode: 'aggregator', 'subscribe', 'breaker', 'metrics', 'indicators', 'alert', 'trade', 'history' or 'aggregate'")
+	target = flag.String("target", "localhost:8082", "Service address for admin/metrics/history commands (core :8082, aggregator :8000, shard :9001)")
+	action = flag.String("action", "list", "Breaker action: list, open, close or reset")
+	name   = flag.String("name", "", "Breaker name (e.g. external:localhost:8080, shard:localhost:9001)")
+	topic  = flag.String("topic", "PETR4", "Topic to subscribe to (e.g. PETR4, candles.1m.PETR4, quarantine.PETR4)")
+	symbol = flag.String("symbol", "PETR4", "Symbol for reports, indicator queries, alert rules and trades")
+
+	user      = flag.String("user", "", "User owning the alert rule")
+	kind      = flag.String("kind", "cross", "Alert kind: cross, cross_above, cross_below or move")
+	threshold = flag.Float64("threshold", 0, "Price threshold for cross alerts")
+	movePct   = flag.Float64("pct", 0, "Percent move for move alerts")
+	window    = flag.Int("window", 600, "Window in seconds for move alerts")
+
+	price    = flag.Float64("price", 0, "Trade price")
+	quantity = flag.Int("qty", 100, "Trade quantity")
+	writeQ   = flag.Int("w", 0, "Write quorum for trades when the core runs with -replication=quorum (0 uses the core default)")
+	idemKey  = flag.String("key", "", "Idempotency key for trades: resending with the same key returns the original transaction")
+
+	limit  = flag.Int("limit", 0, "Max history records per page, across all shards (0 uses the default)")
+	since  = flag.Duration("since", 0, "Only history newer than this, e.g. 1h (0 = all)")
+	order  = flag.String("order", "desc", "History order for reports and -mode=history: asc or desc")
+	cursor = flag.String("cursor", "", "Continuation cursor returned by a previous report or -mode=history page")
+	readQ  = flag.Int("r", 0, "Read quorum for reports when the aggregator runs with -replication=quorum (0 uses the aggregator default)")
+	bucket = flag.Duration("bucket", time.Minute, "Time bucket for -mode=aggregate, e.g. 1m or 1h (0 = whole period)")
 )
 
 func main() {
-	mode := flag.String("mode", "aggregator", "Mode: 'aggregator' or 'subscribe'")
 	flag.Parse()
 
-	if *mode == "subscribe" {
+	switch *mode {
+	case "subscribe":
 		runSubscriber()
-	} else {
+	case "breaker":
+		runBreakerAdmin()
+	case "metrics":
+		runMetrics()
+	case "indicators":
+		runIndicators()
+	case "alert":
+		runAlertRegister()
+	case "trade":
+		runTrade()
+	case "history":
+		runHistory()
+	case "aggregate":
+		runAggregate()
+	default:
 		runAggregatorClient()
 	}
 }
@@ -27,11 +72,11 @@ func runAggregatorClient() {
 	defer conn.Close()
 
 	fmt.Println("Requesting Aggregated Data...")
-	// Apenas conectar aciona o handler no Agregador (gatilho simples)
-	// Ou podemos enviar um byte fictício se a leitura bloquear.
-	// O Agregador espera a conexão, depois trata imediatamente. Espera, o Agregador lê?
-	// Na minha impl: Agregador aceita, então handleClient roda. handleClient envia resposta.
-	// Ele NÃO espera entrada. Então apenas conectar é suficiente.
+	// O Agregador espera uma mensagem dizendo o tipo de requisição
+	req := protocol.NewMessage(protocol.MsgReqReport, protocol.ReportRequest{Symbol: *symbol, From: sinceTime(), Limit: *limit, Order: *order, Cursor: *cursor, R: *readQ})
+	if err := protocol.SendJSON(conn, req); err != nil {
+		panic(err)
+	}
 
 	decoder := json.NewDecoder(conn)
 	var resp map[string]interface{} // Mapa genérico para imprimir bonito
@@ -52,13 +97,14 @@ func runSubscriber() {
 
 	// Inscrever-se
 	subMsg := protocol.NewMessage(protocol.MsgSubscribe, nil)
-	subMsg.Topic = "PETR4"
+	subMsg.Topic = *topic
 	protocol.SendJSON(conn, subMsg)
-	fmt.Println("Subscribed to PETR4. Waiting for updates...")
+	fmt.Printf("Subscribed to %s. Waiting for updates...\n", *topic)
 
+	decoder := json.NewDecoder(conn)
 	for {
 		var msg protocol.Message
-		if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		if err := decoder.Decode(&msg); err != nil {
 			fmt.Println("Connection closed")
 			return
 		}
@@ -67,3 +113,205 @@ func runSubscriber() {
 		}
 	}
 }
+
+// runBreakerAdmin envia um comando de operador para os breakers do Core ou do Agregador.
+func runBreakerAdmin() {
+	conn, err := net.Dial("tcp", *target)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	cmd := protocol.BreakerCommand{Action: *action, Name: *name}
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgAdminBreaker, cmd)); err != nil {
+		panic(err)
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		panic(err)
+	}
+	if resp.Type == protocol.MsgError {
+		fmt.Println("Error:", string(resp.Payload))
+		return
+	}
+
+	var statuses []map[string]interface{}
+	json.Unmarshal(resp.Payload, &statuses)
+	formatted, _ := json.MarshalIndent(statuses, "", "  ")
+	fmt.Println(string(formatted))
+}
+
+// runMetrics imprime os contadores expostos por um serviço.
+func runMetrics() {
+	conn, err := net.Dial("tcp", *target)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReqMetrics, nil)); err != nil {
+		panic(err)
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		panic(err)
+	}
+
+	var metrics map[string]interface{}
+	json.Unmarshal(resp.Payload, &metrics)
+	formatted, _ := json.MarshalIndent(metrics, "", "  ")
+	fmt.Println(string(formatted))
+}
+
+// runIndicators consulta os indicadores atuais de um símbolo.
+func runIndicators() {
+	conn, err := net.Dial("tcp", "localhost:8084")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	req := protocol.NewMessage(protocol.MsgReqIndicators, protocol.IndicatorRequest{Symbol: *symbol})
+	if err := protocol.SendJSON(conn, req); err != nil {
+		panic(err)
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		panic(err)
+	}
+	if resp.Type == protocol.MsgError {
+		fmt.Println("Error:", string(resp.Payload))
+		return
+	}
+
+	var snap map[string]interface{}
+	json.Unmarshal(resp.Payload, &snap)
+	formatted, _ := json.MarshalIndent(snap, "", "  ")
+	fmt.Println(string(formatted))
+}
+
+// runAlertRegister registra uma regra de alerta. Os disparos chegam em "alerts.<user>":
+// ./bin/client -mode=subscribe -topic=alerts.<user>
+func runAlertRegister() {
+	conn, err := net.Dial("tcp", "localhost:8085")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	rule := model.AlertRule{
+		User:          *user,
+		Symbol:        *symbol,
+		Kind:          *kind,
+		Threshold:     *threshold,
+		Hysteresis:    *threshold * 0.005, // 0.5% do limiar
+		MovePct:       *movePct,
+		WindowSeconds: *window,
+	}
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgAlertRegister, rule)); err != nil {
+		panic(err)
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		panic(err)
+	}
+	if resp.Type == protocol.MsgError {
+		fmt.Println("Error:", string(resp.Payload))
+		return
+	}
+	fmt.Printf("Registered: %s\n", string(resp.Payload))
+}
+
+// runTrade submete uma operação ao Core, que a valida e grava no shard dono do símbolo.
+func runTrade() {
+	conn, err := net.Dial("tcp", "localhost:8082")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	req := protocol.TradeRequest{Symbol: *symbol, Price: *price, Quantity: *quantity, W: *writeQ, IdempotencyKey: *idemKey}
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgSubmitTrade, req)); err != nil {
+		panic(err)
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		panic(err)
+	}
+	if resp.Type == protocol.MsgError {
+		fmt.Println("Trade rejected:", string(resp.Payload))
+		return
+	}
+	fmt.Println("Trade confirmed:", string(resp.Payload))
+}
+
+// runHistory consulta uma página do histórico diretamente em um shard.
+func runHistory() {
+	conn, err := net.Dial("tcp", *target)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	q := query.Query{Symbol: *symbol, From: sinceTime(), Order: *order, Limit: *limit, Cursor: *cursor}
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReqHistory, q)); err != nil {
+		panic(err)
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		panic(err)
+	}
+	if resp.Type == protocol.MsgError {
+		fmt.Println("Error:", string(resp.Payload))
+		return
+	}
+
+	var page query.Page
+	json.Unmarshal(resp.Payload, &page)
+	formatted, _ := json.MarshalIndent(page.Transactions, "", "  ")
+	fmt.Println(string(formatted))
+	if page.NextCursor != "" {
+		fmt.Printf("Next page: -cursor=%s\n", page.NextCursor)
+	}
+}
+
+// runAggregate pede ao Aggregator os agregados do símbolo por faixa de tempo.
+func runAggregate() {
+	conn, err := net.Dial("tcp", "localhost:8000")
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	req := aggregate.Request{Query: query.Query{Symbol: *symbol, From: sinceTime()}, Bucket: *bucket}
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReqAggregate, req)); err != nil {
+		panic(err)
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		panic(err)
+	}
+	if resp.Type == protocol.MsgError {
+		fmt.Println("Error:", string(resp.Payload))
+		return
+	}
+	var result map[string]interface{}
+	json.Unmarshal(resp.Payload, &result)
+	formatted, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(formatted))
+}
+
+// sinceTime converte -since no início do período consultado (zero = sem limite).
+func sinceTime() time.Time {
+	if *since <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-*since)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"distributed-system/pkg/model"
+	"sync"
+)
+
+// flightCall representa uma busca em andamento para um símbolo.
+type flightCall struct {
+	wg    sync.WaitGroup
+	quote model.Quote
+	err   error
+}
+
+// flightGroup deduplica buscas concorrentes: enquanto uma chamada para o símbolo
+// está em andamento, as demais esperam e recebem o mesmo resultado (e o mesmo
+// desfecho do Circuit Breaker), em vez de abrir outra conexão com o externo.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// Do executa fn uma única vez por chave em andamento. shared indica que o
+// chamador pegou carona na busca de outra goroutine.
+func (g *flightGroup) Do(key string, fn func() (model.Quote, error)) (quote model.Quote, err error, shared bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.quote, call.err, true
+	}
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.quote, call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	call.wg.Done()
+
+	return call.quote, call.err, false
+}
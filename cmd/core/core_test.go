@@ -0,0 +1,676 @@
+package main
+
+import (
+	"distributed-system/pkg/circuitbreaker"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/quorum"
+	"distributed-system/pkg/ring"
+	"distributed-system/pkg/storage"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestQuoteService cria um QuoteService com uma fonte externa simulada.
+func newTestQuoteService(ttl, maxStale time.Duration, fetch func(string) (model.Quote, error)) *QuoteService {
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 100, ResetTimeout: time.Second})
+	svc := NewQuoteService(breakers, QuoteConfig{Providers: []string{"primary"}, TTL: ttl, MaxStale: maxStale})
+	svc.fetch = func(addr, symbol string) (model.Quote, error) { return fetch(symbol) }
+	return svc
+}
+
+// TestQuoteCacheFreshHitSkipsExternal valida que um acerto dentro do TTL não chama o externo
+func TestQuoteCacheFreshHitSkipsExternal(t *testing.T) {
+	calls := 0
+	svc := newTestQuoteService(time.Minute, time.Minute, func(symbol string) (model.Quote, error) {
+		calls++
+		return model.Quote{Symbol: symbol, Price: 10, Timestamp: time.Now()}, nil
+	})
+
+	if _, fresh, err := svc.GetQuote("PETR4"); err != nil || !fresh {
+		t.Fatalf("Primeira chamada deveria ir ao externo: fresh=%v err=%v", fresh, err)
+	}
+	quote, fresh, err := svc.GetQuote("PETR4")
+	if err != nil || fresh || quote.Stale {
+		t.Fatalf("Segunda chamada deveria vir do cache fresco: %+v fresh=%v err=%v", quote, fresh, err)
+	}
+	if calls != 1 {
+		t.Errorf("Esperava 1 chamada ao externo, houve %d", calls)
+	}
+}
+
+// TestQuoteStaleFallback valida o fallback para a última cotação boa e o limite de idade
+func TestQuoteStaleFallback(t *testing.T) {
+	failing := false
+	svc := newTestQuoteService(0, 50*time.Millisecond, func(symbol string) (model.Quote, error) {
+		if failing {
+			return model.Quote{}, errors.New("external down")
+		}
+		return model.Quote{Symbol: symbol, Price: 25, Timestamp: time.Now()}, nil
+	})
+
+	if _, _, err := svc.GetQuote("PETR4"); err != nil {
+		t.Fatal(err)
+	}
+
+	failing = true
+	quote, fresh, err := svc.GetQuote("PETR4")
+	if err != nil {
+		t.Fatalf("Esperava cotação antiga, recebeu erro: %v", err)
+	}
+	if !quote.Stale || fresh || quote.Price != 25 {
+		t.Errorf("Cotação deveria estar marcada como antiga: %+v", quote)
+	}
+
+	// Passado o limite de idade, o erro original volta a ser propagado
+	time.Sleep(60 * time.Millisecond)
+	if _, _, err := svc.GetQuote("PETR4"); err == nil {
+		t.Error("Esperava erro após exceder max-stale")
+	}
+
+	// Símbolo nunca visto não tem fallback
+	if _, _, err := svc.GetQuote("VALE3"); err == nil {
+		t.Error("Esperava erro para símbolo sem cache")
+	}
+}
+
+// TestQuoteRequestCoalescing valida que pedidos concorrentes compartilham uma única busca externa
+func TestQuoteRequestCoalescing(t *testing.T) {
+	release := make(chan struct{})
+	var calls int64
+	svc := newTestQuoteService(time.Minute, time.Minute, func(symbol string) (model.Quote, error) {
+		calls++ // Protegido pelo próprio flightGroup: apenas uma busca por vez
+		<-release
+		return model.Quote{Symbol: symbol, Price: 30, Timestamp: time.Now()}, nil
+	})
+
+	const n = 10
+	var wg sync.WaitGroup
+	var freshCount int64
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, fresh, err := svc.GetQuote("PETR4"); err != nil {
+				t.Errorf("Erro inesperado: %v", err)
+			} else if fresh {
+				atomic.AddInt64(&freshCount, 1)
+			}
+		}()
+	}
+
+	// Aguardar todos chegarem antes de liberar a busca
+	for svc.metrics.QuoteRequests.Load() < n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Esperava 1 chamada ao externo, houve %d", calls)
+	}
+	if freshCount != 1 {
+		t.Errorf("Apenas o líder deveria publicar, %d marcaram fresh", freshCount)
+	}
+	// Quem chegou atrasado acerta o cache; o resto pegou carona
+	m := svc.metrics.Snapshot()
+	if m["coalesced"]+m["cache_hits"] != n-1 {
+		t.Errorf("Métricas inconsistentes: %v", m)
+	}
+}
+
+// TestProviderFailover valida que, com o breaker do primário aberto, a cotação vem do secundário
+func TestProviderFailover(t *testing.T) {
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 1, ResetTimeout: time.Minute})
+	svc := NewQuoteService(breakers, QuoteConfig{Providers: []string{"primary", "secondary"}})
+	var primaryCalls int64
+	svc.fetch = func(addr, symbol string) (model.Quote, error) {
+		if addr == "primary" {
+			atomic.AddInt64(&primaryCalls, 1)
+			return model.Quote{}, errors.New("primary down")
+		}
+		return model.Quote{Symbol: symbol, Price: 21, Timestamp: time.Now()}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		quote, _, err := svc.GetQuote("PETR4")
+		if err != nil {
+			t.Fatalf("Esperava failover para o secundário, recebeu erro: %v", err)
+		}
+		if quote.Provider != "secondary" {
+			t.Errorf("Cotação deveria indicar o provedor secundário, indica %q", quote.Provider)
+		}
+	}
+
+	// Depois da primeira falha o breaker do primário abre e ele deixa de ser chamado
+	if primaryCalls != 1 {
+		t.Errorf("Primário deveria ter sido chamado 1 vez, foi %d", primaryCalls)
+	}
+}
+
+// TestProviderHedging valida que um primário lento dispara o pedido de hedge ao secundário
+func TestProviderHedging(t *testing.T) {
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 3, ResetTimeout: time.Minute})
+	svc := NewQuoteService(breakers, QuoteConfig{
+		Providers:       []string{"primary", "secondary"},
+		Hedge:           true,
+		HedgePercentile: 95,
+		HedgeDelay:      20 * time.Millisecond,
+	})
+	svc.fetch = func(addr, symbol string) (model.Quote, error) {
+		if addr == "primary" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return model.Quote{Symbol: symbol, Price: 22, Timestamp: time.Now()}, nil
+	}
+
+	start := time.Now()
+	quote, _, err := svc.GetQuote("PETR4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Provider != "secondary" {
+		t.Errorf("Esperava resposta do secundário via hedge, veio de %q", quote.Provider)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("Hedge deveria evitar esperar o primário lento, levou %v", elapsed)
+	}
+	if svc.metrics.Hedged.Load() != 1 {
+		t.Errorf("Esperava 1 hedge, houve %d", svc.metrics.Hedged.Load())
+	}
+}
+
+// TestPollerPublishesOnlyChanges valida que o publicador contínuo publica apenas quando o preço muda
+func TestPollerPublishesOnlyChanges(t *testing.T) {
+	targets, err := ParsePollTargets("PETR4:5ms")
+	if err != nil || len(targets) != 1 || targets[0].Interval != 5*time.Millisecond {
+		t.Fatalf("Parse inesperado: %+v err=%v", targets, err)
+	}
+	if _, err := ParsePollTargets("PETR4"); err == nil {
+		t.Error("Esperava erro para alvo sem intervalo")
+	}
+
+	var calls int64
+	svc := newTestQuoteService(0, 0, func(symbol string) (model.Quote, error) {
+		n := atomic.AddInt64(&calls, 1)
+		// Preço muda apenas a cada 3 consultas
+		return model.Quote{Symbol: symbol, Price: float64(20 + (n-1)/3), Timestamp: time.Now()}, nil
+	})
+
+	var mu sync.Mutex
+	var published []float64
+	poller := NewPoller(svc, targets, 0, time.Second, func(q model.Quote) {
+		mu.Lock()
+		published = append(published, q.Price)
+		mu.Unlock()
+	})
+	poller.Start()
+	time.Sleep(100 * time.Millisecond)
+	poller.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	polled := atomic.LoadInt64(&calls)
+	if polled < 6 || len(published) == 0 {
+		t.Fatalf("Esperava várias consultas e publicações, houve %d consultas e %d publicações", polled, len(published))
+	}
+	for i := 1; i < len(published); i++ {
+		if published[i] == published[i-1] {
+			t.Errorf("Preço repetido publicado: %v", published)
+		}
+	}
+}
+
+// TestPollerBacksOffWhileFailing valida que o intervalo aumenta enquanto o provedor falha
+func TestPollerBacksOffWhileFailing(t *testing.T) {
+	var calls int64
+	svc := newTestQuoteService(0, 0, func(symbol string) (model.Quote, error) {
+		atomic.AddInt64(&calls, 1)
+		return model.Quote{}, errors.New("external down")
+	})
+
+	poller := NewPoller(svc, []PollTarget{{Symbol: "PETR4", Interval: 5 * time.Millisecond}}, 0, time.Second, func(model.Quote) {
+		t.Error("Nada deveria ser publicado")
+	})
+	poller.Start()
+	time.Sleep(100 * time.Millisecond)
+	poller.Stop()
+
+	// Sem backoff seriam ~20 consultas; com intervalo dobrando (5, 10, 20, 40ms) são no máximo 4
+	if n := atomic.LoadInt64(&calls); n > 5 {
+		t.Errorf("Esperava backoff, houve %d consultas em 100ms", n)
+	}
+}
+
+// fakePublisher simula o Broker: falha enquanto failing > 0 e registra o que foi entregue.
+type fakePublisher struct {
+	mu        sync.Mutex
+	failing   int
+	delivered []string
+}
+
+func (f *fakePublisher) Publish(topic string, payload []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing != 0 {
+		if f.failing > 0 {
+			f.failing--
+		}
+		return errors.New("broker offline")
+	}
+	f.delivered = append(f.delivered, string(payload))
+	return nil
+}
+
+func newTestOutbox(t *testing.T, pub publisher, capacity int, journal string) *Outbox {
+	outbox, err := NewOutbox(pub, capacity, journal, &Metrics{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.minBackoff = time.Millisecond
+	outbox.maxBackoff = 5 * time.Millisecond
+	return outbox
+}
+
+// TestOutboxDeliversInOrderWithRetries valida entrega em ordem mesmo com falhas do Broker
+func TestOutboxDeliversInOrderWithRetries(t *testing.T) {
+	pub := &fakePublisher{failing: 3}
+	outbox := newTestOutbox(t, pub, 100, "")
+	outbox.Start()
+
+	for i := 0; i < 5; i++ {
+		outbox.Enqueue("PETR4", []byte(fmt.Sprintf("%d", i)))
+	}
+	if left := outbox.Flush(time.Second); left != 0 {
+		t.Fatalf("Esperava fila vazia após flush, restaram %d", left)
+	}
+
+	if got := strings.Join(pub.delivered, ","); got != "0,1,2,3,4" {
+		t.Errorf("Ordem de entrega incorreta: %s", got)
+	}
+	if err := outbox.Enqueue("PETR4", []byte("late")); err != errOutboxClosed {
+		t.Errorf("Outbox fechado deveria rejeitar mensagens, recebeu %v", err)
+	}
+}
+
+// TestOutboxOverflowAndJournal valida o descarte com fila cheia e a recuperação do journal em disco
+func TestOutboxOverflowAndJournal(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "outbox.jsonl")
+
+	down := &fakePublisher{failing: -1} // Broker fora do ar indefinidamente
+	outbox := newTestOutbox(t, down, 3, journal)
+	outbox.Start()
+	for i := 0; i < 5; i++ {
+		outbox.Enqueue("PETR4", []byte(fmt.Sprintf("%d", i)))
+	}
+	if overflow := outbox.metrics.OutboxOverflow.Load(); overflow != 2 {
+		t.Errorf("Esperava 2 descartes por overflow, houve %d", overflow)
+	}
+	if left := outbox.Flush(20 * time.Millisecond); left != 3 {
+		t.Fatalf("Esperava 3 mensagens pendentes, restaram %d", left)
+	}
+
+	// Reinício: as mensagens pendentes voltam do journal e são entregues em ordem
+	up := &fakePublisher{}
+	restarted := newTestOutbox(t, up, 3, journal)
+	restarted.Start()
+	if left := restarted.Flush(time.Second); left != 0 {
+		t.Fatalf("Esperava entregar as mensagens recuperadas, restaram %d", left)
+	}
+	if got := strings.Join(up.delivered, ","); got != "2,3,4" {
+		t.Errorf("Mensagens recuperadas incorretas: %s", got)
+	}
+}
+
+// TestValidatorRejectsBadTicks valida as regras de sanidade: valores impossíveis, saltos e banda móvel
+func TestValidatorRejectsBadTicks(t *testing.T) {
+	v := NewValidator(ValidationConfig{MaxJumpPct: 10, BandWindow: 20, BandK: 3, MaxSkew: time.Second})
+	now := time.Now()
+	quote := func(symbol string, price float64, ts time.Time) model.Quote {
+		return model.Quote{Symbol: symbol, Price: price, Timestamp: ts}
+	}
+
+	cases := []struct {
+		name  string
+		quote model.Quote
+	}{
+		{"preço zero", quote("PETR4", 0, now)},
+		{"símbolo errado", quote("VALE3", 25, now)},
+		{"timestamp no futuro", quote("PETR4", 25, now.Add(time.Hour))},
+		{"sem timestamp", quote("PETR4", 25, time.Time{})},
+	}
+	for _, c := range cases {
+		if err := v.Validate("PETR4", c.quote); err == nil {
+			t.Errorf("%s: esperava rejeição", c.name)
+		}
+	}
+
+	// Série estável em torno de 25
+	for _, p := range []float64{25, 25.2, 24.9, 25.1, 25, 24.8} {
+		if err := v.Validate("PETR4", quote("PETR4", p, now)); err != nil {
+			t.Fatalf("Preço %v deveria ser aceito: %v", p, err)
+		}
+	}
+
+	// Salto de 20% em relação ao último preço
+	if err := v.Validate("PETR4", quote("PETR4", 29.8, now)); err == nil {
+		t.Error("Esperava rejeição por salto acima de 10%")
+	}
+	// Dentro do limite de salto, mas muito fora da banda (desvio ~0.13)
+	if err := v.Validate("PETR4", quote("PETR4", 26.5, now)); err == nil {
+		t.Error("Esperava rejeição por estar fora da banda móvel")
+	}
+}
+
+// TestRejectedQuoteIsQuarantinedAndFailsOver valida que a cotação inválida vai para quarentena e o secundário é usado
+func TestRejectedQuoteIsQuarantinedAndFailsOver(t *testing.T) {
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 5, ResetTimeout: time.Minute})
+	svc := NewQuoteService(breakers, QuoteConfig{Providers: []string{"primary", "secondary"}})
+	svc.fetch = func(addr, symbol string) (model.Quote, error) {
+		if addr == "primary" {
+			return model.Quote{Symbol: symbol, Price: -1, Timestamp: time.Now()}, nil
+		}
+		return model.Quote{Symbol: symbol, Price: 23, Timestamp: time.Now()}, nil
+	}
+	var quarantined []string
+	svc.onReject = func(symbol string, quote model.Quote, reason string) {
+		quarantined = append(quarantined, symbol+":"+quote.Provider)
+	}
+
+	quote, _, err := svc.GetQuote("PETR4")
+	if err != nil || quote.Provider != "secondary" {
+		t.Fatalf("Esperava cotação do secundário: %+v err=%v", quote, err)
+	}
+	if len(quarantined) != 1 || quarantined[0] != "PETR4:primary" {
+		t.Errorf("Esperava a cotação do primário em quarentena, houve %v", quarantined)
+	}
+}
+
+// TestSustainedMoveReanchorsWithoutOpeningBreaker valida que um movimento real
+// acima do limite de salto vira a nova referência depois de algumas rejeições
+// coerentes, sem abrir o breaker do provedor.
+func TestSustainedMoveReanchorsWithoutOpeningBreaker(t *testing.T) {
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 2, ResetTimeout: time.Minute})
+	svc := NewQuoteService(breakers, QuoteConfig{
+		Providers:  []string{"primary"},
+		MaxStale:   time.Minute,
+		Validation: ValidationConfig{MaxJumpPct: 10, BandWindow: 20, BandK: 4, ReanchorAfter: 3},
+	})
+	price := 25.0
+	svc.fetch = func(addr, symbol string) (model.Quote, error) {
+		return model.Quote{Symbol: symbol, Price: price, Timestamp: time.Now()}, nil
+	}
+	for i := 0; i < 5; i++ {
+		if _, _, err := svc.GetQuote("PETR4"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Depois de uma queda, o preço voltou 80% acima e fica por lá
+	var rejected int
+	for i, p := range []float64{45, 45.2, 44.9, 45.1, 45.3} {
+		price = p
+		quote, _, err := svc.GetQuote("PETR4")
+		if quote.Stale || err != nil {
+			rejected++
+			continue
+		}
+		if i < 2 || quote.Price != p {
+			t.Errorf("Preço %v aceito cedo demais ou errado: %+v", p, quote)
+		}
+	}
+	if rejected != 2 {
+		t.Errorf("Esperava 2 rejeições antes da nova referência, houve %d", rejected)
+	}
+	if state := breakers.Get("external:primary").State(); state != circuitbreaker.StateClosed {
+		t.Errorf("Rejeições não deveriam abrir o breaker do provedor, estado %v", state)
+	}
+
+	// Um tick isolado e incoerente com os anteriores não vira referência
+	v := NewValidator(ValidationConfig{MaxJumpPct: 10, ReanchorAfter: 3})
+	now := time.Now()
+	for _, p := range []float64{25, 40, 60, 40} {
+		v.Validate("PETR4", model.Quote{Symbol: "PETR4", Price: p, Timestamp: now})
+	}
+	if err := v.Validate("PETR4", model.Quote{Symbol: "PETR4", Price: 25.5, Timestamp: now}); err != nil {
+		t.Errorf("Saltos incoerentes não deveriam trocar a referência: %v", err)
+	}
+}
+
+// TestTradeValidationAndRouting valida a entrada de ordens: tolerância ao preço atual, ID e shard dono
+func TestTradeValidationAndRouting(t *testing.T) {
+	svc := newTestQuoteService(time.Minute, time.Minute, func(symbol string) (model.Quote, error) {
+		return model.Quote{Symbol: symbol, Price: 25, Timestamp: time.Now()}, nil
+	})
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 3, ResetTimeout: time.Second})
+	shardRouter, _ := ring.NewRouter([]string{"shard-a", "shard-b", "shard-c"}, ring.KeyBySymbol)
+	router := NewTradeRouter(shardRouter, 5, svc, breakers)
+
+	stored := make(map[string][]model.Transaction)
+	router.store = func(addr string, tx model.Transaction) (model.Transaction, error) {
+		stored[addr] = append(stored[addr], tx)
+		return tx, nil
+	}
+
+	if _, err := router.Submit(protocol.TradeRequest{Symbol: "PETR4", Price: 30, Quantity: 100}); err == nil {
+		t.Error("Preço 20% acima da cotação deveria ser rejeitado")
+	}
+	if _, err := router.Submit(protocol.TradeRequest{Symbol: "PETR4", Price: 25, Quantity: 0}); err == nil {
+		t.Error("Quantidade zero deveria ser rejeitada")
+	}
+
+	first, err := router.Submit(protocol.TradeRequest{Symbol: "PETR4", Price: 25.5, Quantity: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := router.Submit(protocol.TradeRequest{Symbol: "PETR4", Price: 24.8, Quantity: 50})
+	if first.ID == "" || first.ID == second.ID {
+		t.Errorf("IDs deveriam ser únicos: %q, %q", first.ID, second.ID)
+	}
+
+	// Todas as transações do símbolo vão para o mesmo shard
+	owner := shardRouter.ShardsFor("PETR4")[0]
+	if len(stored) != 1 || len(stored[owner]) != 2 {
+		t.Errorf("Esperava 2 transações no shard %s, gravado: %v", owner, stored)
+	}
+}
+
+// TestTradeQuorumWrites valida a gravação em todos os nós da partição com W confirmações
+func TestTradeQuorumWrites(t *testing.T) {
+	svc := newTestQuoteService(time.Minute, time.Minute, func(symbol string) (model.Quote, error) {
+		return model.Quote{Symbol: symbol, Price: 25, Timestamp: time.Now()}, nil
+	})
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 3, ResetTimeout: time.Second})
+	parts := []ring.Partition{{Primary: "a1", Replicas: []string{"a2", "a3"}}}
+	shardRouter, _ := ring.NewPartitionedRouter(parts, ring.KeyBySymbol)
+	router := NewTradeRouter(shardRouter, 5, svc, breakers)
+	if err := router.EnableQuorum(2); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	stored := make(map[string]model.Transaction)
+	router.store = func(addr string, tx model.Transaction) (model.Transaction, error) {
+		if addr == "a3" {
+			return model.Transaction{}, fmt.Errorf("connection refused")
+		}
+		mu.Lock()
+		stored[addr] = tx
+		mu.Unlock()
+		return tx, nil
+	}
+
+	tx, err := router.Submit(protocol.TradeRequest{Symbol: "PETR4", Price: 25, Quantity: 100})
+	if err != nil {
+		t.Fatalf("W=2 com um nó fora deveria confirmar: %v", err)
+	}
+	if tx.Version == 0 {
+		t.Error("Transação deveria ter versão para resolver conflitos")
+	}
+	mu.Lock()
+	if stored["a1"].ID != tx.ID || stored["a2"].ID != tx.ID {
+		t.Errorf("Esperava a transação em a1 e a2, gravado: %v", stored)
+	}
+	mu.Unlock()
+
+	// W por requisição: exigir os 3 nós falha com a3 fora
+	if _, err := router.Submit(protocol.TradeRequest{Symbol: "PETR4", Price: 25, Quantity: 100, W: 3}); err == nil {
+		t.Error("W=3 com um nó fora deveria falhar")
+	}
+	if _, err := router.Submit(protocol.TradeRequest{Symbol: "PETR4", Price: 25, Quantity: 100, W: 4}); err == nil {
+		t.Error("W maior que N deveria ser rejeitado")
+	}
+}
+
+// TestTradeIdempotencyKey valida que reenviar uma operação com a mesma chave
+// devolve a transação original, mesmo quando a repetição chega a um nó que
+// perdeu a original, e que esse nó converge para ela.
+func TestTradeIdempotencyKey(t *testing.T) {
+	svc := newTestQuoteService(time.Minute, time.Minute, func(symbol string) (model.Quote, error) {
+		return model.Quote{Symbol: symbol, Price: 25, Timestamp: time.Now()}, nil
+	})
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 3, ResetTimeout: time.Second})
+	parts := []ring.Partition{{Primary: "a1", Replicas: []string{"a2", "a3"}}}
+	shardRouter, _ := ring.NewPartitionedRouter(parts, ring.KeyByID)
+	router := NewTradeRouter(shardRouter, 5, svc, breakers)
+	router.EnableQuorum(2)
+
+	nodes := map[string]*storage.MemStore{"a1": storage.NewMemStore(), "a2": storage.NewMemStore(), "a3": storage.NewMemStore()}
+	var down atomic.Value
+	down.Store("a3")
+	// Submit retorna após W confirmações; submit espera também o envio que
+	// ficou em segundo plano antes de o teste mudar o nó fora ou ler os nós
+	var writes sync.WaitGroup
+	router.store = func(addr string, tx model.Transaction) (model.Transaction, error) {
+		defer writes.Done()
+		if down.Load() == addr {
+			return model.Transaction{}, fmt.Errorf("connection refused")
+		}
+		res, err := nodes[addr].Put(tx)
+		return res.Stored, err
+	}
+	submit := func(req protocol.TradeRequest) (model.Transaction, error) {
+		writes.Add(len(nodes))
+		defer writes.Wait()
+		return router.Submit(req)
+	}
+
+	req := protocol.TradeRequest{Symbol: "PETR4", Price: 25, Quantity: 100, IdempotencyKey: "order-42"}
+	first, err := submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reenvio com a3 de volta: a1 e a2 já têm a original
+	down.Store("")
+	req.Price = 25.1
+	retry, err := submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID != first.ID || retry.Version != first.Version || retry.Price != 25 {
+		t.Errorf("Reenvio deveria devolver a original %+v, devolveu %+v", first, retry)
+	}
+	for name, node := range nodes {
+		if name != "a3" && node.Len() != 1 {
+			t.Errorf("%s deveria ter uma única transação, tem %d", name, node.Len())
+		}
+	}
+
+	// a3 gravou a repetição; a original (anti-entropia ou reparo) prevalece sobre ela
+	if res, _ := nodes["a3"].Put(first); !res.Applied {
+		t.Error("A original deveria substituir a repetição gravada em a3")
+	}
+	if got, _ := nodes["a3"].Get(first.ID); got.Version != first.Version {
+		t.Errorf("a3 deveria convergir para a original, tem %+v", got)
+	}
+
+	other, _ := submit(protocol.TradeRequest{Symbol: "PETR4", Price: 25, Quantity: 100, IdempotencyKey: "order-43"})
+	if other.ID == first.ID {
+		t.Error("Chaves diferentes deveriam gerar transações diferentes")
+	}
+}
+
+// TestKeyedWriteConvergesAfterNodeDown valida a regra única de conflito das
+// transações com chave: com um nó fora na primeira escrita, o reenvio grava
+// a repetição nele, e a leitura por quorum devolve a versão confirmada e
+// repara o nó até os três convergirem para ela.
+func TestKeyedWriteConvergesAfterNodeDown(t *testing.T) {
+	svc := newTestQuoteService(time.Minute, time.Minute, func(symbol string) (model.Quote, error) {
+		return model.Quote{Symbol: symbol, Price: 25, Timestamp: time.Now()}, nil
+	})
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 3, ResetTimeout: time.Second})
+	parts := []ring.Partition{{Primary: "a1", Replicas: []string{"a2", "a3"}}}
+	shardRouter, _ := ring.NewPartitionedRouter(parts, ring.KeyByID)
+	router := NewTradeRouter(shardRouter, 5, svc, breakers)
+	router.EnableQuorum(2)
+
+	names := []string{"a1", "a2", "a3"}
+	nodes := map[string]*storage.MemStore{"a1": storage.NewMemStore(), "a2": storage.NewMemStore(), "a3": storage.NewMemStore()}
+	var down atomic.Value
+	down.Store("a3")
+	// Submit retorna após W confirmações; submit espera também o envio que
+	// ficou em segundo plano antes de o teste mudar o nó fora ou ler os nós
+	var writes sync.WaitGroup
+	router.store = func(addr string, tx model.Transaction) (model.Transaction, error) {
+		defer writes.Done()
+		if down.Load() == addr {
+			return model.Transaction{}, fmt.Errorf("connection refused")
+		}
+		res, err := nodes[addr].Put(tx)
+		return res.Stored, err
+	}
+	submit := func(req protocol.TradeRequest) (model.Transaction, error) {
+		writes.Add(len(nodes))
+		defer writes.Wait()
+		return router.Submit(req)
+	}
+
+	req := protocol.TradeRequest{Symbol: "PETR4", Price: 25, Quantity: 100, IdempotencyKey: "order-7"}
+	acked, err := submit(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down.Store("")
+	if retry, err := submit(req); err != nil || retry.Version != acked.Version {
+		t.Fatalf("Esperava o reenvio confirmando a versão %d, recebido %+v %v", acked.Version, retry, err)
+	}
+	if got, _ := nodes["a3"].Get(acked.ID); got.Version <= acked.Version {
+		t.Fatalf("Esperava a repetição (versão maior) gravada em a3, recebido %+v", got)
+	}
+
+	// Leitura com R=2 pelos nós que divergem: vale a versão confirmada, e a3 é reparado
+	q, _ := query.Query{Symbol: "PETR4"}.Normalize()
+	var responses []quorum.Response
+	for _, name := range []string{"a3", "a1"} {
+		page, _ := nodes[name].Query(q)
+		responses = append(responses, quorum.Response{Node: name, Page: page})
+	}
+	page, repairs := quorum.Merge(responses, q)
+	if len(page.Transactions) != 1 || page.Transactions[0].Version != acked.Version {
+		t.Errorf("Esperava a leitura com a versão confirmada %d, recebido %+v", acked.Version, page.Transactions)
+	}
+	if len(repairs["a1"]) != 0 || len(repairs["a3"]) != 1 {
+		t.Errorf("Esperava reparar só a3, recebido %v", repairs)
+	}
+	for node, txs := range repairs {
+		for _, tx := range txs {
+			nodes[node].Put(tx)
+		}
+	}
+	for _, name := range names {
+		if got, _ := nodes[name].Get(acked.ID); got.Version != acked.Version || got.Price != acked.Price {
+			t.Errorf("Esperava %s com a versão confirmada %+v, recebido %+v", name, acked, got)
+		}
+		if nodes[name].Tree().Root() != nodes["a1"].Tree().Root() {
+			t.Errorf("Esperava a raiz de %s igual à de a1", name)
+		}
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"distributed-system/pkg/circuitbreaker"
 	"distributed-system/pkg/model"
 	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/pubsub"
+	"distributed-system/pkg/ring"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"net"
-	"sync"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -15,89 +21,136 @@ const (
 	ExternalServiceAddr = "localhost:8080"
 	BrokerServiceAddr   = "localhost:8081"
 	CoreServicePort     = ":8082"
-)
 
-// BrokerClient gerencia a conexão com o Broker Pub/Sub de forma segura.
-type BrokerClient struct {
-	addr string
-	conn net.Conn
-	mu   sync.Mutex // Protege o acesso à conexão (Escritas Atômicas & Reconexão)
-}
+	// Símbolo assumido quando o pedido não informa nenhum
+	defaultSymbol = "PETR4"
+)
 
-func NewBrokerClient(addr string) *BrokerClient {
-	return &BrokerClient{
-		addr: addr,
-	}
-}
+var (
+	providers       = flag.String("providers", ExternalServiceAddr, "Comma-separated external quote providers, in order of preference")
+	cacheTTL        = flag.Duration("cache-ttl", 500*time.Millisecond, "Serve cached quotes younger than this without calling external")
+	maxStale        = flag.Duration("max-stale", 30*time.Second, "Max age of a cached quote served as stale when external fails")
+	hedge           = flag.Bool("hedge", false, "Send a hedged request to the secondary provider when the primary is slow")
+	hedgePercentile = flag.Float64("hedge-percentile", 95, "Primary latency percentile used as the hedge delay")
+	hedgeDelay      = flag.Duration("hedge-delay", 500*time.Millisecond, "Hedge delay used until enough latency samples exist")
+	poll            = flag.String("poll", "", "Symbols polled continuously and published, e.g. PETR4:1s,VALE3:2s")
+	pollJitter      = flag.Float64("poll-jitter", 0.1, "Random jitter applied to poll intervals (fraction of the interval)")
+	pollMaxBackoff  = flag.Duration("poll-max-backoff", 30*time.Second, "Max poll interval while providers are failing")
+	outboxSize      = flag.Int("outbox-size", 1000, "Max messages waiting for the broker (oldest are dropped beyond this)")
+	outboxFile      = flag.String("outbox-file", "", "Optional journal file keeping pending broker messages across restarts")
+	flushTimeout    = flag.Duration("flush-timeout", 5*time.Second, "How long to keep delivering pending messages on shutdown")
+	maxJumpPct      = flag.Float64("max-jump-pct", 60, "Reject quotes moving more than this percent from the last accepted price (0 disables)")
+	bandWindow      = flag.Int("band-window", 50, "Accepted prices kept per symbol for the rolling band")
+	bandK           = flag.Float64("band-k", 4, "Reject quotes further than K standard deviations from the rolling mean (0 disables)")
+	maxSkew         = flag.Duration("max-skew", 2*time.Second, "Tolerance for quote timestamps in the future")
+	reanchorAfter   = flag.Int("reanchor-after", 3, "Consecutive consistent rejections accepted as a genuine move (0 disables)")
+	shards          = flag.String("shards", "localhost:9001,localhost:9002,localhost:9003", "Comma-separated history shards receiving new transactions, each as primary|replica|... (writes go to the primary)")
+	tradeTolerance  = flag.Float64("trade-tolerance-pct", 5, "Max distance (%) between a submitted trade price and the current quote")
+	routeBy         = flag.String("route-by", ring.KeyBySymbol, "Shard routing key: symbol or id (must match the aggregator)")
+	replication     = flag.String("replication", "primary", "Shard replication mode: primary (write to the primary only) or quorum (write to every node)")
+	writeQuorum     = flag.Int("w", 2, "Write acknowledgements required with -replication=quorum")
+)
 
-// Publish envia uma mensagem para o broker com lógica de reconexão automática.
-func (bc *BrokerClient) Publish(topic string, payload []byte) error {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+func main() {
+	flag.Parse()
 
-	// 1. Garantir Conexão
-	if bc.conn == nil {
-		if err := bc.connect(); err != nil {
-			return fmt.Errorf("broker offline: %v", err)
-		}
+	// Inicializar Registro de Circuit Breakers (um por dependência)
+	breakers := circuitbreaker.NewRegistry(circuitbreaker.Settings{Threshold: 5, ResetTimeout: 10 * time.Second})
+	cfg := QuoteConfig{
+		Providers:       strings.Split(*providers, ","),
+		TTL:             *cacheTTL,
+		MaxStale:        *maxStale,
+		Hedge:           *hedge,
+		HedgePercentile: *hedgePercentile,
+		HedgeDelay:      *hedgeDelay,
+		Validation: ValidationConfig{
+			MaxJumpPct:    *maxJumpPct,
+			BandWindow:    *bandWindow,
+			BandK:         *bandK,
+			MaxSkew:       *maxSkew,
+			ReanchorAfter: *reanchorAfter,
+		},
 	}
-
-	// 2. Preparar Mensagem dentro do bloqueio para garantir sequência
-	msg := protocol.Message{
-		Type:    protocol.MsgPublish,
-		Topic:   topic,
-		Payload: payload,
+	quotes := NewQuoteService(breakers, cfg)
+	for _, p := range quotes.providers {
+		breakers.Configure(p.breakerName(), circuitbreaker.Settings{Threshold: 3, ResetTimeout: 5 * time.Second})
+		// Registrado já na partida para o operador poder tirá-lo de rotação antes da primeira chamada
+		breakers.Get(p.breakerName())
 	}
 
-	// 3. Tentar Enviar
-	err := protocol.SendJSON(bc.conn, msg)
-	if err != nil {
-		fmt.Printf("[Core] Error publishing to broker: %v. Attempting reconnection...\n", err)
-		
-		// 4. Lógica de Reconexão (Em caso de Erro)
-		bc.conn.Close()
-		bc.conn = nil // Forçar reconexão completa na próxima vez ou agora
-
-		// Tentar reconectar imediatamente uma vez
-		if reconErr := bc.connect(); reconErr != nil {
-			return fmt.Errorf("reconnection failed: %v", reconErr)
+	// Inicializar Cliente Broker Robusto
+	brokerClient := pubsub.NewPublisher(BrokerServiceAddr)
+	// Tentar conexão inicial (opcional, permite verificação rápida de falha)
+	go func() {
+		if err := brokerClient.Publish("healthcheck", []byte{}); err != nil {
+			fmt.Println("[Core] Initial broker check failed (will retry on demand):", err)
 		}
+	}()
 
-		// Tentar Enviar Novamente
-		fmt.Println("[Core] Reconnected to Broker. Retrying publish...")
-		if err := protocol.SendJSON(bc.conn, msg); err != nil {
-			// Se falhar novamente, desistir desta requisição para evitar bloqueio muito longo
-			bc.conn.Close()
-			bc.conn = nil
-			return fmt.Errorf("retry failed: %v", err)
-		}
+	// Outbox: os handlers apenas enfileiram; a entrega ao Broker é feita em segundo plano
+	outbox, err := NewOutbox(brokerClient, *outboxSize, *outboxFile, quotes.metrics)
+	if err != nil {
+		panic(err)
 	}
+	outbox.Start()
 
-	return nil
-}
+	// Cotações rejeitadas vão para quarentena, com o motivo, em vez do tópico do símbolo
+	quotes.onReject = func(symbol string, quote model.Quote, reason string) {
+		fmt.Printf("[Core] Quarantined %s from %s: %s\n", symbol, quote.Provider, reason)
+		payload, _ := json.Marshal(model.QuarantinedQuote{Quote: quote, Reason: reason, RejectedAt: time.Now()})
+		outbox.Enqueue(quarantineTopic(symbol), payload)
+	}
 
-func (bc *BrokerClient) connect() error {
-	conn, err := net.DialTimeout("tcp", bc.addr, 500*time.Millisecond)
+	// Publicador contínuo (independente de pedidos do Agregador)
+	targets, err := ParsePollTargets(*poll)
 	if err != nil {
-		return err
+		panic(err)
+	}
+	var poller *Poller
+	if len(targets) > 0 {
+		poller = NewPoller(quotes, targets, *pollJitter, *pollMaxBackoff, func(quote model.Quote) {
+			publishQuote(outbox, quote)
+		})
+		poller.Start()
+		fmt.Printf("[Core] Polling %d symbols\n", len(targets))
 	}
-	bc.conn = conn
-	return nil
-}
 
-func main() {
-	// Inicializar Circuit Breaker
-	cb := circuitbreaker.NewCircuitBreaker(3, 5*time.Second)
-	
-	// Inicializar Cliente Broker Robusto
-	brokerClient := NewBrokerClient(BrokerServiceAddr)
-	// Tentar conexão inicial (opcional, permite verificação rápida de falha)
+	// Encerramento gracioso: parar o publicador contínuo e esvaziar o Outbox
 	go func() {
-		if err := brokerClient.Publish("healthcheck", []byte{}); err != nil {
-			fmt.Println("[Core] Initial broker check failed (will retry on demand):", err)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		fmt.Println("[Core] Shutting down, flushing outbox...")
+		if poller != nil {
+			poller.Stop()
 		}
+		if left := outbox.Flush(*flushTimeout); left > 0 {
+			fmt.Printf("[Core] %d messages left undelivered\n", left)
+		}
+		os.Exit(0)
 	}()
 
+	// Entrada de ordens: validação contra a cotação atual e gravação no shard dono
+	parts, err := ring.ParsePartitions(*shards)
+	if err != nil {
+		panic(err)
+	}
+	router, err := ring.NewPartitionedRouter(parts, *routeBy)
+	if err != nil {
+		panic(err)
+	}
+	trades := NewTradeRouter(router, *tradeTolerance, quotes, breakers)
+	switch *replication {
+	case "primary":
+	case "quorum":
+		if err := trades.EnableQuorum(*writeQuorum); err != nil {
+			panic(err)
+		}
+		fmt.Printf("[Core] Quorum writes: W=%d\n", *writeQuorum)
+	default:
+		panic(fmt.Sprintf("unknown replication mode %q", *replication))
+	}
+
 	// Servidor para o Agregador
 	listener, err := net.Listen("tcp", CoreServicePort)
 	if err != nil {
@@ -110,11 +163,11 @@ func main() {
 		if err != nil {
 			continue
 		}
-		go handleRequest(conn, cb, brokerClient)
+		go handleRequest(conn, quotes, breakers, outbox, trades)
 	}
 }
 
-func handleRequest(clientConn net.Conn, cb *circuitbreaker.CircuitBreaker, broker *BrokerClient) {
+func handleRequest(clientConn net.Conn, quotes *QuoteService, breakers *circuitbreaker.Registry, outbox *Outbox, trades *TradeRouter) {
 	defer clientConn.Close()
 
 	var msg protocol.Message
@@ -122,45 +175,117 @@ func handleRequest(clientConn net.Conn, cb *circuitbreaker.CircuitBreaker, broke
 		return
 	}
 
-	if msg.Type == protocol.MsgRequestQuote {
-		// Usar Circuit Breaker para buscar do Externo
-		result, err := cb.Execute(func() (interface{}, error) {
-			return fetchQuoteFromExternal()
-		})
+	switch msg.Type {
+	case protocol.MsgAdminBreaker:
+		handleAdmin(clientConn, breakers, msg)
+	case protocol.MsgAdminTopology:
+		handleTopology(clientConn, trades, msg)
+	case protocol.MsgReqMetrics:
+		protocol.SendJSON(clientConn, protocol.NewMessage(protocol.MsgRespMetrics, quotes.metrics.Snapshot()))
+	case protocol.MsgSubmitTrade:
+		var req protocol.TradeRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			protocol.SendJSON(clientConn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		tx, err := trades.Submit(req)
+		if err != nil {
+			fmt.Println("[Core] Trade rejected:", err)
+			protocol.SendJSON(clientConn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		fmt.Printf("[Core] Trade %s stored (%d %s @ %.2f)\n", tx.ID, tx.Quantity, tx.Symbol, tx.Price)
+		protocol.SendJSON(clientConn, protocol.NewMessage(protocol.MsgTradeAck, tx))
+	case protocol.MsgRequestQuote:
+		// Payload opcional: pedidos sem símbolo continuam recebendo PETR4
+		var req protocol.QuoteRequest
+		json.Unmarshal(msg.Payload, &req)
+		if req.Symbol == "" {
+			req.Symbol = defaultSymbol
+		}
 
+		// Cache + Circuit Breaker + fallback para cotação antiga
+		quote, fresh, err := quotes.GetQuote(req.Symbol)
 		if err != nil {
 			errMsg := protocol.NewMessage(protocol.MsgError, err.Error())
 			protocol.SendJSON(clientConn, errMsg)
 			return
 		}
 
-		quote := result.(model.Quote)
-		
 		// 1. Retornar ao Cliente (Agregador)
 		respPayload, _ := json.Marshal(quote)
 		resp := protocol.Message{Type: protocol.MsgRespQuote, Payload: respPayload}
 		protocol.SendJSON(clientConn, resp)
 
-		// 2. Publicar no Broker (Robusto & Quase Assíncrono)
-		// Fazemos isso de forma síncrona aqui para garantir a ordem, mas como usamos um timeout na conexão, não ficará travado para sempre.
-		err = broker.Publish(quote.Symbol, respPayload)
-		if err != nil {
-			// Apenas logar, não falhar a requisição do cliente pois o pub/sub é auxiliar
-			fmt.Println("[Core] Warning: Failed to publish quote:", err)
-		} else {
-			fmt.Printf("[Core] Published %s to Broker\n", quote.Symbol)
+		// Cotações vindas do cache já foram publicadas quando foram obtidas
+		if !fresh {
+			return
 		}
+
+		// 2. Publicar no Broker (via Outbox, sem esperar a entrega)
+		publishQuote(outbox, quote)
+	}
+}
+
+// publishQuote agenda a cotação para o tópico do símbolo no Broker.
+// A ordem é garantida pelo Outbox, que entrega uma mensagem por vez.
+func publishQuote(outbox *Outbox, quote model.Quote) {
+	payload, _ := json.Marshal(quote)
+	if err := outbox.Enqueue(quote.Symbol, payload); err != nil {
+		// Apenas logar, não falhar a requisição do cliente pois o pub/sub é auxiliar
+		fmt.Println("[Core] Warning: Failed to queue quote:", err)
+	}
+}
+
+// quarantineTopic é o tópico onde cotações rejeitadas do símbolo são publicadas.
+func quarantineTopic(symbol string) string {
+	return "quarantine." + symbol
+}
+
+// handleAdmin aplica um comando de operador (forçar abertura/fechamento ou reset) em um breaker.
+func handleAdmin(conn net.Conn, breakers *circuitbreaker.Registry, msg protocol.Message) {
+	var cmd protocol.BreakerCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+
+	statuses, err := breakers.Apply(cmd.Action, cmd.Name)
+	if err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+	fmt.Printf("[Core] Admin command %q applied to breaker %q\n", cmd.Action, cmd.Name)
+	protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAdmin, statuses))
+}
+
+// handleTopology consulta ou muda a topologia dos shards usada na entrada de ordens.
+func handleTopology(conn net.Conn, trades *TradeRouter, msg protocol.Message) {
+	var cmd protocol.TopologyCommand
+	if err := json.Unmarshal(msg.Payload, &cmd); err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+
+	status, err := trades.ApplyTopology(cmd)
+	if err != nil {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+		return
+	}
+	if cmd.Action != ring.ActionShow {
+		fmt.Printf("[Core] Topology %s: shards=%s next=%s\n", cmd.Action, status.Shards, status.Next)
 	}
+	protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAdmin, status))
 }
 
-func fetchQuoteFromExternal() (model.Quote, error) {
-	conn, err := net.DialTimeout("tcp", ExternalServiceAddr, 2*time.Second)
+func fetchQuoteFromExternal(addr, symbol string) (model.Quote, error) {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
 	if err != nil {
 		return model.Quote{}, err
 	}
 	defer conn.Close()
 
-	req := protocol.NewMessage(protocol.MsgRequestQuote, nil)
+	req := protocol.NewMessage(protocol.MsgRequestQuote, protocol.QuoteRequest{Symbol: symbol})
 	if err := protocol.SendJSON(conn, req); err != nil {
 		return model.Quote{}, err
 	}
@@ -0,0 +1,37 @@
+package main
+
+import "sync/atomic"
+
+// Metrics reúne contadores do Core expostos via MsgReqMetrics.
+type Metrics struct {
+	QuoteRequests atomic.Int64 // Pedidos de cotação recebidos
+	CacheHits     atomic.Int64 // Servidos do cache fresco, sem chamada externa
+	StaleServed   atomic.Int64 // Servidos do cache como fallback
+	UpstreamCalls atomic.Int64 // Buscas efetivamente enviadas ao externo (via breaker)
+	Coalesced     atomic.Int64 // Pedidos que pegaram carona em uma busca em andamento
+	Failovers     atomic.Int64 // Cotações obtidas de um provedor que não o preferido
+	Hedged        atomic.Int64 // Pedidos de hedge disparados ao provedor secundário
+	Rejected      atomic.Int64 // Cotações descartadas pela validação (quarentena)
+
+	OutboxPublished atomic.Int64 // Mensagens entregues ao Broker pelo Outbox
+	OutboxOverflow  atomic.Int64 // Mensagens descartadas com a fila cheia
+	OutboxPending   atomic.Int64 // Mensagens aguardando entrega (gauge)
+}
+
+// Snapshot retorna uma cópia serializável dos contadores.
+func (m *Metrics) Snapshot() map[string]int64 {
+	return map[string]int64{
+		"quote_requests": m.QuoteRequests.Load(),
+		"cache_hits":     m.CacheHits.Load(),
+		"stale_served":   m.StaleServed.Load(),
+		"upstream_calls": m.UpstreamCalls.Load(),
+		"coalesced":      m.Coalesced.Load(),
+		"failovers":      m.Failovers.Load(),
+		"hedged":         m.Hedged.Load(),
+		"rejected":       m.Rejected.Load(),
+
+		"outbox_published": m.OutboxPublished.Load(),
+		"outbox_overflow":  m.OutboxOverflow.Load(),
+		"outbox_pending":   m.OutboxPending.Load(),
+	}
+}
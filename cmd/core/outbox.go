@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// publisher é o transporte usado pelo Outbox (BrokerClient em produção).
+type publisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// outboxEntry é uma mensagem pendente. No journal em disco, linhas com Ack
+// marcam a entrada Seq como entregue (ou descartada por overflow).
+type outboxEntry struct {
+	Seq     uint64          `json:"seq"`
+	Topic   string          `json:"topic,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	Ack     bool            `json:"ack,omitempty"`
+}
+
+var errOutboxClosed = errors.New("outbox is closed")
+
+// Outbox desacopla os handlers do Core do Broker: publicar vira apenas enfileirar
+// em memória, e uma goroutine de fundo entrega as mensagens em ordem, reconectando
+// com backoff exponencial. Com a fila cheia, a mensagem mais antiga é descartada
+// (e contada). Opcionalmente as mensagens pendentes são mantidas em um journal
+// em disco e reenviadas após um restart.
+type Outbox struct {
+	mu       sync.Mutex
+	queue    []outboxEntry
+	capacity int
+	nextSeq  uint64
+	closed   bool
+	journal  *os.File // nil quando a fila é apenas em memória
+
+	pub        publisher
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	metrics    *Metrics
+
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewOutbox cria a fila. journalPath vazio mantém tudo apenas em memória.
+func NewOutbox(pub publisher, capacity int, journalPath string, metrics *Metrics) (*Outbox, error) {
+	o := &Outbox{
+		capacity:   capacity,
+		nextSeq:    1,
+		pub:        pub,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+		metrics:    metrics,
+		notify:     make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if journalPath != "" {
+		if err := o.openJournal(journalPath); err != nil {
+			return nil, err
+		}
+	}
+	o.metrics.OutboxPending.Store(int64(len(o.queue)))
+	return o, nil
+}
+
+// Start inicia a goroutine de entrega.
+func (o *Outbox) Start() {
+	go o.drain()
+}
+
+// Enqueue agenda a publicação sem bloquear o chamador.
+func (o *Outbox) Enqueue(topic string, payload []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return errOutboxClosed
+	}
+
+	if len(o.queue) >= o.capacity {
+		dropped := o.queue[0]
+		o.queue = o.queue[1:]
+		o.appendJournal(outboxEntry{Seq: dropped.Seq, Ack: true})
+		o.metrics.OutboxOverflow.Add(1)
+	}
+
+	entry := outboxEntry{Seq: o.nextSeq, Topic: topic, Payload: payload}
+	o.nextSeq++
+	o.queue = append(o.queue, entry)
+	o.appendJournal(entry)
+	o.metrics.OutboxPending.Store(int64(len(o.queue)))
+
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len retorna quantas mensagens aguardam entrega.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queue)
+}
+
+// Flush para de aceitar mensagens, tenta entregar o que está pendente dentro do
+// prazo e encerra a goroutine de entrega. Retorna quantas mensagens ficaram para
+// trás (que continuam no journal, se houver um).
+func (o *Outbox) Flush(timeout time.Duration) int {
+	o.mu.Lock()
+	o.closed = true
+	o.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for o.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(o.stop)
+	<-o.done
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.journal != nil {
+		o.journal.Sync()
+		o.journal.Close()
+		o.journal = nil
+	}
+	return len(o.queue)
+}
+
+func (o *Outbox) drain() {
+	defer close(o.done)
+	backoff := o.minBackoff
+
+	for {
+		entry, ok := o.peek()
+		if !ok {
+			select {
+			case <-o.notify:
+				continue
+			case <-o.stop:
+				return
+			}
+		}
+
+		if err := o.pub.Publish(entry.Topic, entry.Payload); err != nil {
+			// Mantemos a mensagem na cabeça da fila para preservar a ordem
+			fmt.Printf("[Core] Outbox publish failed (retrying in %v): %v\n", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-o.stop:
+				return
+			}
+			backoff *= 2
+			if backoff > o.maxBackoff {
+				backoff = o.maxBackoff
+			}
+			continue
+		}
+
+		backoff = o.minBackoff
+		o.ack(entry.Seq)
+	}
+}
+
+func (o *Outbox) peek() (outboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.queue) == 0 {
+		return outboxEntry{}, false
+	}
+	return o.queue[0], true
+}
+
+// ack remove a entrada entregue. Ela pode já ter sido descartada por overflow
+// enquanto era publicada; nesse caso não há nada a fazer.
+func (o *Outbox) ack(seq uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.queue) == 0 || o.queue[0].Seq != seq {
+		return
+	}
+	o.queue = o.queue[1:]
+	o.metrics.OutboxPublished.Add(1)
+	o.metrics.OutboxPending.Store(int64(len(o.queue)))
+
+	if len(o.queue) == 0 && o.journal != nil {
+		// Fila vazia: o journal pode recomeçar do zero
+		o.journal.Truncate(0)
+		return
+	}
+	o.appendJournal(outboxEntry{Seq: seq, Ack: true})
+}
+
+// appendJournal grava uma linha no journal. Deve ser chamada com o lock.
+func (o *Outbox) appendJournal(entry outboxEntry) {
+	if o.journal == nil {
+		return
+	}
+	if err := json.NewEncoder(o.journal).Encode(entry); err != nil {
+		fmt.Println("[Core] Warning: Failed to write outbox journal:", err)
+	}
+}
+
+// openJournal recupera as mensagens pendentes de uma execução anterior e
+// reescreve o journal contendo apenas elas.
+func (o *Outbox) openJournal(path string) error {
+	pending := make(map[uint64]outboxEntry)
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var entry outboxEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				// Linha final incompleta (crash durante a escrita): ignorar
+				continue
+			}
+			if entry.Ack {
+				delete(pending, entry.Seq)
+			} else {
+				pending[entry.Seq] = entry
+			}
+			if entry.Seq >= o.nextSeq {
+				o.nextSeq = entry.Seq + 1
+			}
+		}
+		f.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, entry := range pending {
+		o.queue = append(o.queue, entry)
+	}
+	sort.Slice(o.queue, func(i, j int) bool { return o.queue[i].Seq < o.queue[j].Seq })
+	if len(o.queue) > o.capacity {
+		o.metrics.OutboxOverflow.Add(int64(len(o.queue) - o.capacity))
+		o.queue = o.queue[len(o.queue)-o.capacity:]
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	o.journal = f
+	for _, entry := range o.queue {
+		o.appendJournal(entry)
+	}
+	if len(o.queue) > 0 {
+		fmt.Printf("[Core] Recovered %d pending messages from outbox journal\n", len(o.queue))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"distributed-system/pkg/model"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PollTarget define um símbolo acompanhado continuamente e seu intervalo de consulta.
+type PollTarget struct {
+	Symbol   string
+	Interval time.Duration
+}
+
+// ParsePollTargets interpreta a lista "PETR4:1s,VALE3:2s".
+func ParsePollTargets(spec string) ([]PollTarget, error) {
+	var targets []PollTarget
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		symbol, rawInterval, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid poll target %q (expected SYMBOL:INTERVAL)", item)
+		}
+		interval, err := time.ParseDuration(rawInterval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid poll interval in %q", item)
+		}
+		targets = append(targets, PollTarget{Symbol: symbol, Interval: interval})
+	}
+	return targets, nil
+}
+
+// Poller consulta cada símbolo configurado no seu próprio ritmo e publica mudanças,
+// independente de pedidos do Agregador. Enquanto os breakers dos provedores
+// estiverem abertos (ou a busca falhar) o intervalo dobra, até maxBackoff.
+type Poller struct {
+	quotes     *QuoteService
+	targets    []PollTarget
+	jitter     float64 // Fração do intervalo sorteada para mais ou para menos
+	maxBackoff time.Duration
+	publish    func(model.Quote)
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewPoller(quotes *QuoteService, targets []PollTarget, jitter float64, maxBackoff time.Duration, publish func(model.Quote)) *Poller {
+	return &Poller{
+		quotes:     quotes,
+		targets:    targets,
+		jitter:     jitter,
+		maxBackoff: maxBackoff,
+		publish:    publish,
+		done:       make(chan struct{}),
+	}
+}
+
+func (p *Poller) Start() {
+	for _, target := range p.targets {
+		p.wg.Add(1)
+		go p.run(target)
+	}
+}
+
+// Stop encerra todas as goroutines de consulta e aguarda sua saída.
+func (p *Poller) Stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *Poller) run(target PollTarget) {
+	defer p.wg.Done()
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	interval := target.Interval
+	var lastPrice float64
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(p.withJitter(r, interval)):
+		}
+
+		// Breakers abertos rejeitam na hora (erro ou cotação antiga do cache);
+		// nesse caso desaceleramos, e a próxima tentativa serve de sonda.
+		quote, fresh, err := p.quotes.GetQuote(target.Symbol)
+		if err != nil || quote.Stale {
+			interval = p.backoff(interval)
+			continue
+		}
+		interval = target.Interval
+
+		// Publicar apenas cotações novas e que mudaram desde a última publicação
+		if fresh && quote.Price != lastPrice {
+			lastPrice = quote.Price
+			p.publish(quote)
+		}
+	}
+}
+
+func (p *Poller) backoff(interval time.Duration) time.Duration {
+	interval *= 2
+	if interval > p.maxBackoff {
+		interval = p.maxBackoff
+	}
+	return interval
+}
+
+// withJitter espalha as consultas para que símbolos com o mesmo intervalo não
+// batam no provedor ao mesmo tempo.
+func (p *Poller) withJitter(r *rand.Rand, interval time.Duration) time.Duration {
+	if p.jitter <= 0 {
+		return interval
+	}
+	delta := (r.Float64()*2 - 1) * p.jitter * float64(interval)
+	return interval + time.Duration(delta)
+}
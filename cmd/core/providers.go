@@ -0,0 +1,175 @@
+package main
+
+import (
+	"distributed-system/pkg/model"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Quantidade de amostras de latência mantidas por provedor
+const latencyWindow = 100
+
+// Mínimo de amostras antes de confiar no percentil para o atraso do hedge
+const minLatencySamples = 5
+
+// latencyTracker guarda as latências mais recentes de um provedor (buffer circular).
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (lt *latencyTracker) Record(d time.Duration) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	if len(lt.samples) < latencyWindow {
+		lt.samples = append(lt.samples, d)
+		return
+	}
+	lt.samples[lt.next] = d
+	lt.next = (lt.next + 1) % latencyWindow
+}
+
+// Percentile retorna o percentil p (0-100) das amostras, ou fallback se houver poucas.
+func (lt *latencyTracker) Percentile(p float64, fallback time.Duration) time.Duration {
+	lt.mu.Lock()
+	sorted := make([]time.Duration, len(lt.samples))
+	copy(sorted, lt.samples)
+	lt.mu.Unlock()
+
+	if len(sorted) < minLatencySamples {
+		return fallback
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p / 100 * float64(len(sorted)-1))
+	if idx < 0 {
+		idx = 0
+	} else if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// Provider é uma fonte externa de cotações, com seu próprio breaker e histórico de latência.
+type Provider struct {
+	Addr    string
+	latency *latencyTracker
+}
+
+func NewProvider(addr string) *Provider {
+	return &Provider{Addr: addr, latency: &latencyTracker{}}
+}
+
+func (p *Provider) breakerName() string {
+	return "external:" + p.Addr
+}
+
+type providerResult struct {
+	quote model.Quote
+	err   error
+}
+
+// callProvider busca a cotação em um provedor através do breaker dele.
+func (s *QuoteService) callProvider(p *Provider, symbol string) (model.Quote, error) {
+	result, err := s.breakers.Get(p.breakerName()).Execute(func() (interface{}, error) {
+		start := time.Now()
+		quote, err := s.fetch(p.Addr, symbol)
+		if err != nil {
+			return nil, err
+		}
+		p.latency.Record(time.Since(start))
+		return quote, nil
+	})
+	if err != nil {
+		return model.Quote{}, fmt.Errorf("%s: %w", p.Addr, err)
+	}
+	quote := result.(model.Quote)
+	quote.Provider = p.Addr
+
+	// Cotação absurda aciona o failover, mas não abre o breaker: o provedor
+	// respondeu, e um movimento real não pode derrubá-lo
+	if err := s.validator.Validate(symbol, quote); err != nil {
+		s.metrics.Rejected.Add(1)
+		s.onReject(symbol, quote, err.(*RejectionError).Reason)
+		return model.Quote{}, fmt.Errorf("%s: %w", p.Addr, err)
+	}
+	return quote, nil
+}
+
+// fetchWithFailover percorre os provedores em ordem de preferência. Breakers abertos
+// rejeitam na hora, então um primário fora do ar passa a vez ao próximo sem espera.
+func (s *QuoteService) fetchWithFailover(symbol string) (model.Quote, error) {
+	var errs []error
+	for i := 0; i < len(s.providers); i++ {
+		if s.cfg.Hedge && i+1 < len(s.providers) {
+			quote, err := s.hedgedFetch(s.providers[i], s.providers[i+1], symbol)
+			if err == nil {
+				return quote, nil
+			}
+			errs = append(errs, err)
+			i++ // O secundário já foi tentado pelo hedge
+			continue
+		}
+
+		quote, err := s.callProvider(s.providers[i], symbol)
+		if err == nil {
+			if i > 0 {
+				s.metrics.Failovers.Add(1)
+			}
+			return quote, nil
+		}
+		errs = append(errs, err)
+	}
+	return model.Quote{}, errors.Join(errs...)
+}
+
+// hedgedFetch dispara o primário e, se ele não responder dentro do percentil
+// configurado da sua latência recente, dispara também o secundário. Vence a
+// primeira resposta de sucesso; se o primário falhar antes do prazo, o
+// secundário é acionado imediatamente (failover).
+func (s *QuoteService) hedgedFetch(primary, secondary *Provider, symbol string) (model.Quote, error) {
+	results := make(chan providerResult, 2) // Bufferizado: o perdedor não fica preso
+	launch := func(p *Provider) {
+		go func() {
+			quote, err := s.callProvider(p, symbol)
+			results <- providerResult{quote, err}
+		}()
+	}
+
+	launch(primary)
+	delay := primary.latency.Percentile(s.cfg.HedgePercentile, s.cfg.HedgeDelay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending, hedged := 1, false
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				s.metrics.Hedged.Add(1)
+				launch(secondary)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if res.quote.Provider != primary.Addr {
+					s.metrics.Failovers.Add(1)
+				}
+				return res.quote, nil
+			}
+			errs = append(errs, res.err)
+			if !hedged {
+				hedged = true
+				pending++
+				launch(secondary)
+			}
+		}
+	}
+	return model.Quote{}, errors.Join(errs...)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"distributed-system/pkg/circuitbreaker"
+	"distributed-system/pkg/model"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// cachedQuote guarda a última cotação boa de um símbolo e quando ela foi obtida.
+type cachedQuote struct {
+	quote     model.Quote
+	fetchedAt time.Time
+}
+
+// QuoteCache mantém a última cotação válida ("last known good") por símbolo.
+type QuoteCache struct {
+	mu      sync.RWMutex
+	entries map[string]cachedQuote
+}
+
+func NewQuoteCache() *QuoteCache {
+	return &QuoteCache{entries: make(map[string]cachedQuote)}
+}
+
+// Get retorna a cotação em cache e há quanto tempo ela foi obtida.
+func (c *QuoteCache) Get(symbol string) (model.Quote, time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[symbol]
+	if !ok {
+		return model.Quote{}, 0, false
+	}
+	return entry.quote, time.Since(entry.fetchedAt), true
+}
+
+func (c *QuoteCache) Put(quote model.Quote) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[quote.Symbol] = cachedQuote{quote: quote, fetchedAt: time.Now()}
+}
+
+// QuoteConfig agrupa os parâmetros do QuoteService.
+type QuoteConfig struct {
+	Providers       []string      // Endereços dos provedores, em ordem de preferência
+	TTL             time.Duration // Cache "fresco": dentro deste prazo nem chamamos o externo
+	MaxStale        time.Duration // Limite de idade para servir cotação antiga quando o externo falha
+	Hedge           bool          // Disparar pedido ao secundário se o primário demorar
+	HedgePercentile float64       // Percentil da latência do primário usado como atraso do hedge
+	HedgeDelay      time.Duration // Atraso usado enquanto não há amostras suficientes
+	Validation      ValidationConfig
+}
+
+// QuoteService concentra a obtenção de cotações: cache, Circuit Breaker e fallback.
+type QuoteService struct {
+	cfg       QuoteConfig
+	breakers  *circuitbreaker.Registry
+	providers []*Provider
+	cache     *QuoteCache
+	fetch     func(addr, symbol string) (model.Quote, error)
+	flights   *flightGroup
+	metrics   *Metrics
+	validator *Validator
+	// onReject recebe as cotações descartadas pela validação (quarentena)
+	onReject func(symbol string, quote model.Quote, reason string)
+}
+
+func NewQuoteService(breakers *circuitbreaker.Registry, cfg QuoteConfig) *QuoteService {
+	providers := make([]*Provider, 0, len(cfg.Providers))
+	for _, addr := range cfg.Providers {
+		providers = append(providers, NewProvider(addr))
+	}
+	return &QuoteService{
+		cfg:       cfg,
+		breakers:  breakers,
+		providers: providers,
+		cache:     NewQuoteCache(),
+		fetch:     fetchQuoteFromExternal,
+		flights:   newFlightGroup(),
+		metrics:   &Metrics{},
+		validator: NewValidator(cfg.Validation),
+		onReject:  func(string, model.Quote, string) {},
+	}
+}
+
+// GetQuote devolve a cotação do símbolo. O booleano indica se ela acabou de vir
+// da fonte externa (e portanto deve ser publicada no Broker).
+func (s *QuoteService) GetQuote(symbol string) (model.Quote, bool, error) {
+	s.metrics.QuoteRequests.Add(1)
+
+	// 1. Cache fresco: evita completamente a chamada externa
+	if quote, age, ok := s.cache.Get(symbol); ok && age <= s.cfg.TTL {
+		s.metrics.CacheHits.Add(1)
+		quote.AgeMs = age.Milliseconds()
+		return quote, false, nil
+	}
+
+	// 2. Buscar nos provedores externos, cada um protegido pelo seu Circuit Breaker.
+	// Pedidos concorrentes para o mesmo símbolo compartilham uma única busca.
+	quote, err, shared := s.flights.Do(symbol, func() (model.Quote, error) {
+		s.metrics.UpstreamCalls.Add(1)
+		quote, err := s.fetchWithFailover(symbol)
+		if err != nil {
+			return model.Quote{}, err
+		}
+		s.cache.Put(quote)
+		return quote, nil
+	})
+	if shared {
+		s.metrics.Coalesced.Add(1)
+	}
+	if err == nil {
+		// Apenas quem fez a busca publica; os caronas só respondem ao cliente
+		return quote, !shared, nil
+	}
+
+	// 3. Fallback: última cotação boa, marcada como antiga, se ainda dentro do limite
+	if quote, age, ok := s.cache.Get(symbol); ok && age <= s.cfg.MaxStale {
+		fmt.Printf("[Core] Serving stale %s (age %v) after upstream error: %v\n", symbol, age.Round(time.Millisecond), err)
+		s.metrics.StaleServed.Add(1)
+		quote.Stale = true
+		quote.AgeMs = age.Milliseconds()
+		return quote, false, nil
+	}
+	return model.Quote{}, false, err
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/sha256"
+	"distributed-system/pkg/circuitbreaker"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/quorum"
+	"distributed-system/pkg/ring"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const shardRequestTimeout = 2 * time.Second
+
+// TradeRouter valida operações submetidas contra a cotação atual, atribui um ID
+// e grava a transação no shard dono (anel de hash consistente).
+//
+// No modo quorum (EnableQuorum), a transação vai para todos os nós da
+// partição e a operação é confirmada quando W deles a gravaram.
+type TradeRouter struct {
+	router       *ring.Router
+	tolerancePct float64 // Distância máxima (%) entre o preço da operação e a cotação atual
+	quotes       *QuoteService
+	breakers     *circuitbreaker.Registry
+	seq          atomic.Uint64
+	// store grava no nó e devolve a versão que ficou lá (a original, numa repetição)
+	store       func(addr string, tx model.Transaction) (model.Transaction, error)
+	writeQuorum int // Modo quorum: W padrão (0 = gravar apenas no primário)
+}
+
+func NewTradeRouter(router *ring.Router, tolerancePct float64, quotes *QuoteService, breakers *circuitbreaker.Registry) *TradeRouter {
+	return &TradeRouter{
+		router:       router,
+		tolerancePct: tolerancePct,
+		quotes:       quotes,
+		breakers:     breakers,
+		store:        storeOnShard,
+	}
+}
+
+// EnableQuorum passa a gravar em todos os nós de cada partição, exigindo w
+// confirmações por padrão.
+func (r *TradeRouter) EnableQuorum(w int) error {
+	current, _ := r.router.Topology()
+	if err := validateQuorum(current, w); err != nil {
+		return err
+	}
+	r.writeQuorum = w
+	return nil
+}
+
+func validateQuorum(parts []ring.Partition, w int) error {
+	for _, p := range parts {
+		if err := quorum.Validate(len(p.Nodes()), w, 1); err != nil {
+			return fmt.Errorf("partition %s: %v", p.Primary, err)
+		}
+	}
+	return nil
+}
+
+// ApplyTopology executa um comando de administração da topologia. No modo
+// quorum, a topologia de destino também precisa comportar o W padrão.
+func (r *TradeRouter) ApplyTopology(cmd protocol.TopologyCommand) (ring.Status, error) {
+	if cmd.Action == ring.ActionBegin && r.writeQuorum > 0 {
+		next, err := ring.ParsePartitions(cmd.Shards)
+		if err != nil {
+			return ring.Status{}, err
+		}
+		if err := validateQuorum(next, r.writeQuorum); err != nil {
+			return ring.Status{}, err
+		}
+	}
+	return r.router.Apply(cmd.Action, cmd.Shards)
+}
+
+// Submit valida e grava a operação, retornando a transação confirmada pelo shard.
+//
+// Com chave de idempotência, o ID vem da chave: uma repetição vai para o
+// mesmo dono e os shards respondem com a transação original, que é a devolvida.
+func (r *TradeRouter) Submit(req protocol.TradeRequest) (model.Transaction, error) {
+	if req.Symbol == "" || req.Quantity <= 0 || req.Price <= 0 || math.IsNaN(req.Price) {
+		return model.Transaction{}, fmt.Errorf("invalid trade: symbol, positive price and quantity are required")
+	}
+
+	// A operação precisa estar próxima do preço de mercado atual (cotação fresca)
+	quote, _, err := r.quotes.GetQuote(req.Symbol)
+	if err != nil {
+		return model.Transaction{}, fmt.Errorf("no current quote for %s: %v", req.Symbol, err)
+	}
+	if quote.Stale {
+		return model.Transaction{}, fmt.Errorf("current quote for %s is stale (age %dms)", req.Symbol, quote.AgeMs)
+	}
+	if deviation := math.Abs(req.Price-quote.Price) / quote.Price * 100; deviation > r.tolerancePct {
+		return model.Transaction{}, fmt.Errorf("price %.2f deviates %.2f%% from current quote %.2f (max %.2f%%)",
+			req.Price, deviation, quote.Price, r.tolerancePct)
+	}
+
+	now := time.Now()
+	tx := model.Transaction{
+		ID:             r.nextID(),
+		Symbol:         req.Symbol,
+		Price:          req.Price,
+		Quantity:       req.Quantity,
+		Timestamp:      now,
+		Version:        now.UnixNano(),
+		IdempotencyKey: req.IdempotencyKey,
+	}
+	if req.IdempotencyKey != "" {
+		tx.ID = idempotentID(req.IdempotencyKey)
+	}
+
+	addr := r.router.OwnerOf(tx)
+	if r.writeQuorum == 0 {
+		stored, err := r.storeVia(addr, tx)
+		if err != nil {
+			return model.Transaction{}, fmt.Errorf("shard %s: %v", addr, err)
+		}
+		return stored, nil
+	}
+
+	nodes := r.router.Partition(addr).Nodes()
+	w := r.writeQuorum
+	if req.W != 0 {
+		if err := quorum.Validate(len(nodes), req.W, 1); err != nil {
+			return model.Transaction{}, err
+		}
+		w = req.W
+	}
+	// Numa repetição, os nós que já tinham a original a devolvem; ela prevalece (menor versão)
+	var mu sync.Mutex
+	result := tx
+	_, err = quorum.Write(nodes, w, func(node string) error {
+		stored, err := r.storeVia(node, tx)
+		if err == nil {
+			mu.Lock()
+			if model.Supersedes(stored, result) {
+				result = stored
+			}
+			mu.Unlock()
+		}
+		return err
+	})
+	if err != nil {
+		return model.Transaction{}, fmt.Errorf("partition %s: %v", addr, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return result, nil
+}
+
+// storeVia grava no nó através do breaker dele.
+func (r *TradeRouter) storeVia(node string, tx model.Transaction) (model.Transaction, error) {
+	stored, err := r.breakers.Get("shard:" + node).Execute(func() (interface{}, error) {
+		return r.store(node, tx)
+	})
+	if err != nil {
+		return model.Transaction{}, err
+	}
+	return stored.(model.Transaction), nil
+}
+
+// idempotentID deriva o ID da transação da chave de idempotência.
+func idempotentID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "tx-k-" + hex.EncodeToString(sum[:12])
+}
+
+// nextID gera IDs únicos entre restarts: instante em base 36 + sequência local.
+func (r *TradeRouter) nextID() string {
+	return fmt.Sprintf("tx-%s-%d", strconv.FormatInt(time.Now().UnixNano(), 36), r.seq.Add(1))
+}
+
+func storeOnShard(addr string, tx model.Transaction) (model.Transaction, error) {
+	conn, err := net.DialTimeout("tcp", addr, shardRequestTimeout)
+	if err != nil {
+		return model.Transaction{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(shardRequestTimeout))
+
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgStoreTx, tx)); err != nil {
+		return model.Transaction{}, err
+	}
+
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		return model.Transaction{}, err
+	}
+	if resp.Type == protocol.MsgError {
+		var reason string
+		json.Unmarshal(resp.Payload, &reason)
+		return model.Transaction{}, fmt.Errorf("store rejected: %s", reason)
+	}
+	// A resposta traz a versão que ficou no shard (a original, numa repetição)
+	var stored model.Transaction
+	if err := json.Unmarshal(resp.Payload, &stored); err != nil || stored.ID == "" {
+		return tx, nil
+	}
+	return stored, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"distributed-system/pkg/model"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ValidationConfig define os limites de sanidade aplicados às cotações externas.
+// Valores zero desligam a verificação correspondente.
+type ValidationConfig struct {
+	MaxJumpPct float64       // Variação máxima (%) em relação ao último preço aceito
+	BandWindow int           // Quantidade de preços aceitos usados na banda móvel
+	BandK      float64       // Largura da banda em desvios-padrão em torno da média
+	MaxSkew    time.Duration // Tolerância para timestamps no futuro (relógios dessincronizados)
+	// Rejeições seguidas e coerentes entre si (dentro de MaxJumpPct) que passam
+	// a ser a nova referência do símbolo: um movimento real não fica em
+	// quarentena para sempre (0 desliga)
+	ReanchorAfter int
+}
+
+// Mínimo de preços na janela antes de aplicar a banda móvel
+const minBandSamples = 5
+
+// RejectionError indica que uma cotação foi descartada pela validação.
+type RejectionError struct {
+	Reason string
+}
+
+func (e *RejectionError) Error() string {
+	return "quote rejected: " + e.Reason
+}
+
+// Validator rejeita cotações impossíveis e saltos fora do padrão recente do símbolo.
+type Validator struct {
+	mu      sync.Mutex
+	cfg     ValidationConfig
+	history map[string][]float64 // Últimos preços aceitos por símbolo
+	pending map[string][]float64 // Rejeições seguidas por salto ou banda, candidatas a nova referência
+}
+
+func NewValidator(cfg ValidationConfig) *Validator {
+	return &Validator{cfg: cfg, history: make(map[string][]float64), pending: make(map[string][]float64)}
+}
+
+// Validate verifica a cotação recebida para o símbolo pedido e, se ela for aceita,
+// a incorpora ao histórico usado nas próximas verificações.
+//
+// Depois de ReanchorAfter rejeições seguidas por salto ou banda, todas
+// próximas entre si, o preço é aceito e o histórico recomeça a partir delas.
+func (v *Validator) Validate(symbol string, quote model.Quote) error {
+	if reason := v.checkValues(symbol, quote); reason != "" {
+		return &RejectionError{Reason: reason}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	prices := v.history[symbol]
+	if reason := v.checkOutlier(prices, quote.Price); reason != "" {
+		pending := v.consistent(append(v.pending[symbol], quote.Price))
+		if v.cfg.ReanchorAfter <= 0 || len(pending) < v.cfg.ReanchorAfter {
+			v.pending[symbol] = pending
+			return &RejectionError{Reason: reason}
+		}
+		// Movimento sustentado: as rejeições viram a nova referência
+		prices = pending[:len(pending)-1]
+	}
+	delete(v.pending, symbol)
+
+	prices = append(prices, quote.Price)
+	if limit := v.cfg.BandWindow; limit > 0 && len(prices) > limit {
+		prices = prices[len(prices)-limit:]
+	} else if limit <= 0 {
+		prices = prices[len(prices)-1:]
+	}
+	v.history[symbol] = prices
+	return nil
+}
+
+// consistent mantém o maior sufixo de prices em que todos estão dentro de
+// MaxJumpPct do mais recente.
+func (v *Validator) consistent(prices []float64) []float64 {
+	last := prices[len(prices)-1]
+	start := len(prices) - 1
+	for start > 0 && (v.cfg.MaxJumpPct <= 0 || math.Abs(prices[start-1]-last)/last*100 <= v.cfg.MaxJumpPct) {
+		start--
+	}
+	return prices[start:]
+}
+
+// checkValues rejeita valores impossíveis, independente do histórico.
+func (v *Validator) checkValues(symbol string, quote model.Quote) string {
+	switch {
+	case quote.Symbol != symbol:
+		return fmt.Sprintf("symbol mismatch: requested %s, got %q", symbol, quote.Symbol)
+	case math.IsNaN(quote.Price) || math.IsInf(quote.Price, 0) || quote.Price <= 0:
+		return fmt.Sprintf("invalid price %v", quote.Price)
+	case quote.Timestamp.IsZero():
+		return "missing timestamp"
+	case quote.Timestamp.After(time.Now().Add(v.cfg.MaxSkew)):
+		return fmt.Sprintf("timestamp %s is in the future", quote.Timestamp.Format(time.RFC3339Nano))
+	}
+	return ""
+}
+
+// checkOutlier compara o preço com o último aceito e com a banda móvel.
+func (v *Validator) checkOutlier(prices []float64, price float64) string {
+	if len(prices) == 0 {
+		return ""
+	}
+
+	last := prices[len(prices)-1]
+	if v.cfg.MaxJumpPct > 0 {
+		jump := math.Abs(price-last) / last * 100
+		if jump > v.cfg.MaxJumpPct {
+			return fmt.Sprintf("jump of %.2f%% from last price %.2f exceeds %.2f%%", jump, last, v.cfg.MaxJumpPct)
+		}
+	}
+
+	if v.cfg.BandK > 0 && len(prices) >= minBandSamples {
+		mean, std := meanStd(prices)
+		if std > 0 && math.Abs(price-mean) > v.cfg.BandK*std {
+			return fmt.Sprintf("price %.2f outside rolling band %.2f ± %.2f", price, mean, v.cfg.BandK*std)
+		}
+	}
+	return ""
+}
+
+func meanStd(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
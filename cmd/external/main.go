@@ -4,20 +4,26 @@ import (
 	"distributed-system/pkg/model"
 	"distributed-system/pkg/protocol"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"math/rand"
 	"net"
 	"time"
 )
 
+var port = flag.String("port", "8080", "Port to listen on (run several instances to simulate multiple providers)")
+
 func main() {
-	listener, err := net.Listen("tcp", ":8080")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", ":"+*port)
 	if err != nil {
 		panic(err)
 	}
 	defer listener.Close()
 
-	fmt.Println("External Quote Service (Mock) running on :8080")
+	fmt.Printf("External Quote Service (Mock) running on :%s\n", *port)
 
 	for {
 		conn, err := listener.Accept()
@@ -50,10 +56,17 @@ func handleConnection(conn net.Conn) {
 				time.Sleep(2 * time.Second)
 			}
 
+			// O símbolo é opcional no pedido (compatibilidade com clientes antigos)
+			var req protocol.QuoteRequest
+			json.Unmarshal(msg.Payload, &req)
+			if req.Symbol == "" {
+				req.Symbol = "PETR4"
+			}
+
 			// Resposta de Sucesso
 			quote := model.Quote{
-				Symbol:    "PETR4",
-				Price:     20.0 + r.Float64()*10,
+				Symbol:    req.Symbol,
+				Price:     basePrice(req.Symbol) + r.Float64()*10,
 				Timestamp: time.Now(),
 			}
 			
@@ -66,3 +79,15 @@ func handleConnection(conn net.Conn) {
 		}
 	}
 }
+
+// basePrice dá a cada símbolo uma faixa de preço estável (PETR4 fica em 20-30).
+// A base mínima de 20 mantém a oscilação de 0-10 abaixo de 50%, dentro do
+// -max-jump-pct padrão do Core.
+func basePrice(symbol string) float64 {
+	if symbol == "PETR4" {
+		return 20.0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return float64(20 + h.Sum32()%80)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"distributed-system/pkg/indicators"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/pubsub"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/ring"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	port         = flag.String("port", "8084", "Port for on-demand indicator queries")
+	brokerAddr   = flag.String("broker", "localhost:8081", "Broker address")
+	symbols      = flag.String("symbols", "PETR4", "Comma-separated symbols to track")
+	shardAddrs   = flag.String("shards", "localhost:9001,localhost:9002,localhost:9003", "Comma-separated shards used for VWAP, each as primary|replica|...")
+	period       = flag.Int("period", 20, "Number of quotes for SMA, EMA and Bollinger")
+	bollingerK   = flag.Float64("k", 2, "Bollinger band width in standard deviations")
+	vwapInterval = flag.Duration("vwap-interval", 30*time.Second, "How often transactions are pulled from the shards for VWAP")
+)
+
+const requestTimeout = 2 * time.Second
+
+// vwapLateness é quanto antes da última transação vista de uma partição a
+// leitura seguinte recomeça, para pegar gravações que chegaram fora de ordem
+// (as repetidas são descartadas pelo ID).
+const vwapLateness = time.Minute
+
+func main() {
+	flag.Parse()
+
+	set := indicators.NewSet(*period, *bollingerK)
+	publisher := pubsub.NewPublisher(*brokerAddr)
+
+	// 1. Cotações do Broker alimentam SMA, EMA e Bollinger
+	subscriber := pubsub.NewSubscriber(*brokerAddr, strings.Split(*symbols, ","), func(msg protocol.Message) {
+		var quote model.Quote
+		if err := json.Unmarshal(msg.Payload, &quote); err != nil || quote.Symbol == "" {
+			return
+		}
+		publish(publisher, set.AddQuote(quote))
+	})
+	go subscriber.Run()
+
+	// 2. Transações dos shards alimentam o VWAP
+	parts, err := ring.ParsePartitions(*shardAddrs)
+	if err != nil {
+		panic(err)
+	}
+	go pollShards(set, publisher, parts)
+
+	// 3. Consultas sob demanda
+	listener, err := net.Listen("tcp", ":"+*port)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("Indicator Service running on :%s (period %d)\n", *port, *period)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			continue
+		}
+		go handleRequest(conn, set)
+	}
+}
+
+// publish envia a atualização ao tópico "indicators.<SYMBOL>".
+func publish(publisher *pubsub.Publisher, snap model.Indicators) {
+	payload, _ := json.Marshal(snap)
+	if err := publisher.Publish("indicators."+snap.Symbol, payload); err != nil {
+		fmt.Println("Error publishing indicators:", err)
+	}
+}
+
+// pollShards lê de cada partição só as transações novas: a partir da última
+// vista (ou do início da sessão do VWAP), página a página.
+func pollShards(set *indicators.Set, publisher *pubsub.Publisher, parts []ring.Partition) {
+	last := make(map[string]time.Time) // Última transação vista por partição
+	for {
+		for _, p := range parts {
+			from := set.VWAPSession()
+			if seen := last[p.Primary].Add(-vwapLateness); seen.After(from) {
+				from = seen
+			}
+			q := query.Query{From: from, Order: query.OrderAsc, Limit: query.MaxLimit}
+			for {
+				page, err := queryPartition(p, q)
+				if err != nil {
+					fmt.Printf("Error fetching history from Shard(%s): %v\n", p.Primary, err)
+					break
+				}
+				for _, tx := range page.Transactions {
+					if tx.Timestamp.After(last[p.Primary]) {
+						last[p.Primary] = tx.Timestamp
+					}
+				}
+				for _, symbol := range set.AddTransactions(page.Transactions) {
+					if snap, ok := set.Snapshot(symbol); ok {
+						publish(publisher, snap)
+					}
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+		}
+		time.Sleep(*vwapInterval)
+	}
+}
+
+func handleRequest(conn net.Conn, set *indicators.Set) {
+	defer conn.Close()
+
+	var msg protocol.Message
+	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		return
+	}
+	if msg.Type != protocol.MsgReqIndicators {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, fmt.Sprintf("unknown request type %q", msg.Type)))
+		return
+	}
+
+	var req protocol.IndicatorRequest
+	json.Unmarshal(msg.Payload, &req)
+	snap, ok := set.Snapshot(req.Symbol)
+	if !ok {
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, fmt.Sprintf("no data for symbol %q", req.Symbol)))
+		return
+	}
+	protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespIndicators, snap))
+}
+
+// queryPartition consulta o primário da partição e, se ele falhar, cada réplica em ordem.
+func queryPartition(p ring.Partition, q query.Query) (query.Page, error) {
+	var errs []string
+	for _, node := range p.Nodes() {
+		page, err := queryShard(node, q)
+		if err == nil {
+			return page, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", node, err))
+	}
+	return query.Page{}, fmt.Errorf("all nodes failed (%s)", strings.Join(errs, "; "))
+}
+
+// queryShard pede ao shard uma página do histórico filtrada por q.
+func queryShard(addr string, q query.Query) (query.Page, error) {
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return query.Page{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReqHistory, q)); err != nil {
+		return query.Page{}, err
+	}
+
+	var msg protocol.Message
+	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		return query.Page{}, err
+	}
+	if msg.Type == protocol.MsgError {
+		return query.Page{}, fmt.Errorf("%s", msg.Payload)
+	}
+
+	var page query.Page
+	if err := json.Unmarshal(msg.Payload, &page); err != nil {
+		return query.Page{}, err
+	}
+	return page, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestAggregatePushdown valida que o shard devolve só os parciais por faixa,
+// uma fração do tamanho das transações que eles resumem.
+func TestAggregatePushdown(t *testing.T) {
+	s, addr := startShard(t, "Shard-1")
+	base := time.Now().Truncate(time.Hour)
+	for i := 0; i < 5000; i++ {
+		tx := testTx(i)
+		tx.Price = 20 + float64(i%10)
+		tx.Timestamp = base.Add(time.Duration(i) * 100 * time.Millisecond) // 500s: 9 faixas de 1m
+		s.store.Put(tx)
+	}
+
+	req := aggregate.Request{Query: query.Query{Symbol: "PETR4"}, Bucket: time.Minute}
+	resp, err := request(addr, protocol.NewMessage(protocol.MsgReqAggregate, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != protocol.MsgRespAggregate {
+		t.Fatalf("Resposta inesperada: %s %s", resp.Type, resp.Payload)
+	}
+	var partials []aggregate.Partial
+	json.Unmarshal(resp.Payload, &partials)
+
+	var count, qty int64
+	for _, p := range partials {
+		count += p.Count
+		qty += p.Quantity
+	}
+	if len(partials) != 9 || count != 5000 || qty != 500000 || partials[0].MinPrice != 20 || partials[0].MaxPrice != 29 {
+		t.Fatalf("Esperava 9 faixas cobrindo 5000 transações, recebido %d faixas e %d transações", len(partials), count)
+	}
+
+	page, _ := s.store.Query(query.Query{Symbol: "PETR4", Limit: query.MaxLimit})
+	raw, _ := json.Marshal(page)
+	transactions := 5 * len(raw) // 5000 transações ocupam 5 páginas dessas
+	if len(resp.Payload)*100 > transactions {
+		t.Errorf("Esperava parciais ordens de grandeza menores: %d bytes contra %d bytes de transações", len(resp.Payload), transactions)
+	}
+
+	bad := aggregate.Request{Bucket: time.Millisecond}
+	if resp, _ := request(addr, protocol.NewMessage(protocol.MsgReqAggregate, bad)); resp.Type != protocol.MsgError {
+		t.Errorf("Esperava a rejeição de uma faixa menor que um segundo, recebido %s", resp.Type)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"distributed-system/pkg/merkle"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+const antiEntropyTimeout = 5 * time.Second
+
+// antiEntropyStats resume a comparação com os pares, exposta nas métricas.
+type antiEntropyStats struct {
+	Peers           []string  `json:"peers"`
+	Rounds          int       `json:"rounds"`           // Comparações feitas (uma por par a cada intervalo)
+	DivergentRounds int       `json:"divergent_rounds"` // Comparações em que as raízes diferiam
+	RangesSynced    int       `json:"ranges_synced"`    // Faixas divergentes sincronizadas
+	Pulled          int       `json:"pulled"`           // Transações e remoções recebidas dos pares
+	Pushed          int       `json:"pushed"`           // Transações e remoções enviadas aos pares
+	LastRound       time.Time `json:"last_round,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+}
+
+// syncResult é o efeito de uma comparação com um par.
+type syncResult struct {
+	Ranges int
+	Pulled int
+	Pushed int
+}
+
+// AntiEntropy compara periodicamente a árvore de Merkle do shard com a de
+// cada par (os outros nós da partição no modo quorum). Quando as raízes
+// diferem, só as faixas divergentes são trocadas, e cada lado fica com a
+// versão que prevalece de cada transação (model.Supersedes). As remoções entram na árvore como
+// tombstones e também são trocadas: um tombstone vence as versões até a
+// dele, para que um par que não recebeu a remoção não ressuscite a transação.
+func (s *Shard) AntiEntropy(peers []string, interval time.Duration) {
+	s.mu.Lock()
+	s.repair.Peers = peers
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, peer := range peers {
+				res, err := s.syncWith(peer)
+				if err != nil {
+					fmt.Printf("[%s] Anti-entropy with %s failed: %v\n", s.id, peer, err)
+					continue
+				}
+				if res.Ranges > 0 {
+					fmt.Printf("[%s] Anti-entropy with %s: %d ranges, %d pulled, %d pushed\n", s.id, peer, res.Ranges, res.Pulled, res.Pushed)
+				}
+			}
+		}
+	}()
+}
+
+// PruneTombstones descarta periodicamente as remoções mais antigas que ttl.
+// Os tombstones guardam o instante da remoção original, então todos os nós
+// da partição descartam os mesmos e as árvores voltam a coincidir. ttl
+// precisa cobrir o tempo que um par ou réplica pode ficar fora do ar: quem
+// volta depois disso sem ter recebido a remoção ressuscitaria a transação.
+func (s *Shard) PruneTombstones(ttl time.Duration) {
+	go func() {
+		for now := range time.Tick(ttl / 10) {
+			s.pruneTombstones(now.Add(-ttl))
+		}
+	}()
+}
+
+// pruneTombstones faz uma rodada de descarte dos tombstones anteriores a before.
+func (s *Shard) pruneTombstones(before time.Time) int {
+	n, err := s.store.PruneTombstones(before)
+	if err != nil {
+		fmt.Printf("[%s] Tombstone pruning failed: %v\n", s.id, err)
+	}
+	if n > 0 {
+		fmt.Printf("[%s] Pruned %d tombstones older than %s\n", s.id, n, before.Format(time.RFC3339))
+	}
+	return n
+}
+
+// syncWith faz uma rodada de anti-entropia com o par.
+func (s *Shard) syncWith(peer string) (syncResult, error) {
+	res, err := s.compareWith(peer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repair.Rounds++
+	s.repair.LastRound = time.Now()
+	s.repair.LastError = ""
+	if err != nil {
+		s.repair.LastError = fmt.Sprintf("%s: %v", peer, err)
+	}
+	if res.Ranges > 0 {
+		s.repair.DivergentRounds++
+		s.repair.RangesSynced += res.Ranges
+		s.repair.Pulled += res.Pulled
+		s.repair.Pushed += res.Pushed
+	}
+	return res, err
+}
+
+func (s *Shard) compareWith(peer string) (syncResult, error) {
+	var res syncResult
+	tree := s.store.Tree()
+	buckets, err := merkle.Diff(tree, func(level int, nodes []int) ([]uint64, error) {
+		var resp protocol.MerkleResponse
+		if err := peerCall(peer, protocol.NewMessage(protocol.MsgReqMerkle, protocol.MerkleRequest{Level: level, Nodes: nodes}), &resp); err != nil {
+			return nil, err
+		}
+		if resp.Depth != tree.Depth() {
+			return nil, fmt.Errorf("peer tree depth %d differs from local %d", resp.Depth, tree.Depth())
+		}
+		return resp.Hashes, nil
+	})
+	if err != nil || len(buckets) == 0 {
+		return res, err
+	}
+	res.Ranges = len(buckets)
+
+	var theirs rangeResponse
+	if err := peerCall(peer, protocol.NewMessage(protocol.MsgReqRange, protocol.RangeRequest{Buckets: buckets}), &theirs); err != nil {
+		return res, err
+	}
+	ours := s.rangeContents(buckets)
+	mine, mineDead := ours.versions()
+	remote, remoteDead := theirs.versions()
+
+	changed := false
+	for _, tx := range theirs.Transactions {
+		if cur, ok := mine[tx.ID]; ok && !model.Supersedes(tx, cur) {
+			continue
+		}
+		if v, ok := mineDead[tx.ID]; ok && v >= tx.Version {
+			continue
+		}
+		put, err := s.store.Put(tx)
+		if err != nil {
+			return res, err
+		}
+		if put.Applied {
+			res.Pulled++
+			changed = true
+		}
+	}
+	for _, t := range theirs.Deleted {
+		ok, err := s.store.Tombstone(t)
+		if err != nil {
+			return res, err
+		}
+		if ok {
+			res.Pulled++
+			changed = true
+		}
+	}
+	if changed {
+		s.notifyChanged()
+	}
+	for _, tx := range ours.Transactions {
+		if cur, ok := remote[tx.ID]; ok && !model.Supersedes(tx, cur) {
+			continue
+		}
+		if v, ok := remoteDead[tx.ID]; ok && v >= tx.Version {
+			continue
+		}
+		if err := peerCall(peer, protocol.NewMessage(protocol.MsgStoreTx, tx), nil); err != nil {
+			return res, err
+		}
+		res.Pushed++
+	}
+	for _, t := range ours.Deleted {
+		if cur, ok := remote[t.ID]; ok && cur.Version > t.Version {
+			continue
+		}
+		if v, ok := remoteDead[t.ID]; ok && v >= t.Version {
+			continue
+		}
+		req := protocol.DeleteRequest{ID: t.ID, Tombstone: true, Version: t.Version, DeletedAt: t.DeletedAt}
+		if err := peerCall(peer, protocol.NewMessage(protocol.MsgDeleteTx, req), nil); err != nil {
+			return res, err
+		}
+		res.Pushed++
+	}
+	return res, nil
+}
+
+// rangeResponse é o conteúdo de faixas da árvore: as transações vivas e os
+// tombstones dos IDs removidos.
+type rangeResponse struct {
+	Transactions []model.Transaction `json:"transactions"`
+	Deleted      []storage.Tombstone `json:"deleted,omitempty"`
+}
+
+// versions devolve cada transação viva e a versão de cada tombstone, por ID.
+func (r rangeResponse) versions() (live map[string]model.Transaction, dead map[string]int64) {
+	live = make(map[string]model.Transaction, len(r.Transactions))
+	for _, tx := range r.Transactions {
+		live[tx.ID] = tx
+	}
+	dead = make(map[string]int64, len(r.Deleted))
+	for _, t := range r.Deleted {
+		dead[t.ID] = t.Version
+	}
+	return live, dead
+}
+
+// rangeContents devolve as transações e os tombstones que caem nas faixas pedidas.
+func (s *Shard) rangeContents(buckets []int) rangeResponse {
+	tree := s.store.Tree()
+	want := make(map[int]bool, len(buckets))
+	for _, b := range buckets {
+		want[b] = true
+	}
+	resp := rangeResponse{Transactions: []model.Transaction{}}
+	s.store.Scan(func(tx model.Transaction) bool {
+		if want[tree.Bucket(tx.ID)] {
+			resp.Transactions = append(resp.Transactions, tx)
+		}
+		return true
+	})
+	s.store.Tombstones(func(t storage.Tombstone) bool {
+		if want[tree.Bucket(t.ID)] {
+			resp.Deleted = append(resp.Deleted, t)
+		}
+		return true
+	})
+	return resp
+}
+
+// peerCall faz uma requisição a outro shard e decodifica a resposta em out.
+func peerCall(addr string, msg protocol.Message, out interface{}) error {
+	conn, err := net.DialTimeout("tcp", addr, antiEntropyTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(antiEntropyTimeout))
+
+	if err := protocol.SendJSON(conn, msg); err != nil {
+		return err
+	}
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Type == protocol.MsgError {
+		return fmt.Errorf("%s", resp.Payload)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(resp.Payload, out)
+}
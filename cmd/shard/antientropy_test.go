@@ -0,0 +1,159 @@
+package main
+
+import (
+	"distributed-system/pkg/protocol"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestAntiEntropyRepairsOnlyDivergentRanges valida que dois pares que
+// divergiram convergem trocando apenas as faixas diferentes, e que o reparo
+// aparece nas métricas.
+func TestAntiEntropyRepairsOnlyDivergentRanges(t *testing.T) {
+	a, _ := startShard(t, "Shard-A1")
+	b, bAddr := startShard(t, "Shard-A2")
+	for i := 0; i < 2000; i++ {
+		tx := testTx(i)
+		tx.Version = 1
+		a.store.Put(tx)
+		b.store.Put(tx)
+	}
+
+	// Divergências: gravações que só chegaram a um dos lados e uma versão nova só em b
+	for i := 2000; i < 2003; i++ {
+		a.store.Put(testTx(i))
+	}
+	b.store.Put(testTx(2003))
+	newer := testTx(10)
+	newer.Version, newer.Price = 2, 30
+	b.store.Put(newer)
+
+	res, err := a.syncWith(bAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Pulled != 2 || res.Pushed != 3 {
+		t.Errorf("Esperava 2 puxadas e 3 enviadas, recebido %+v", res)
+	}
+	if res.Ranges == 0 || res.Ranges > 5 {
+		t.Errorf("Esperava sincronizar apenas as poucas faixas divergentes, recebido %d", res.Ranges)
+	}
+	if a.store.Tree().Root() != b.store.Tree().Root() || a.store.Len() != 2004 {
+		t.Fatalf("Esperava pares convergidos com 2004 registros, recebido %d e %d", a.store.Len(), b.store.Len())
+	}
+	if got, _ := a.store.Get("tx-10"); got.Version != 2 {
+		t.Errorf("Esperava a versão mais nova de tx-10, recebido %+v", got)
+	}
+
+	// Já convergidos: a próxima rodada só compara as raízes
+	if res, _ := a.syncWith(bAddr); res.Ranges != 0 {
+		t.Errorf("Esperava nenhuma divergência após o reparo, recebido %+v", res)
+	}
+
+	a.AntiEntropy([]string{bAddr}, time.Hour) // Só registra o par; as rodadas acima já foram feitas
+	m := a.replicationMetrics()
+	var stats antiEntropyStats
+	data, _ := json.Marshal(m["anti_entropy"])
+	json.Unmarshal(data, &stats)
+	if stats.Rounds != 2 || stats.DivergentRounds != 1 || stats.Pulled != 2 || stats.Pushed != 3 {
+		t.Errorf("Métricas de anti-entropia inesperadas: %+v", stats)
+	}
+
+	// O par também responde às consultas de árvore pelo protocolo
+	resp, err := request(bAddr, protocol.NewMessage(protocol.MsgReqMerkle, protocol.MerkleRequest{Level: 0, Nodes: []int{0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree protocol.MerkleResponse
+	json.Unmarshal(resp.Payload, &tree)
+	if len(tree.Hashes) != 1 || tree.Hashes[0] != a.store.Tree().Root() {
+		t.Errorf("Esperava a raiz %x do par, recebido %+v", a.store.Tree().Root(), tree)
+	}
+}
+
+// TestAntiEntropyDoesNotResurrectDeletes valida que uma remoção que chegou a
+// só um dos pares (como a limpeza de um reshard no modo quorum, nó a nó) se
+// propaga como tombstone em vez de a transação voltar do outro par.
+func TestAntiEntropyDoesNotResurrectDeletes(t *testing.T) {
+	a, aAddr := startShard(t, "Shard-A1")
+	b, bAddr := startShard(t, "Shard-A2")
+	for i := 0; i < 100; i++ {
+		tx := testTx(i)
+		tx.Version = 1
+		a.store.Put(tx)
+		b.store.Put(tx)
+	}
+	if ok, _ := a.store.Delete("tx-5"); !ok {
+		t.Fatal("Esperava tx-5 removida em a")
+	}
+	if ok, _ := b.store.Delete("tx-7"); !ok {
+		t.Fatal("Esperava tx-7 removida em b")
+	}
+
+	res, err := a.syncWith(bAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Pulled != 1 || res.Pushed != 1 {
+		t.Errorf("Esperava 1 tombstone puxado e 1 enviado, recebido %+v", res)
+	}
+	for _, s := range []*Shard{a, b} {
+		for _, id := range []string{"tx-5", "tx-7"} {
+			if _, ok := s.store.Get(id); ok {
+				t.Errorf("Esperava %s removida em %s, mas ela voltou", id, s.id)
+			}
+		}
+	}
+	if a.store.Tree().Root() != b.store.Tree().Root() || a.store.Len() != 98 || b.store.Len() != 98 {
+		t.Fatalf("Esperava pares convergidos com 98 registros, recebido %d e %d", a.store.Len(), b.store.Len())
+	}
+	if res, _ := b.syncWith(aAddr); res.Ranges != 0 {
+		t.Errorf("Esperava nenhuma divergência após o reparo, recebido %+v", res)
+	}
+
+	// Uma versão gravada depois da remoção vence o tombstone
+	newer := testTx(5)
+	newer.Version = 2
+	b.store.Put(newer)
+	if _, err := a.syncWith(bAddr); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := a.store.Get("tx-5"); !ok || got.Version != 2 {
+		t.Errorf("Esperava a versão 2 de tx-5 após a remoção, recebido %+v %v", got, ok)
+	}
+	if a.store.Tree().Root() != b.store.Tree().Root() {
+		t.Error("Esperava raízes iguais após regravar tx-5")
+	}
+}
+
+// TestAntiEntropyKeepsEarliestKeyedWrite valida que, numa transação com chave
+// de idempotência, a anti-entropia leva aos dois pares a escrita mais antiga
+// (a confirmada), e não a repetição de versão maior.
+func TestAntiEntropyKeepsEarliestKeyedWrite(t *testing.T) {
+	a, aAddr := startShard(t, "Shard-A1")
+	b, bAddr := startShard(t, "Shard-A2")
+	original := testTx(1)
+	original.Version, original.IdempotencyKey = 1, "order-1"
+	retry := original
+	retry.Version, retry.Price = 2, 99
+	a.store.Put(original)
+	b.store.Put(retry)
+
+	for _, sync := range []func() (syncResult, error){
+		func() (syncResult, error) { return b.syncWith(aAddr) },
+		func() (syncResult, error) { return a.syncWith(bAddr) },
+	} {
+		if _, err := sync(); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []*Shard{a, b} {
+			if got, _ := s.store.Get(original.ID); got.Version != 1 {
+				t.Errorf("Esperava a escrita original (versão 1) em %s, recebido %+v", s.id, got)
+			}
+		}
+	}
+	if a.store.Tree().Root() != b.store.Tree().Root() {
+		t.Error("Esperava raízes iguais após a anti-entropia")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/retention"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestOnlineBackupWhileWriting valida que ADMIN_BACKUP gera, com gravações
+// em andamento, um backup que restaura exatamente o que existia no LSN dele.
+func TestOnlineBackupWhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "data"), storage.Options{Sync: storage.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	shard := NewShard("Shard-A", 0, store)
+	go shard.Serve(listener)
+	addr := listener.Addr().String()
+
+	for i := 0; i < 100; i++ {
+		storeTx(addr, testTx(i))
+	}
+	store.Snapshot()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 100; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				storeTx(addr, testTx(i))
+			}
+		}
+	}()
+	waitFor(t, "gravações em andamento", func() bool { return store.LastLSN() > 150 })
+	resp, err := request(addr, protocol.NewMessage(protocol.MsgAdminBackup, protocol.BackupRequest{Dir: filepath.Join(dir, "backup")}))
+	waitFor(t, "mais gravações depois do backup", func() bool { return store.LastLSN() > 300 })
+	close(stop)
+	<-done
+	if err != nil || resp.Type != protocol.MsgRespAdmin {
+		t.Fatalf("Backup falhou: %v %s", err, resp.Payload)
+	}
+	var info storage.BackupInfo
+	json.Unmarshal(resp.Payload, &info)
+	if info.BaseLSN != 100 || info.LSN <= 150 || info.LSN >= store.LastLSN() {
+		t.Fatalf("Faixa do backup inesperada: %+v (shard em %d)", info, store.LastLSN())
+	}
+
+	res, err := storage.Restore(info.Dir, filepath.Join(dir, "restored"), storage.RestorePoint{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sem sobrescritas, o estado no LSN do backup são as gravações até ele
+	if res.LSN != info.LSN || res.Transactions != int(info.LSN) {
+		t.Errorf("Restauração deveria ter as %d transações até o LSN do backup: %+v", info.LSN, res)
+	}
+	restored, err := storage.Open(filepath.Join(dir, "restored"), storage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	for _, e := range store.Since(0, int(store.LastLSN())) {
+		_, ok := restored.Get(e.Tx.ID)
+		if ok != (e.LSN <= info.LSN) {
+			t.Errorf("%s (LSN %d) presente na restauração: %v", e.Tx.ID, e.LSN, ok)
+		}
+	}
+}
+
+// TestBackupRestoresCompactedHistory valida que o backup de um shard com
+// retenção leva os agregados compactados, que a restauração devolve os mesmos
+// agregados e que o backup de uma réplica, sem eles, é recusado.
+func TestBackupRestoresCompactedHistory(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.Open(filepath.Join(dir, "data"), storage.Options{Sync: storage.SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	primary := NewShard("Shard-A", 0, store)
+	go primary.Serve(listener)
+	primaryAddr := listener.Addr().String()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	for i := 0; i < 3*24*60; i += 5 { // Uma transação a cada 5 minutos nos últimos 3 dias
+		store.Put(model.Transaction{
+			ID:        fmt.Sprintf("tx-%d", i),
+			Symbol:    "PETR4",
+			Price:     20 + float64(i%7),
+			Quantity:  10 * (i%5 + 1),
+			Timestamp: now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+	replica, replicaAddr := startShard(t, "Shard-A-replica")
+	replica.Follow(primaryAddr)
+
+	policy, _ := retention.ParsePolicy("*=raw:1d,1h")
+	rollups, _ := retention.OpenRollups(filepath.Join(dir, "data", retention.RollupsFile))
+	if _, err := primary.compact(primary.retention(policy, rollups), now); err != nil {
+		t.Fatal(err)
+	}
+	aggregates := func(addr string) aggregate.Partial {
+		req := aggregate.Request{Query: query.Query{Symbol: "PETR4"}}
+		resp, err := request(addr, protocol.NewMessage(protocol.MsgReqAggregate, req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var partials []aggregate.Partial
+		json.Unmarshal(resp.Payload, &partials)
+		if len(partials) != 1 {
+			t.Fatalf("Esperava um agregado do período inteiro, recebido %s", resp.Payload)
+		}
+		return partials[0]
+	}
+	before := aggregates(primaryAddr)
+
+	resp, err := request(primaryAddr, protocol.NewMessage(protocol.MsgAdminBackup, protocol.BackupRequest{Dir: filepath.Join(dir, "backup")}))
+	if err != nil || resp.Type != protocol.MsgRespAdmin {
+		t.Fatalf("Backup falhou: %v %s", err, resp.Payload)
+	}
+	var info storage.BackupInfo
+	json.Unmarshal(resp.Payload, &info)
+	if len(info.Expired) != 1 || info.Expired[0] != "PETR4" {
+		t.Errorf("Esperava PETR4 entre os símbolos expirados do backup, recebido %+v", info)
+	}
+
+	// A réplica não tem os agregados: o backup dela perderia o histórico compactado
+	waitFor(t, "expiração na réplica", func() bool { return replica.store.LastLSN() == store.LastLSN() })
+	replicaDir := filepath.Join(dir, "replica-backup")
+	resp, _ = request(replicaAddr, protocol.NewMessage(protocol.MsgAdminBackup, protocol.BackupRequest{Dir: replicaDir}))
+	if resp.Type != protocol.MsgError {
+		t.Errorf("Esperava o backup da réplica recusado, recebido %s", resp.Payload)
+	}
+	if _, err := os.Stat(replicaDir); !os.IsNotExist(err) {
+		t.Errorf("Esperava o backup recusado descartado, recebido %v", err)
+	}
+
+	restoredDir := filepath.Join(dir, "restored")
+	if _, err := storage.Restore(info.Dir, restoredDir, storage.RestorePoint{}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := retention.RestoreRollups(info.Dir, restoredDir); !ok || err != nil {
+		t.Fatalf("Esperava os agregados restaurados, recebido %v %v", ok, err)
+	}
+	restoredStore, err := storage.Open(restoredDir, storage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredStore.Close()
+	restoredRollups, err := retention.OpenRollups(filepath.Join(restoredDir, retention.RollupsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredListener.Close()
+	restored := NewShard("Shard-A-restored", 0, restoredStore)
+	restored.retention(policy, restoredRollups)
+	go restored.Serve(restoredListener)
+
+	if restoredStore.Len() != store.Len() {
+		t.Errorf("Esperava %d transações brutas restauradas, recebido %d", store.Len(), restoredStore.Len())
+	}
+	if after := aggregates(restoredListener.Addr().String()); after.Count != before.Count || after.Quantity != before.Quantity || after.MinPrice != before.MinPrice || after.MaxPrice != before.MaxPrice {
+		t.Errorf("Agregados mudaram com a restauração: antes %+v, depois %+v", before, after)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"distributed-system/pkg/bulk"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/ring"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBulkImportAndExport valida a importação em lotes (STORE_BATCH) em
+// shards reais, a réplica recusando lotes e a exportação de volta.
+func TestBulkImportAndExport(t *testing.T) {
+	_, addrA := startShard(t, "Shard-A")
+	shardB, addrB := startShard(t, "Shard-B")
+	replica, replicaAddr := startShard(t, "Shard-B-replica")
+	replica.Follow(addrB)
+
+	var input strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&input, `{"id":"tx-%d","symbol":"SYM%d","price":%d,"quantity":1,"timestamp":"2024-03-01T10:00:%02dZ"}`+"\n", i, i%10, 10+i%5, i%60)
+	}
+	input.WriteString(`{"id":"tx-0","symbol":"SYM0","price":10,"quantity":1,"timestamp":"2024-03-01T10:00:00Z"}` + "\n")
+
+	parts, _ := ring.ParsePartitions(addrA + "," + addrB + "|" + replicaAddr)
+	router, _ := ring.NewPartitionedRouter(parts, ring.KeyBySymbol)
+	shards := bulk.TCPShards{Timeout: time.Second}
+	im := &bulk.Importer{Router: router, Sink: shards, BatchSize: 50}
+	r, _ := bulk.NewReader(strings.NewReader(input.String()), bulk.FormatJSONL)
+	stats, err := im.Import(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Imported != 300 || stats.Duplicates != 1 {
+		t.Errorf("Esperava 300 importadas e 1 duplicada: %+v", stats)
+	}
+	waitFor(t, "lotes na réplica", func() bool { return replica.store.Len() == shardB.store.Len() })
+
+	if _, err := shards.StoreBatch(replicaAddr, []model.Transaction{testTx(1000)}); err == nil {
+		t.Error("Réplica deveria recusar lotes")
+	}
+	if _, err := shards.StoreBatch(addrA, []model.Transaction{{Symbol: "SYM0"}}); err == nil {
+		t.Error("Lote com transação sem ID deveria ser recusado")
+	}
+
+	var out strings.Builder
+	w, _ := bulk.NewWriter(&out, bulk.FormatCSV)
+	e := &bulk.Exporter{Partitions: parts, Source: shards}
+	n, err := e.Export(query.Query{Symbol: "SYM3"}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(out.String(), "\n"); n != 30 || lines != 31 {
+		t.Errorf("Esperava 30 transações de SYM3 (mais o cabeçalho), exportou %d em %d linhas", n, lines)
+	}
+}
@@ -2,79 +2,227 @@ package main
 
 import (
 	"distributed-system/pkg/model"
-	"distributed-system/pkg/protocol"
-	"encoding/json"
+	"distributed-system/pkg/retention"
+	"distributed-system/pkg/ring"
+	"distributed-system/pkg/storage"
 	"flag"
 	"fmt"
 	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 )
 
 var (
-	port  = flag.String("port", "9001", "Port to listen on")
-	id    = flag.String("id", "Shard-A", "Shard ID")
-	delay = flag.Int("delay", 100, "Artificial processing delay in ms (to demonstrate parallelism)")
+	port             = flag.String("port", "9001", "Port to listen on")
+	id               = flag.String("id", "Shard-A", "Shard ID")
+	delay            = flag.Int("delay", 100, "Artificial processing delay in ms (to demonstrate parallelism)")
+	dataDir          = flag.String("data-dir", "", "Directory for the write-ahead log and snapshots (empty keeps data in memory only)")
+	fsync            = flag.String("fsync", string(storage.SyncAlways), "WAL fsync policy: always, interval or none")
+	fsyncInterval    = flag.Duration("fsync-interval", 100*time.Millisecond, "Time between fsyncs with -fsync=interval")
+	snapshotInterval = flag.Duration("snapshot-interval", time.Minute, "Time between snapshots that compact the WAL (0 disables)")
+	replicaOf        = flag.String("replica-of", "", "Run as a read-only replica of the shard at this address")
+	peers            = flag.String("peers", "", "Comma-separated peer nodes of this partition (quorum mode) to repair with anti-entropy")
+	antiEntropy      = flag.Duration("anti-entropy-interval", 30*time.Second, "Time between Merkle tree comparisons with each peer")
+	tombstoneTTL     = flag.Duration("tombstone-ttl", 7*24*time.Hour, "Age after which deletion tombstones are dropped; must exceed the longest a peer or replica stays offline (0 keeps them)")
+	empty            = flag.Bool("empty", false, "Start without sample data (new node joining the cluster through shardctl reshard)")
+	retain           = flag.String("retention", "", "Per-symbol retention, e.g. PETR4=raw:7d,1m:30d,1h;*=raw:30d,1h:365d (empty keeps everything)")
+	compactInterval  = flag.Duration("compact-interval", time.Minute, "Time between retention compaction rounds")
+	restoreFrom      = flag.String("restore", "", "Restore the backup in this directory into an empty -data-dir and exit")
+	restoreLSN       = flag.Uint64("restore-lsn", 0, "With -restore, stop at this log position (0 = end of the backup)")
+	restoreTime      = flag.String("restore-time", "", "With -restore, stop at the writes made up to this time (RFC 3339)")
+	seedShards       = flag.String("seed-shards", "localhost:9001,localhost:9002,localhost:9003", "Cluster topology used to seed only the sample transactions this shard owns (must match the core)")
+	seedRouteBy      = flag.String("seed-route-by", ring.KeyBySymbol, "Routing key of -seed-shards: symbol or id (must match the core)")
+	advertise        = flag.String("addr", "", "Address of this shard in -seed-shards (default localhost:<port>)")
 )
 
-// BD em memória
-var db []model.Transaction
-
 func main() {
 	flag.Parse()
 
-	// Popular com dados fictícios
-	populateDB()
+	if *restoreFrom != "" {
+		if err := restore(); err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] Restore failed: %v\n", *id, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	store, err := openStore()
+	if err != nil {
+		panic(err)
+	}
+
+	// Popular com dados fictícios apenas na primeira execução (réplicas recebem os dados do primário)
+	if store.Len() == 0 && *replicaOf == "" && !*empty {
+		if err := populateDB(store); err != nil {
+			panic(err)
+		}
+	}
+
+	if disk, ok := store.(*storage.DiskStore); ok {
+		rec := disk.Recovery()
+		fmt.Printf("[%s] Recovered %s: snapshot LSN %d, %d log entries replayed, %d corrupted bytes truncated\n",
+			*id, *dataDir, rec.SnapshotLSN, rec.Replayed, rec.TruncatedBytes)
+		if *snapshotInterval > 0 {
+			go snapshotLoop(disk, *snapshotInterval)
+		}
+	}
+
+	// Encerramento gracioso: fsync do log antes de sair
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		if err := store.Close(); err != nil {
+			fmt.Printf("[%s] Error closing store: %v\n", *id, err)
+		}
+		os.Exit(0)
+	}()
 
 	listener, err := net.Listen("tcp", ":"+*port)
 	if err != nil {
 		panic(err)
 	}
-	fmt.Printf("History Shard %s running on :%s with %d records (Delay: %dms)\n", *id, *port, len(db), *delay)
+	fmt.Printf("History Shard %s running on :%s with %d records (Delay: %dms)\n", *id, *port, store.Len(), *delay)
 
-	for {
-		conn, err := listener.Accept()
+	shard := NewShard(*id, time.Duration(*delay)*time.Millisecond, store)
+	if *replicaOf != "" {
+		fmt.Printf("[%s] Replicating from %s\n", *id, *replicaOf)
+		shard.Follow(*replicaOf)
+	}
+	if *retain != "" {
+		if *replicaOf != "" {
+			panic("-retention runs on the primary; replicas receive the expirations through its log")
+		}
+		policy, err := retention.ParsePolicy(*retain)
+		if err != nil {
+			panic(err)
+		}
+		rollups, err := retention.OpenRollups(rollupsPath())
 		if err != nil {
-			continue
+			panic(err)
+		}
+		fmt.Printf("[%s] Retention %s, compacting every %v\n", *id, policy, *compactInterval)
+		shard.Retain(policy, rollups, *compactInterval)
+	}
+	if *peers != "" {
+		if *replicaOf != "" {
+			panic("-peers is for quorum peers; a replica follows its primary's log")
 		}
-		go handleRequest(conn)
+		fmt.Printf("[%s] Anti-entropy with %s every %v\n", *id, *peers, *antiEntropy)
+		shard.AntiEntropy(strings.Split(*peers, ","), *antiEntropy)
+	}
+	if *tombstoneTTL > 0 {
+		shard.PruneTombstones(*tombstoneTTL)
+	}
+	if err := shard.Serve(listener); err != nil {
+		panic(err)
 	}
 }
 
-func populateDB() {
-	// Adicionar transações fictícias
-	for i := 0; i < 10; i++ {
-		db = append(db, model.Transaction{
-			ID:        fmt.Sprintf("%s-%d", *id, i),
-			Symbol:    "PETR4",
-			Price:     20.0 + float64(i),
-			Quantity:  100 * (i + 1),
-			Timestamp: time.Now().Add(time.Duration(-i) * time.Hour),
-		})
+func openStore() (storage.Store, error) {
+	if *dataDir == "" {
+		return storage.NewMemStore(), nil
 	}
+	policy, err := storage.ParseSyncPolicy(*fsync)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Open(*dataDir, storage.Options{Sync: policy, SyncInterval: *fsyncInterval})
 }
 
-func handleRequest(conn net.Conn) {
-	defer conn.Close()
+// restore recria o -data-dir a partir de um backup, no ponto pedido. O shard
+// é iniciado depois, sem -restore. Réplicas dele precisam ser recriadas (ou
+// restauradas no mesmo ponto), pois podem ter entradas posteriores.
+func restore() error {
+	if *dataDir == "" {
+		return fmt.Errorf("-restore needs -data-dir")
+	}
+	var point storage.RestorePoint
+	point.LSN = *restoreLSN
+	if *restoreTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, *restoreTime)
+		if err != nil {
+			return fmt.Errorf("invalid -restore-time: %v", err)
+		}
+		point.Time = t
+	}
+	res, err := storage.Restore(*restoreFrom, *dataDir, point)
+	if err != nil {
+		return err
+	}
+	// Os agregados são os do instante do backup; transações restauradas
+	// anteriores ao ponto compactado não contam em dobro e saem na próxima rodada
+	rolled, err := retention.RestoreRollups(*restoreFrom, *dataDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[%s] Restored %s into %s: LSN %d, %d log entries replayed, %d transactions (rollups: %v)\n",
+		*id, *restoreFrom, *dataDir, res.LSN, res.Replayed, res.Transactions, rolled)
+	return nil
+}
 
-	var msg protocol.Message
-	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
-		return
+// rollupsPath devolve o arquivo dos agregados compactados ("" sem -data-dir).
+func rollupsPath() string {
+	if *dataDir == "" {
+		return ""
 	}
+	return filepath.Join(*dataDir, retention.RollupsFile)
+}
 
-	if msg.Type == protocol.MsgReqHistory {
-		// Simular processamento (I/O Bound ou CPU Bound) para evidenciar paralelismo
-		if *delay > 0 {
-			time.Sleep(time.Duration(*delay) * time.Millisecond)
+func snapshotLoop(disk *storage.DiskStore, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := disk.Snapshot(); err != nil {
+			fmt.Printf("[%s] Snapshot failed: %v\n", *id, err)
 		}
+	}
+}
+
+// sampleSymbols são os símbolos das transações fictícias.
+var sampleSymbols = []string{"PETR4", "VALE3", "ITUB4", "BBDC4", "BBAS3", "MGLU3"}
+
+// populateDB grava as transações fictícias que o Core mandaria para este shard:
+// cada shard do cluster fica só com as suas, e uma consulta roteada ao dono
+// encontra o histórico inteiro do símbolo. Um shard fora de -seed-shards
+// (rodando sozinho) grava todas.
+func populateDB(store storage.Store) error {
+	parts, err := ring.ParsePartitions(*seedShards)
+	if err != nil {
+		return err
+	}
+	router, err := ring.NewPartitionedRouter(parts, *seedRouteBy)
+	if err != nil {
+		return err
+	}
+	self := *advertise
+	if self == "" {
+		self = "localhost:" + *port
+	}
+	member := false
+	for _, p := range parts {
+		member = member || p.Primary == self
+	}
 
-		// Retornar todos os dados (Simular Consulta)
-	
-payload, _ := json.Marshal(db)
-		resp := protocol.Message{
-			Type:    protocol.MsgRespHistory,
-			Payload: payload,
+	now := time.Now()
+	for _, symbol := range sampleSymbols {
+		for i := 0; i < 10; i++ {
+			tx := model.Transaction{
+				ID:        fmt.Sprintf("seed-%s-%d", symbol, i),
+				Symbol:    symbol,
+				Price:     20.0 + float64(i),
+				Quantity:  100 * (i + 1),
+				Timestamp: now.Add(time.Duration(-i) * time.Hour),
+			}
+			if member && router.OwnerOf(tx) != self {
+				continue
+			}
+			if _, err := store.Put(tx); err != nil {
+				return err
+			}
 		}
-		protocol.SendJSON(conn, resp)
-		fmt.Printf("[%s] Served history request (latency: %dms)\n", *id, *delay)
 	}
-}
\ No newline at end of file
+	return nil
+}
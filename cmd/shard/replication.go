@@ -0,0 +1,272 @@
+package main
+
+import (
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	replBatchSize     = 500
+	replHeartbeat     = time.Second // Primário envia um lote vazio quando não há gravações
+	replTimeout       = 3 * replHeartbeat
+	replMinBackoff    = 100 * time.Millisecond
+	replMaxBackoff    = 5 * time.Second
+	replWriteDeadline = 2 * time.Second
+)
+
+// replBatch é o payload de MsgReplBatch.
+type replBatch struct {
+	Entries    []storage.Entry `json:"entries"`
+	PrimaryLSN uint64          `json:"primary_lsn"` // Posição atual do primário (para o cálculo de atraso)
+}
+
+// replicaStatus é a visão do primário sobre uma réplica conectada.
+type replicaStatus struct {
+	Replica string `json:"replica"`
+	Addr    string `json:"addr"`
+	SentLSN uint64 `json:"sent_lsn"`
+	AckLSN  uint64 `json:"ack_lsn"`
+	Lag     uint64 `json:"lag"` // Entradas ainda não confirmadas pela réplica
+}
+
+// upstreamStatus é a visão da réplica sobre o primário.
+type upstreamStatus struct {
+	Primary     string    `json:"primary"`
+	Connected   bool      `json:"connected"`
+	PrimaryLSN  uint64    `json:"primary_lsn"`
+	LastContact time.Time `json:"last_contact"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// serveReplica envia o log a uma réplica a partir de req.FromLSN e continua
+// enviando cada nova gravação até a conexão cair.
+//
+// Uma réplica à frente do primário (um primário sem -data-dir que reiniciou
+// do LSN 0) ignoraria as gravações novas, com LSNs que ela já tem, e serviria
+// dados antigos: a sessão é recusada com MsgReplResync, e a réplica descarta
+// o conteúdo e volta a pedir o log desde o início. O mesmo vale para uma
+// réplica atrás de um tombstone já descartado, que não recebe mais a remoção.
+func (s *Shard) serveReplica(conn net.Conn, req protocol.ReplicateRequest) {
+	if last := s.store.LastLSN(); req.FromLSN > last {
+		fmt.Printf("[%s] Replica %s at LSN %d is ahead of LSN %d; asking for a full resync\n", s.id, req.Replica, req.FromLSN, last)
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReplResync, replBatch{PrimaryLSN: last}))
+		return
+	}
+	if pruned := s.store.PrunedLSN(); req.FromLSN > 0 && req.FromLSN < pruned {
+		fmt.Printf("[%s] Replica %s at LSN %d missed tombstones pruned up to LSN %d; asking for a full resync\n", s.id, req.Replica, req.FromLSN, pruned)
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReplResync, replBatch{PrimaryLSN: s.store.LastLSN()}))
+		return
+	}
+	key := conn.RemoteAddr().String()
+	s.mu.Lock()
+	s.replicas[key] = &replicaStatus{Replica: req.Replica, Addr: key, SentLSN: req.FromLSN, AckLSN: req.FromLSN}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.replicas, key)
+		s.mu.Unlock()
+	}()
+	fmt.Printf("[%s] Replica %s connected from LSN %d\n", s.id, req.Replica, req.FromLSN)
+
+	// Confirmações chegam pela mesma conexão
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		decoder := json.NewDecoder(conn)
+		for {
+			var msg protocol.Message
+			if err := decoder.Decode(&msg); err != nil {
+				return
+			}
+			var ack protocol.ReplicationAck
+			if msg.Type != protocol.MsgReplAck || json.Unmarshal(msg.Payload, &ack) != nil {
+				continue
+			}
+			s.mu.Lock()
+			if st := s.replicas[key]; st != nil {
+				st.AckLSN = ack.LSN
+			}
+			s.mu.Unlock()
+		}
+	}()
+
+	heartbeat := time.NewTicker(replHeartbeat)
+	defer heartbeat.Stop()
+	sent := req.FromLSN
+	for {
+		// Pegar o canal antes de ler o log: uma gravação entre os dois passos ainda nos acorda
+		changed := s.changedCh()
+		entries := s.store.Since(sent, replBatchSize)
+		if len(entries) > 0 {
+			if err := s.sendBatch(conn, entries); err != nil {
+				fmt.Printf("[%s] Replica %s disconnected: %v\n", s.id, req.Replica, err)
+				return
+			}
+			sent = entries[len(entries)-1].LSN
+			s.mu.Lock()
+			s.replicas[key].SentLSN = sent
+			s.mu.Unlock()
+			if len(entries) == replBatchSize {
+				continue
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			if err := s.sendBatch(conn, nil); err != nil {
+				fmt.Printf("[%s] Replica %s disconnected: %v\n", s.id, req.Replica, err)
+				return
+			}
+		case <-done:
+			fmt.Printf("[%s] Replica %s disconnected\n", s.id, req.Replica)
+			return
+		}
+	}
+}
+
+func (s *Shard) sendBatch(conn net.Conn, entries []storage.Entry) error {
+	conn.SetWriteDeadline(time.Now().Add(replWriteDeadline))
+	return protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReplBatch, replBatch{Entries: entries, PrimaryLSN: s.store.LastLSN()}))
+}
+
+// Follow transforma o shard em réplica somente leitura de primary: ele passa
+// a receber o log do primário, reconectando com backoff quando a conexão cai.
+func (s *Shard) Follow(primary string) {
+	s.mu.Lock()
+	s.upstream = &upstreamStatus{Primary: primary}
+	s.mu.Unlock()
+
+	go func() {
+		backoff := replMinBackoff
+		for {
+			applied, err := s.replicateFrom(primary)
+			s.mu.Lock()
+			s.upstream.Connected = false
+			s.upstream.LastError = err.Error()
+			s.mu.Unlock()
+			fmt.Printf("[%s] Replication from %s interrupted: %v\n", s.id, primary, err)
+
+			// Uma sessão produtiva volta ao backoff mínimo
+			if applied > 0 {
+				backoff = replMinBackoff
+			}
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > replMaxBackoff {
+				backoff = replMaxBackoff
+			}
+		}
+	}()
+}
+
+// replicateFrom mantém uma sessão de replicação até ela falhar, devolvendo
+// quantas entradas foram aplicadas.
+func (s *Shard) replicateFrom(primary string) (int, error) {
+	conn, err := net.DialTimeout("tcp", primary, replWriteDeadline)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	req := protocol.ReplicateRequest{Replica: s.id, FromLSN: s.store.LastLSN()}
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReplicate, req)); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	decoder := json.NewDecoder(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+		var msg protocol.Message
+		if err := decoder.Decode(&msg); err != nil {
+			return applied, err
+		}
+		if msg.Type == protocol.MsgError {
+			return applied, fmt.Errorf("primary: %s", msg.Payload)
+		}
+		if msg.Type == protocol.MsgReplResync {
+			// O primário perdeu o log que a réplica tem: recomeçar do LSN 0 na próxima sessão
+			if err := s.store.Reset(); err != nil {
+				return applied, err
+			}
+			s.notifyChanged()
+			return applied, fmt.Errorf("primary is behind replica LSN %d; store reset for a full resync", req.FromLSN)
+		}
+		var batch replBatch
+		if err := json.Unmarshal(msg.Payload, &batch); err != nil {
+			return applied, err
+		}
+
+		for _, e := range batch.Entries {
+			if err := s.store.Apply(e); err != nil {
+				return applied, err
+			}
+			applied++
+		}
+		if len(batch.Entries) > 0 {
+			s.notifyChanged()
+		}
+
+		s.mu.Lock()
+		s.upstream.Connected = true
+		s.upstream.PrimaryLSN = batch.PrimaryLSN
+		s.upstream.LastContact = time.Now()
+		s.upstream.LastError = ""
+		s.mu.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(replWriteDeadline))
+		ack := protocol.ReplicationAck{LSN: s.store.LastLSN()}
+		if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgReplAck, ack)); err != nil {
+			return applied, err
+		}
+	}
+}
+
+// replicationMetrics descreve o papel do shard e o atraso de replicação.
+func (s *Shard) replicationMetrics() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lsn := s.store.LastLSN()
+	m := map[string]interface{}{
+		"id":      s.id,
+		"lsn":     lsn,
+		"records": s.store.Len(),
+	}
+	if len(s.repair.Peers) > 0 {
+		m["anti_entropy"] = s.repair
+	}
+	if s.rollups != nil {
+		m["retention"] = s.compaction
+	}
+	if s.upstream != nil {
+		m["role"] = "replica"
+		up := *s.upstream
+		m["upstream"] = up
+		var lag uint64
+		if up.PrimaryLSN > lsn {
+			lag = up.PrimaryLSN - lsn
+		}
+		m["lag_entries"] = lag
+		if !up.LastContact.IsZero() {
+			m["last_contact_ms"] = time.Since(up.LastContact).Milliseconds()
+		}
+		return m
+	}
+
+	m["role"] = "primary"
+	replicas := make([]replicaStatus, 0, len(s.replicas))
+	for _, st := range s.replicas {
+		r := *st
+		if lsn > r.AckLSN {
+			r.Lag = lsn - r.AckLSN
+		}
+		replicas = append(replicas, r)
+	}
+	m["replicas"] = replicas
+	return m
+}
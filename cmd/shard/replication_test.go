@@ -0,0 +1,243 @@
+package main
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startShard sobe um shard em memória numa porta aleatória.
+func startShard(t *testing.T, id string) (*Shard, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard := NewShard(id, 0, storage.NewMemStore())
+	go shard.Serve(listener)
+	t.Cleanup(func() { listener.Close() })
+	return shard, listener.Addr().String()
+}
+
+func request(addr string, msg protocol.Message) (protocol.Message, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return protocol.Message{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if err := protocol.SendJSON(conn, msg); err != nil {
+		return protocol.Message{}, err
+	}
+	var resp protocol.Message
+	err = protocol.ReceiveJSON(conn, &resp)
+	return resp, err
+}
+
+func storeTx(addr string, tx model.Transaction) error {
+	resp, err := request(addr, protocol.NewMessage(protocol.MsgStoreTx, tx))
+	if err != nil {
+		return err
+	}
+	if resp.Type == protocol.MsgError {
+		return fmt.Errorf("%s", resp.Payload)
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout esperando %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testTx(i int) model.Transaction {
+	return model.Transaction{ID: fmt.Sprintf("tx-%d", i), Symbol: "PETR4", Price: 25, Quantity: 100, Timestamp: time.Now()}
+}
+
+// TestReplicaReceivesLogAndReportsLag valida o envio do log (histórico existente
+// e gravações novas), a rejeição de gravações na réplica e as métricas de atraso.
+func TestReplicaReceivesLogAndReportsLag(t *testing.T) {
+	primary, primaryAddr := startShard(t, "Shard-A")
+	for i := 0; i < 5; i++ {
+		if err := storeTx(primaryAddr, testTx(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replica, replicaAddr := startShard(t, "Shard-A-replica")
+	replica.Follow(primaryAddr)
+	waitFor(t, "histórico inicial na réplica", func() bool { return replica.store.LastLSN() == 5 })
+
+	for i := 5; i < 8; i++ {
+		storeTx(primaryAddr, testTx(i))
+	}
+	waitFor(t, "gravações novas na réplica", func() bool { return replica.store.LastLSN() == 8 })
+	if _, ok := replica.store.Get("tx-7"); !ok {
+		t.Errorf("Réplica deveria ter a transação tx-7")
+	}
+
+	if err := storeTx(replicaAddr, testTx(99)); err == nil {
+		t.Errorf("Réplica deveria rejeitar gravações diretas")
+	}
+
+	// Atraso visível nos dois lados depois da confirmação
+	waitFor(t, "confirmação da réplica", func() bool {
+		reps := primary.replicationMetrics()["replicas"].([]replicaStatus)
+		return len(reps) == 1 && reps[0].AckLSN == 8
+	})
+	resp, err := request(replicaAddr, protocol.NewMessage(protocol.MsgReqMetrics, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	json.Unmarshal(resp.Payload, &m)
+	if m["role"] != "replica" || m["lag_entries"] != float64(0) {
+		t.Errorf("Métricas da réplica inesperadas: %v", m)
+	}
+}
+
+// proxy repassa conexões TCP para target e permite derrubá-las todas (queda de rede).
+type proxy struct {
+	mu    sync.Mutex
+	conns []net.Conn
+	addr  string
+}
+
+func startProxy(t *testing.T, target string) *proxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	p := &proxy{addr: listener.Addr().String()}
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", target)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, client, server)
+			p.mu.Unlock()
+			go io.Copy(server, client)
+			go io.Copy(client, server)
+		}
+	}()
+	return p
+}
+
+func (p *proxy) cut() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+// TestReplicaResumesAfterDisconnect valida que a réplica reconecta e continua
+// do último LSN aplicado, recebendo o que foi gravado enquanto estava fora.
+func TestReplicaResumesAfterDisconnect(t *testing.T) {
+	primary, primaryAddr := startShard(t, "Shard-B")
+	for i := 0; i < 3; i++ {
+		storeTx(primaryAddr, testTx(i))
+	}
+	link := startProxy(t, primaryAddr)
+	replica, _ := startShard(t, "Shard-B-replica")
+	replica.Follow(link.addr)
+	waitFor(t, "réplica sincronizada", func() bool { return replica.store.LastLSN() == 3 })
+
+	link.cut()
+	for i := 3; i < 6; i++ {
+		storeTx(primaryAddr, testTx(i))
+	}
+
+	waitFor(t, "réplica retomando após a queda", func() bool { return replica.store.LastLSN() == 6 })
+	if replica.store.Len() != 6 {
+		t.Errorf("Esperava 6 registros na réplica, há %d", replica.store.Len())
+	}
+	waitFor(t, "réplica reconectada a partir do LSN 3", func() bool {
+		reps := primary.replicationMetrics()["replicas"].([]replicaStatus)
+		return len(reps) == 1 && reps[0].AckLSN == 6
+	})
+}
+
+// TestReplicaAheadOfRestartedPrimaryResyncs valida que uma réplica à frente
+// do primário (um primário em memória que reiniciou do LSN 0) descarta o
+// conteúdo e recebe o log novo inteiro, em vez de ignorar as gravações novas
+// por já ter os LSNs delas.
+func TestReplicaAheadOfRestartedPrimaryResyncs(t *testing.T) {
+	primary, primaryAddr := startShard(t, "Shard-A")
+	replica, _ := startShard(t, "Shard-A-replica")
+	for i := 0; i < 5; i++ {
+		replica.store.Put(testTx(i)) // Log do primário antes do restart
+	}
+	for i := 100; i < 102; i++ {
+		storeTx(primaryAddr, testTx(i))
+	}
+
+	replica.Follow(primaryAddr)
+	waitFor(t, "ressincronização da réplica", func() bool {
+		_, ok := replica.store.Get("tx-101")
+		return ok && replica.store.Len() == 2
+	})
+	if _, ok := replica.store.Get("tx-0"); ok {
+		t.Error("Esperava o conteúdo antigo da réplica descartado")
+	}
+
+	storeTx(primaryAddr, testTx(102))
+	waitFor(t, "gravação nova na réplica", func() bool { return replica.store.LastLSN() == primary.store.LastLSN() })
+	if _, ok := replica.store.Get("tx-102"); !ok || replica.store.Len() != 3 {
+		t.Errorf("Esperava a réplica com as 3 transações do primário, tem %d", replica.store.Len())
+	}
+	if replica.store.Tree().Root() != primary.store.Tree().Root() {
+		t.Error("Esperava a réplica com a mesma raiz Merkle do primário")
+	}
+}
+
+// TestReplicaBehindPrunedTombstoneResyncs valida que uma réplica atrás de uma
+// remoção já descartada no primário ressincroniza em vez de manter a
+// transação removida.
+func TestReplicaBehindPrunedTombstoneResyncs(t *testing.T) {
+	primary, primaryAddr := startShard(t, "Shard-A")
+	replica, _ := startShard(t, "Shard-A-replica")
+	for i := 0; i < 3; i++ {
+		storeTx(primaryAddr, testTx(i))
+	}
+	for _, e := range primary.store.Since(0, 2) {
+		replica.store.Apply(e) // A réplica parou no LSN 2, com tx-1
+	}
+	primary.store.Tombstone(storage.Tombstone{ID: "tx-1", Version: testTx(1).Version, DeletedAt: time.Now().Add(-48 * time.Hour)})
+	storeTx(primaryAddr, testTx(3))
+	if n := primary.pruneTombstones(time.Now().Add(-24 * time.Hour)); n != 1 {
+		t.Fatalf("Esperava descartar o tombstone de tx-1, descartou %d", n)
+	}
+
+	replica.Follow(primaryAddr)
+	waitFor(t, "ressincronização da réplica", func() bool { return replica.store.LastLSN() == primary.store.LastLSN() })
+	if _, ok := replica.store.Get("tx-1"); ok {
+		t.Error("Esperava tx-1 fora da réplica depois da ressincronização")
+	}
+	if replica.store.Len() != 3 || replica.store.Tree().Root() != primary.store.Tree().Root() {
+		t.Errorf("Esperava a réplica igual ao primário, tem %d transações", replica.store.Len())
+	}
+}
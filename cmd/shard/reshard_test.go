@@ -0,0 +1,220 @@
+package main
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/reshard"
+	"distributed-system/pkg/ring"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyCluster falha depois de um número de cópias, simulando uma migração interrompida.
+type flakyCluster struct {
+	reshard.Cluster
+	mu   sync.Mutex
+	left int
+}
+
+func (f *flakyCluster) Store(node string, tx model.Transaction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.left == 0 {
+		return fmt.Errorf("connection reset")
+	}
+	f.left--
+	return f.Cluster.Store(node, tx)
+}
+
+func switchOf(r *ring.Router) reshard.Switch {
+	return func(action, shards string) error {
+		_, err := r.Apply(action, shards)
+		return err
+	}
+}
+
+// historyOf lê o histórico do símbolo nos shards que o leitor consultaria,
+// deixando uma cópia de cada ID (como o Aggregator durante a migração).
+func historyOf(reader *ring.Router, symbol string) (map[string]bool, error) {
+	found := make(map[string]bool)
+	for _, addr := range reader.ShardsFor(symbol) {
+		resp, err := request(addr, protocol.NewMessage(protocol.MsgReqHistory, query.Query{Symbol: symbol, Limit: query.MaxLimit}))
+		if err != nil {
+			return nil, err
+		}
+		var page query.Page
+		if err := json.Unmarshal(resp.Payload, &page); err != nil {
+			return nil, err
+		}
+		for _, tx := range page.Transactions {
+			found[tx.ID] = true
+		}
+	}
+	return found, nil
+}
+
+// TestReshardUnderLoad migra de 3 para 5 shards com gravações e leituras
+// contínuas, interrompe a cópia no meio, retoma a partir do progresso gravado
+// e confere que nenhuma transação foi perdida ou duplicada.
+func TestReshardUnderLoad(t *testing.T) {
+	var shards []*Shard
+	var addrs []string
+	for i := 0; i < 5; i++ {
+		s, addr := startShard(t, fmt.Sprintf("Shard-%d", i))
+		shards = append(shards, s)
+		addrs = append(addrs, addr)
+	}
+	from, _ := ring.ParsePartitions(fmt.Sprintf("%s,%s,%s", addrs[0], addrs[1], addrs[2]))
+	to, _ := ring.ParsePartitions(fmt.Sprintf("%s,%s,%s,%s,%s", addrs[0], addrs[1], addrs[2], addrs[3], addrs[4]))
+	writer, _ := ring.NewPartitionedRouter(from, ring.KeyBySymbol) // Core
+	reader, _ := ring.NewPartitionedRouter(from, ring.KeyBySymbol) // Aggregator
+
+	const symbolCount = 40
+	var mu sync.Mutex
+	acked := make(map[string][]string) // Símbolo -> IDs confirmados
+	total := 0
+	write := func(i int) {
+		tx := model.Transaction{
+			ID:        fmt.Sprintf("tx-%d", i),
+			Symbol:    fmt.Sprintf("SYM%02d", i%symbolCount),
+			Price:     25,
+			Quantity:  100,
+			Timestamp: time.Now(),
+			Version:   1,
+		}
+		if err := storeTx(writer.OwnerOf(tx), tx); err != nil {
+			t.Errorf("Gravação de %s falhou: %v", tx.ID, err)
+			return
+		}
+		mu.Lock()
+		acked[tx.Symbol] = append(acked[tx.Symbol], tx.ID)
+		total++
+		mu.Unlock()
+	}
+	for i := 0; i < 1200; i++ {
+		write(i)
+	}
+
+	// Carga contínua: gravações e leituras que conferem as gravações já confirmadas
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1200; i < 3000; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			write(i)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	reads := 0
+	go func() {
+		defer wg.Done()
+		for n := 0; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			symbol := fmt.Sprintf("SYM%02d", n%symbolCount)
+			mu.Lock()
+			want := append([]string(nil), acked[symbol]...)
+			mu.Unlock()
+			found, err := historyOf(reader, symbol)
+			if err != nil {
+				t.Errorf("Leitura de %s falhou: %v", symbol, err)
+				return
+			}
+			for _, id := range want {
+				if !found[id] {
+					t.Errorf("Leitura durante a migração não encontrou %s de %s", id, symbol)
+					return
+				}
+			}
+			reads++
+		}
+	}()
+
+	state := filepath.Join(t.TempDir(), "reshard.json")
+	migrator := func(cluster reshard.Cluster) *reshard.Migrator {
+		return &reshard.Migrator{
+			From:    from,
+			To:      to,
+			KeyBy:   ring.KeyBySymbol,
+			Cluster: cluster,
+			Readers: []reshard.Switch{switchOf(reader)},
+			Writers: []reshard.Switch{switchOf(writer)},
+			Settle:  100 * time.Millisecond,
+			State:   state,
+		}
+	}
+
+	// Primeira tentativa cai no meio da cópia
+	if err := migrator(&flakyCluster{Cluster: reshard.TCPCluster{}, left: 150}).Run(); err == nil {
+		t.Fatal("Esperava que a migração interrompida falhasse")
+	}
+	p, err := reshard.LoadProgress(state)
+	if err != nil || p.Phase != reshard.PhaseCopy {
+		t.Fatalf("Esperava o progresso gravado na fase de cópia, recebido %+v (%v)", p, err)
+	}
+
+	// Retomada a partir do progresso gravado
+	m := migrator(reshard.TCPCluster{})
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond) // Mais gravações e leituras já na topologia nova
+	close(stop)
+	wg.Wait()
+
+	progress := m.Progress()
+	copied, deleted := 0, 0
+	for _, sp := range progress.Sources {
+		copied += sp.Copied
+		deleted += sp.Deleted
+	}
+	if progress.Phase != reshard.PhaseDone || copied == 0 || deleted == 0 {
+		t.Errorf("Esperava uma migração concluída que moveu dados, recebido %+v", progress)
+	}
+	if reads == 0 {
+		t.Error("Esperava leituras durante a migração")
+	}
+	if _, next := writer.Topology(); next != nil {
+		t.Errorf("O roteador de gravação ainda está migrando para %v", next)
+	}
+
+	// Cada transação confirmada existe exatamente uma vez, no dono da topologia nova
+	location := make(map[string][]string)
+	for i, s := range shards {
+		s.store.Scan(func(tx model.Transaction) bool {
+			location[tx.ID] = append(location[tx.ID], addrs[i])
+			return true
+		})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(location) != total {
+		t.Errorf("Esperava %d transações no cluster, encontrou %d", total, len(location))
+	}
+	final, _ := ring.NewPartitionedRouter(to, ring.KeyBySymbol)
+	for symbol, ids := range acked {
+		owner := final.OwnerOf(model.Transaction{Symbol: symbol})
+		for _, id := range ids {
+			if nodes := location[id]; len(nodes) != 1 || nodes[0] != owner {
+				t.Errorf("Transação %s de %s gravada em %v, esperava apenas em %s", id, symbol, nodes, owner)
+			}
+		}
+	}
+	if shards[3].store.Len() == 0 || shards[4].store.Len() == 0 {
+		t.Errorf("Esperava dados nos shards novos, recebido %d e %d", shards[3].store.Len(), shards[4].store.Len())
+	}
+}
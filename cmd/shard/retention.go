@@ -0,0 +1,177 @@
+package main
+
+import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/retention"
+	"distributed-system/pkg/storage"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// compactionStats resume as rodadas de retenção, exposto nas métricas.
+type compactionStats struct {
+	Policy    string    `json:"policy"`
+	Rounds    int       `json:"rounds"`
+	Buckets   int       `json:"buckets"` // Faixas geradas a partir de transações brutas
+	Expired   int       `json:"expired"` // Transações brutas removidas
+	LastRound time.Time `json:"last_round,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Retain aplica a política de retenção em segundo plano: a cada intervalo as
+// transações brutas vencidas viram agregados em rollups e saem do Store (a
+// expiração chega às réplicas pelo log). Consultas não esperam a rodada.
+func (s *Shard) Retain(policy retention.Policy, rollups *retention.Rollups, interval time.Duration) {
+	c := s.retention(policy, rollups)
+	go func() {
+		for range time.Tick(interval) {
+			s.compact(c, time.Now())
+		}
+	}()
+}
+
+// retention liga os agregados às consultas e devolve o compactador da política.
+func (s *Shard) retention(policy retention.Policy, rollups *retention.Rollups) *retention.Compactor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rollups = rollups
+	s.compaction.Policy = policy.String()
+	return &retention.Compactor{Store: s.store, Rollups: rollups, Policy: policy}
+}
+
+// compact faz uma rodada de retenção.
+func (s *Shard) compact(c *retention.Compactor, now time.Time) (retention.Result, error) {
+	res, err := c.Run(now)
+	if res.Expired > 0 {
+		s.notifyChanged()
+		fmt.Printf("[%s] Compaction: %d transactions expired into %d buckets\n", s.id, res.Expired, res.Buckets)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compaction.Rounds++
+	s.compaction.Buckets += res.Buckets
+	s.compaction.Expired += res.Expired
+	s.compaction.LastRound = now
+	s.compaction.LastError = ""
+	if err != nil {
+		s.compaction.LastError = err.Error()
+		fmt.Printf("[%s] Compaction failed: %v\n", s.id, err)
+	}
+	return res, err
+}
+
+// aggregate calcula os agregados do pedido: os compactados vêm dos rollups e
+// o resto das transações brutas. Transações anteriores ao ponto compactado
+// (que ainda não saíram do Store) são ignoradas para não contar em dobro, e
+// uma compactação no meio da leitura faz a leitura recomeçar.
+//
+// Sem rollups (uma réplica, ou um primário sem -retention) um período com
+// transações expiradas é recusado: o resultado seria parcial, sem o
+// histórico compactado, que só existe nos agregados do primário.
+func (s *Shard) aggregate(req aggregate.Request) ([]aggregate.Partial, error) {
+	s.mu.Lock()
+	rollups := s.rollups
+	s.mu.Unlock()
+	if compacted := s.compactedIn(rollups, req.Query); len(compacted) > 0 && (req.CheckRaw || rollups == nil) {
+		if rollups == nil {
+			return nil, fmt.Errorf("%s has no rollups for the compacted history of %s; aggregate on the primary",
+				s.id, strings.Join(compacted, ","))
+		}
+		return nil, fmt.Errorf("compacted history of %s is only in the rollups of %s, not in its raw transactions",
+			strings.Join(compacted, ","), s.id)
+	}
+	if req.CheckRaw {
+		return []aggregate.Partial{}, nil
+	}
+	if rollups == nil {
+		return aggregate.Compute(s.store.Query, req)
+	}
+	for {
+		gen := rollups.Generation()
+		partials, err := s.aggregateWith(rollups, req)
+		if err != nil || rollups.Generation() == gen {
+			return partials, err
+		}
+	}
+}
+
+// compactedIn devolve os símbolos da consulta com histórico compactado no
+// período dela: o ponto compactado dos rollups ou, sem eles, o limite de
+// retenção do Store (que chega às réplicas pelo log).
+func (s *Shard) compactedIn(rollups *retention.Rollups, q query.Query) []string {
+	points := s.store.Horizons()
+	if rollups != nil {
+		for _, symbol := range rollups.Symbols() {
+			if c := rollups.Compacted(symbol); c.After(points[symbol]) {
+				points[symbol] = c
+			}
+		}
+	}
+	var symbols []string
+	for symbol, point := range points {
+		if (q.Symbol == "" || q.Symbol == symbol) && !point.IsZero() && (q.From.IsZero() || q.From.Before(point)) {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (s *Shard) aggregateWith(rollups *retention.Rollups, req aggregate.Request) ([]aggregate.Partial, error) {
+	raw, err := aggregate.Compute(func(q query.Query) (query.Page, error) {
+		page, err := s.store.Query(q)
+		txs := page.Transactions[:0]
+		for _, tx := range page.Transactions {
+			if !tx.Timestamp.Before(rollups.Compacted(tx.Symbol)) {
+				txs = append(txs, tx)
+			}
+		}
+		page.Transactions = txs
+		return page, err
+	}, req)
+	if err != nil {
+		return nil, err
+	}
+
+	acc := aggregate.NewAccumulator(req.Bucket)
+	for _, p := range rollups.Query(req) {
+		acc.Merge(p)
+	}
+	for _, p := range raw {
+		acc.Merge(p)
+	}
+	return acc.Partials(), nil
+}
+
+// backup copia o Store e, com retenção, os agregados compactados para dir.
+// Réplicas também aceitam, para o backup não pesar no primário, mas não têm
+// os agregados: se o Store já teve transações expiradas, o backup de uma
+// réplica perderia esse histórico e é recusado. Um backup recusado ou que
+// falhou nos agregados é descartado, para não ser restaurado pela metade.
+func (s *Shard) backup(dir string) (storage.BackupInfo, error) {
+	s.mu.Lock()
+	rollups := s.rollups
+	s.mu.Unlock()
+
+	info, err := s.store.Backup(dir)
+	if err != nil {
+		return info, err
+	}
+	switch {
+	case rollups != nil:
+		// Depois do Store: os agregados copiados cobrem tudo o que ele já expirou
+		err = rollups.Backup(dir)
+	case len(info.Expired) > 0:
+		err = fmt.Errorf("%s has compacted history (%s) but no rollups; back up the primary",
+			s.id, strings.Join(info.Expired, ","))
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+	}
+	return info, err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/retention"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestRetentionCompactsWithoutChangingAggregates valida que a compactação
+// tira as transações brutas vencidas do primário e da réplica sem mudar os
+// agregados servidos pelo shard.
+func TestRetentionCompactsWithoutChangingAggregates(t *testing.T) {
+	primary, primaryAddr := startShard(t, "Shard-A")
+	now := time.Now().UTC().Truncate(time.Hour)
+	for i := 0; i < 3*24*60; i++ { // Uma transação por minuto nos últimos 3 dias
+		primary.store.Put(model.Transaction{
+			ID:        fmt.Sprintf("tx-%d", i),
+			Symbol:    "PETR4",
+			Price:     20 + float64(i%7),
+			Quantity:  10 * (i%5 + 1),
+			Timestamp: now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+	replica, _ := startShard(t, "Shard-A-replica")
+	replica.Follow(primaryAddr)
+
+	aggregates := func() aggregate.Partial {
+		req := aggregate.Request{Query: query.Query{Symbol: "PETR4"}}
+		resp, err := request(primaryAddr, protocol.NewMessage(protocol.MsgReqAggregate, req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var partials []aggregate.Partial
+		json.Unmarshal(resp.Payload, &partials)
+		if len(partials) != 1 {
+			t.Fatalf("Esperava um agregado do período inteiro, recebeu %s", resp.Payload)
+		}
+		return partials[0]
+	}
+	before := aggregates()
+
+	policy, _ := retention.ParsePolicy("*=raw:1d,1h")
+	rollups, _ := retention.OpenRollups("")
+	if _, err := primary.compact(primary.retention(policy, rollups), now); err != nil {
+		t.Fatal(err)
+	}
+
+	if primary.store.Len() != 24*60+1 {
+		t.Errorf("Esperava só o último dia bruto, restaram %d transações", primary.store.Len())
+	}
+	waitFor(t, "expiração na réplica", func() bool { return replica.store.LastLSN() == primary.store.LastLSN() })
+	if replica.store.Len() != primary.store.Len() {
+		t.Errorf("Réplica deveria ter %d transações, tem %d", primary.store.Len(), replica.store.Len())
+	}
+	if after := aggregates(); after.Count != before.Count || after.Quantity != before.Quantity || after.MinPrice != before.MinPrice || after.MaxPrice != before.MaxPrice {
+		t.Errorf("Agregados mudaram com a compactação: antes %+v, depois %+v", before, after)
+	}
+
+	// Uma transação atrasada abaixo do limite não volta para o histórico
+	late := model.Transaction{ID: "late", Symbol: "PETR4", Price: 99, Quantity: 1, Timestamp: now.Add(-48 * time.Hour)}
+	storeTx(primaryAddr, late)
+	if _, ok := primary.store.Get("late"); ok {
+		t.Error("Transação anterior ao limite de retenção não deveria ser gravada")
+	}
+
+	stats := primary.replicationMetrics()["retention"].(compactionStats)
+	if stats.Rounds != 1 || stats.Expired != 2*24*60-1 || stats.Policy != "*=raw:1d,1h" {
+		t.Errorf("Métricas de retenção inesperadas: %+v", stats)
+	}
+}
+
+// TestAggregatesOfCompactedHistoryNeedRollups valida que um shard sem os
+// agregados da retenção (a réplica) recusa períodos com histórico compactado
+// em vez de devolver um agregado parcial, e que a conferência das transações
+// brutas (usada pelo Aggregator durante um reshard) recusa o mesmo período.
+func TestAggregatesOfCompactedHistoryNeedRollups(t *testing.T) {
+	primary, primaryAddr := startShard(t, "Shard-A")
+	now := time.Now().UTC().Truncate(time.Hour)
+	for i := 0; i < 3*24; i++ { // Uma transação por hora nos últimos 3 dias
+		primary.store.Put(model.Transaction{ID: fmt.Sprintf("tx-%d", i), Symbol: "PETR4", Price: 20, Quantity: 10, Timestamp: now.Add(-time.Duration(i) * time.Hour)})
+	}
+	replica, replicaAddr := startShard(t, "Shard-A-replica")
+	replica.Follow(primaryAddr)
+
+	policy, _ := retention.ParsePolicy("*=raw:1d,1h")
+	rollups, _ := retention.OpenRollups("")
+	if _, err := primary.compact(primary.retention(policy, rollups), now); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "expiração na réplica", func() bool { return replica.store.LastLSN() == primary.store.LastLSN() })
+
+	aggregateOn := func(addr string, req aggregate.Request) protocol.Message {
+		resp, err := request(addr, protocol.NewMessage(protocol.MsgReqAggregate, req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	whole := aggregate.Request{Query: query.Query{Symbol: "PETR4"}}
+	if resp := aggregateOn(primaryAddr, whole); resp.Type != protocol.MsgRespAggregate {
+		t.Errorf("Esperava o primário agregando com os rollups, recebido %s", resp.Payload)
+	}
+	if resp := aggregateOn(replicaAddr, whole); resp.Type != protocol.MsgError {
+		t.Errorf("Esperava a réplica recusando o histórico compactado, recebido %s", resp.Payload)
+	}
+	check := whole
+	check.CheckRaw = true
+	if resp := aggregateOn(primaryAddr, check); resp.Type != protocol.MsgError {
+		t.Errorf("Esperava a conferência das transações brutas recusada, recebido %s", resp.Payload)
+	}
+
+	// Só o último dia, ainda bruto: a réplica e a conferência aceitam
+	recent := aggregate.Request{Query: query.Query{Symbol: "PETR4", From: now.Add(-12 * time.Hour)}}
+	if resp := aggregateOn(replicaAddr, recent); resp.Type != protocol.MsgRespAggregate {
+		t.Errorf("Esperava a réplica agregando o período bruto, recebido %s", resp.Payload)
+	}
+	recent.CheckRaw = true
+	if resp := aggregateOn(primaryAddr, recent); resp.Type != protocol.MsgRespAggregate || string(resp.Payload) != "[]" {
+		t.Errorf("Esperava a conferência aceita sem parciais, recebido %s", resp.Payload)
+	}
+}
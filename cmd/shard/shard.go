@@ -0,0 +1,274 @@
+package main
+
+import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/retention"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Shard atende um nó de histórico sobre um storage.Store. Um shard é primário
+// (aceita gravações e envia o log às réplicas conectadas) ou, depois de
+// Follow, réplica somente leitura de outro shard.
+type Shard struct {
+	id    string
+	delay time.Duration // Atraso artificial nas consultas de histórico
+	store storage.Store
+
+	mu       sync.Mutex
+	changed  chan struct{}             // Fechado (e recriado) a cada gravação, acorda os envios às réplicas
+	replicas map[string]*replicaStatus // Primário: réplicas conectadas
+	upstream *upstreamStatus           // Réplica: estado da conexão com o primário
+	repair   antiEntropyStats          // Anti-entropia com os pares
+
+	rollups    *retention.Rollups // Agregados das transações compactadas (nil sem retenção)
+	compaction compactionStats
+}
+
+func NewShard(id string, delay time.Duration, store storage.Store) *Shard {
+	return &Shard{
+		id:       id,
+		delay:    delay,
+		store:    store,
+		changed:  make(chan struct{}),
+		replicas: make(map[string]*replicaStatus),
+	}
+}
+
+func (s *Shard) changedCh() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changed
+}
+
+func (s *Shard) notifyChanged() {
+	s.mu.Lock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// primary devolve o endereço do primário, ou "" se este shard é o primário.
+func (s *Shard) primary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.upstream == nil {
+		return ""
+	}
+	return s.upstream.Primary
+}
+
+// Serve aceita conexões até o listener ser fechado.
+func (s *Shard) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			continue
+		}
+		go s.handleRequest(conn)
+	}
+}
+
+func (s *Shard) handleRequest(conn net.Conn) {
+	defer conn.Close()
+
+	var msg protocol.Message
+	if err := protocol.ReceiveJSON(conn, &msg); err != nil {
+		return
+	}
+
+	switch msg.Type {
+	case protocol.MsgStoreTx:
+		if primary := s.primary(); primary != "" {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "read-only replica of "+primary))
+			return
+		}
+		var tx model.Transaction
+		if err := json.Unmarshal(msg.Payload, &tx); err != nil || tx.ID == "" {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "invalid transaction"))
+			return
+		}
+		// Só confirma depois da gravação no log. Um ID já existente só é
+		// substituído por uma versão maior; a resposta traz a versão que ficou.
+		res, err := s.store.Put(tx)
+		if err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespStore, res.Stored))
+		if res.Applied {
+			s.notifyChanged()
+			fmt.Printf("[%s] Stored transaction %s (%s)\n", s.id, tx.ID, tx.Symbol)
+		}
+
+	case protocol.MsgStoreBatch:
+		if primary := s.primary(); primary != "" {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "read-only replica of "+primary))
+			return
+		}
+		var txs []model.Transaction
+		if err := json.Unmarshal(msg.Payload, &txs); err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "invalid batch: "+err.Error()))
+			return
+		}
+		for _, tx := range txs {
+			if tx.ID == "" {
+				protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "invalid transaction in batch"))
+				return
+			}
+		}
+		// Mesma regra de STORE_TX para cada transação; a resposta só vem depois do lote inteiro no log
+		var result protocol.BatchResult
+		for _, tx := range txs {
+			res, err := s.store.Put(tx)
+			if err != nil {
+				protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+				return
+			}
+			if res.Applied {
+				result.Applied++
+			} else {
+				result.Unchanged++
+			}
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespStore, result))
+		if result.Applied > 0 {
+			s.notifyChanged()
+			fmt.Printf("[%s] Stored batch of %d transactions (%d unchanged)\n", s.id, result.Applied, result.Unchanged)
+		}
+
+	case protocol.MsgAdminBackup:
+		var req protocol.BackupRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil || req.Dir == "" {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "invalid backup request"))
+			return
+		}
+		info, err := s.backup(req.Dir)
+		if err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAdmin, info))
+		fmt.Printf("[%s] Backup to %s at LSN %d (base LSN %d)\n", s.id, info.Dir, info.LSN, info.BaseLSN)
+
+	case protocol.MsgDeleteTx:
+		if primary := s.primary(); primary != "" {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "read-only replica of "+primary))
+			return
+		}
+		var req protocol.DeleteRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil || req.ID == "" {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "invalid delete request"))
+			return
+		}
+		var deleted bool
+		var err error
+		if req.Tombstone {
+			deleted, err = s.store.Tombstone(storage.Tombstone{ID: req.ID, Version: req.Version, DeletedAt: req.DeletedAt})
+		} else {
+			deleted, err = s.store.Delete(req.ID)
+		}
+		if err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespStore, map[string]bool{"deleted": deleted}))
+		if deleted {
+			s.notifyChanged()
+		}
+
+	case protocol.MsgReqLog:
+		// Leitura pontual do log (migração entre shards), sem registrar uma réplica
+		var req protocol.LogRequest
+		json.Unmarshal(msg.Payload, &req)
+		if req.Max <= 0 || req.Max > replBatchSize {
+			req.Max = replBatchSize
+		}
+		s.sendBatch(conn, s.store.Since(req.FromLSN, req.Max))
+
+	case protocol.MsgReqMerkle:
+		var req protocol.MerkleRequest
+		json.Unmarshal(msg.Payload, &req)
+		tree := s.store.Tree()
+		hashes, err := tree.Hashes(req.Level, req.Nodes)
+		if err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespMerkle, protocol.MerkleResponse{Depth: tree.Depth(), Hashes: hashes}))
+
+	case protocol.MsgReqRange:
+		var req protocol.RangeRequest
+		json.Unmarshal(msg.Payload, &req)
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespHistory, s.rangeContents(req.Buckets)))
+
+	case protocol.MsgReqAggregate:
+		// Agregação feita aqui: só os parciais (um por símbolo e faixa) trafegam
+		var req aggregate.Request
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "invalid aggregate request: "+err.Error()))
+			return
+		}
+		partials, err := s.aggregate(req)
+		if err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespAggregate, partials))
+		fmt.Printf("[%s] Served %d aggregate buckets\n", s.id, len(partials))
+
+	case protocol.MsgReplicate:
+		var req protocol.ReplicateRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		s.serveReplica(conn, req)
+
+	case protocol.MsgReqMetrics:
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespMetrics, s.replicationMetrics()))
+
+	case protocol.MsgReqHistory:
+		// Simular processamento (I/O Bound ou CPU Bound) para evidenciar paralelismo
+		if s.delay > 0 {
+			time.Sleep(s.delay)
+		}
+
+		// Sem payload: formato legado, com o histórico inteiro
+		if len(msg.Payload) == 0 || string(msg.Payload) == "null" {
+			history := make([]model.Transaction, 0, s.store.Len())
+			s.store.Scan(func(tx model.Transaction) bool {
+				history = append(history, tx)
+				return true
+			})
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespHistory, history))
+			fmt.Printf("[%s] Served full history request (latency: %v)\n", s.id, s.delay)
+			return
+		}
+
+		// Com Query: filtros avaliados aqui, uma página por resposta
+		var q query.Query
+		if err := json.Unmarshal(msg.Payload, &q); err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, "invalid query: "+err.Error()))
+			return
+		}
+		page, err := s.store.Query(q)
+		if err != nil {
+			protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgError, err.Error()))
+			return
+		}
+		protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgRespHistory, page))
+		fmt.Printf("[%s] Served history page with %d records (latency: %v)\n", s.id, len(page.Transactions), s.delay)
+	}
+}
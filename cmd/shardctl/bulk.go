@@ -0,0 +1,189 @@
+package main
+
+import (
+	"distributed-system/pkg/bulk"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/ring"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressEvery limita a frequência das linhas de progresso.
+const progressEvery = time.Second
+
+// topology devolve as partições informadas em shards ou, sem elas, as do Core
+// (com as de destino se houver uma migração em andamento).
+func topology(core, shards string) (current, next []ring.Partition, err error) {
+	status := ring.Status{Shards: shards}
+	if shards == "" {
+		if status, err = showTopology(core); err != nil {
+			return nil, nil, fmt.Errorf("reading topology from core: %v", err)
+		}
+	}
+	if current, err = ring.ParsePartitions(status.Shards); err != nil {
+		return nil, nil, err
+	}
+	if status.Next != "" {
+		if next, err = ring.ParsePartitions(status.Next); err != nil {
+			return nil, nil, err
+		}
+	}
+	return current, next, nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	core := fs.String("core", "localhost:8082", "Core address, used to read the shard topology")
+	shards := fs.String("shards", "", "Shards in the -shards format (default: the topology reported by the core)")
+	routeBy := fs.String("route-by", ring.KeyBySymbol, "Shard routing key: symbol or id (must match the core and the aggregator)")
+	replication := fs.String("replication", "primary", "Replication mode of the cluster: primary or quorum")
+	format := fs.String("format", "", "Input format: csv or jsonl (default: from the file extension)")
+	batch := fs.Int("batch", bulk.DefaultBatchSize, "Transactions per request to each shard")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: shardctl import [flags] <file>... (\"-\" reads stdin)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *replication != "primary" && *replication != "quorum" {
+		return fmt.Errorf("unknown replication mode %q", *replication)
+	}
+	current, next, err := topology(*core, *shards)
+	if err != nil {
+		return err
+	}
+	router, err := ring.NewPartitionedRouter(current, *routeBy)
+	if err != nil {
+		return err
+	}
+	if next != nil { // Como o Core: gravações vão para o dono de destino
+		if err := router.Begin(next); err != nil {
+			return err
+		}
+	}
+
+	invalid := 0
+	for _, path := range fs.Args() {
+		stats, err := importFile(path, *format, &bulk.Importer{
+			Router:    router,
+			Quorum:    *replication == "quorum",
+			Sink:      bulk.TCPShards{Timeout: requestTimeout},
+			BatchSize: *batch,
+		})
+		invalid += stats.Invalid
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid lines were skipped", invalid)
+	}
+	return nil
+}
+
+func importFile(path, format string, im *bulk.Importer) (bulk.Stats, error) {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return bulk.Stats{}, err
+		}
+		defer f.Close()
+		in = f
+	}
+	if format == "" {
+		if format = bulk.FormatOf(path); format == "" {
+			return bulk.Stats{}, fmt.Errorf("unknown file extension, use -format")
+		}
+	}
+	r, err := bulk.NewReader(in, format)
+	if err != nil {
+		return bulk.Stats{}, err
+	}
+
+	last := time.Now()
+	im.Progress = func(s bulk.Stats) {
+		if time.Since(last) >= progressEvery {
+			last = time.Now()
+			fmt.Fprintf(os.Stderr, "[import] %s: %d lines, %d imported, %d unchanged (%.0f tx/s)\n",
+				path, s.Lines, s.Imported, s.Unchanged, float64(s.Imported+s.Unchanged)/s.Elapsed.Seconds())
+		}
+	}
+	im.Invalid = func(e *bulk.LineError) {
+		fmt.Fprintf(os.Stderr, "[import] %s:%d: %v\n", path, e.Line, e.Err)
+	}
+	stats, err := im.Import(r)
+	fmt.Fprintf(os.Stderr, "[import] %s: %d lines, %d imported, %d unchanged, %d duplicates, %d invalid in %v\n",
+		path, stats.Lines, stats.Imported, stats.Unchanged, stats.Duplicates, stats.Invalid, stats.Elapsed.Round(time.Millisecond))
+	return stats, err
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	core := fs.String("core", "localhost:8082", "Core address, used to read the shard topology")
+	shards := fs.String("shards", "", "Shards in the -shards format (default: the topology reported by the core)")
+	format := fs.String("format", "", "Output format: csv or jsonl (default: from the -out extension, jsonl on stdout)")
+	out := fs.String("out", "-", "Output file (\"-\" writes to stdout)")
+	symbol := fs.String("symbol", "", "Only export this symbol")
+	from := fs.String("from", "", "Only export transactions at or after this time (RFC 3339)")
+	to := fs.String("to", "", "Only export transactions before this time (RFC 3339)")
+	fs.Parse(args)
+
+	q := query.Query{Symbol: *symbol}
+	var err error
+	if *from != "" {
+		if q.From, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("invalid -from: %v", err)
+		}
+	}
+	if *to != "" {
+		if q.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			return fmt.Errorf("invalid -to: %v", err)
+		}
+	}
+	if *format == "" {
+		if *format = bulk.FormatOf(*out); *format == "" {
+			*format = bulk.FormatJSONL
+		}
+	}
+	current, next, err := topology(*core, *shards)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	writer, err := bulk.NewWriter(w, *format)
+	if err != nil {
+		return err
+	}
+
+	start, last := time.Now(), time.Now()
+	e := &bulk.Exporter{
+		Partitions: append(next, current...),
+		Source:     bulk.TCPShards{Timeout: requestTimeout},
+		Progress: func(n int) {
+			if time.Since(last) >= progressEvery {
+				last = time.Now()
+				fmt.Fprintf(os.Stderr, "[export] %d transactions\n", n)
+			}
+		},
+	}
+	n, err := e.Export(q, writer)
+	fmt.Fprintf(os.Stderr, "[export] %d transactions in %v\n", n, time.Since(start).Round(time.Millisecond))
+	return err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/reshard"
+	"distributed-system/pkg/ring"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 5 * time.Second
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: shardctl <command> [flags]
+
+Commands:
+  topology   Show the shard topology used by the core and the aggregator
+  reshard    Move history to a new shard topology while the cluster keeps running
+  import     Load transactions from CSV or JSON Lines files into the shards
+  export     Write the shards' history as CSV or JSON Lines
+  backup     Take an online backup of a shard into a directory on the shard's machine
+
+Run "shardctl <command> -h" for the flags of each command.`)
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "topology":
+		err = runTopology(os.Args[2:])
+	case "reshard":
+		err = runReshard(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	case "backup":
+		err = runBackup(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// services lê os endereços do Core e do Aggregator, que precisam concordar sobre a topologia.
+func services(fs *flag.FlagSet) (core, aggregators *string) {
+	core = fs.String("core", "localhost:8082", "Core address (routes writes)")
+	aggregators = fs.String("aggregators", "localhost:8000", "Comma-separated aggregator addresses (route reads)")
+	return core, aggregators
+}
+
+func split(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func runTopology(args []string) error {
+	fs := flag.NewFlagSet("topology", flag.ExitOnError)
+	core, aggregators := services(fs)
+	fs.Parse(args)
+
+	for _, addr := range append([]string{*core}, split(*aggregators)...) {
+		status, err := showTopology(addr)
+		if err != nil {
+			fmt.Printf("%-20s error: %v\n", addr, err)
+			continue
+		}
+		fmt.Printf("%-20s shards=%s", addr, status.Shards)
+		if status.Next != "" {
+			fmt.Printf(" migrating to=%s", status.Next)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func showTopology(addr string) (ring.Status, error) {
+	conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+	if err != nil {
+		return ring.Status{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	cmd := protocol.TopologyCommand{Action: ring.ActionShow}
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgAdminTopology, cmd)); err != nil {
+		return ring.Status{}, err
+	}
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		return ring.Status{}, err
+	}
+	if resp.Type == protocol.MsgError {
+		return ring.Status{}, fmt.Errorf("%s", resp.Payload)
+	}
+	var status ring.Status
+	err = json.Unmarshal(resp.Payload, &status)
+	return status, err
+}
+
+func runReshard(args []string) error {
+	fs := flag.NewFlagSet("reshard", flag.ExitOnError)
+	core, aggregators := services(fs)
+	to := fs.String("to", "", "Target shards, in the -shards format (e.g. localhost:9001,...,localhost:9005)")
+	from := fs.String("from", "", "Current shards (default: the topology reported by the core)")
+	routeBy := fs.String("route-by", ring.KeyBySymbol, "Shard routing key: symbol or id (must match the core and the aggregator)")
+	replication := fs.String("replication", "primary", "Replication mode of the cluster: primary or quorum")
+	state := fs.String("state", "reshard.json", "Progress file; rerun with the same file to resume")
+	settle := fs.Duration("settle", 3*time.Second, "Wait after switching writes, so writes in flight reach the old owners before the copy")
+	status := fs.Bool("status", false, "Only print the progress saved in -state")
+	fs.Parse(args)
+
+	if *status {
+		p, err := reshard.LoadProgress(*state)
+		if err != nil {
+			return err
+		}
+		out, _ := json.MarshalIndent(p, "", "  ")
+		fmt.Println(string(out))
+		return nil
+	}
+
+	if *to == "" {
+		return fmt.Errorf("-to is required")
+	}
+	if *replication != "primary" && *replication != "quorum" {
+		return fmt.Errorf("unknown replication mode %q", *replication)
+	}
+	// Retomada: a origem é a da migração gravada (o Core pode já estar na topologia nova)
+	if p, err := reshard.LoadProgress(*state); *from == "" && err == nil && p.Phase != reshard.PhaseDone {
+		*from = p.From
+	}
+	if *from == "" {
+		current, err := showTopology(*core)
+		if err != nil {
+			return fmt.Errorf("reading topology from core: %v", err)
+		}
+		*from = current.Shards
+	}
+	fromParts, err := ring.ParsePartitions(*from)
+	if err != nil {
+		return err
+	}
+	toParts, err := ring.ParsePartitions(*to)
+	if err != nil {
+		return err
+	}
+
+	var readers []reshard.Switch
+	for _, addr := range split(*aggregators) {
+		readers = append(readers, reshard.TopologySwitch(addr, requestTimeout))
+	}
+	m := &reshard.Migrator{
+		From:    fromParts,
+		To:      toParts,
+		KeyBy:   *routeBy,
+		Quorum:  *replication == "quorum",
+		Cluster: reshard.TCPCluster{Timeout: requestTimeout},
+		Readers: readers,
+		Writers: []reshard.Switch{reshard.TopologySwitch(*core, requestTimeout)},
+		Settle:  *settle,
+		State:   *state,
+		Logf: func(format string, args ...interface{}) {
+			fmt.Printf("[reshard] "+format+"\n", args...)
+		},
+	}
+	fmt.Printf("[reshard] %s -> %s\n", ring.FormatPartitions(fromParts), ring.FormatPartitions(toParts))
+	return m.Run()
+}
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	shard := fs.String("shard", "localhost:9001", "Shard address (a replica also works and spares the primary)")
+	dir := fs.String("dir", "", "New or empty directory on the shard's machine")
+	timeout := fs.Duration("timeout", 5*time.Minute, "Time to wait for the copy")
+	fs.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	conn, err := net.DialTimeout("tcp", *shard, requestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(*timeout))
+
+	if err := protocol.SendJSON(conn, protocol.NewMessage(protocol.MsgAdminBackup, protocol.BackupRequest{Dir: *dir})); err != nil {
+		return err
+	}
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Type == protocol.MsgError {
+		return fmt.Errorf("%s", resp.Payload)
+	}
+	var info storage.BackupInfo
+	if err := json.Unmarshal(resp.Payload, &info); err != nil {
+		return err
+	}
+	fmt.Printf("Backup of %s in %s: restorable from LSN %d to %d (%d log entries)\n", *shard, info.Dir, info.BaseLSN, info.LSN, info.Entries)
+	if !info.BaseTime.IsZero() {
+		fmt.Printf("Restorable times: %s to %s\n", info.BaseTime.Format(time.RFC3339), info.Time.Format(time.RFC3339))
+	} else {
+		fmt.Printf("Restorable times: up to %s\n", info.Time.Format(time.RFC3339))
+	}
+	return nil
+}
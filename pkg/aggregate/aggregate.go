@@ -0,0 +1,167 @@
+package aggregate
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Request é o payload de MsgReqAggregate (Cliente -> Aggregator e
+// Aggregator -> Shard). Os filtros de Query selecionam as transações (Order,
+// Limit e Cursor são ignorados); Bucket divide o período em faixas alinhadas
+// ao relógio. Bucket zero agrega o período inteiro em uma faixa por símbolo.
+type Request struct {
+	Query  query.Query   `json:"query"`
+	Bucket time.Duration `json:"bucket,omitempty"`
+	// CheckRaw pede ao shard só a conferência de que o período não tem
+	// histórico compactado pela retenção, sem calcular nada. O Aggregator a
+	// usa antes de agregar as transações brutas (durante um reshard), que
+	// não incluem esse histórico.
+	CheckRaw bool `json:"check_raw,omitempty"`
+}
+
+// Validate rejeita pedidos inválidos.
+func (r Request) Validate() error {
+	if r.Bucket < 0 {
+		return fmt.Errorf("bucket must not be negative")
+	}
+	if r.Bucket > 0 && r.Bucket < time.Second {
+		return fmt.Errorf("bucket must be at least 1s")
+	}
+	_, err := r.Query.Normalize()
+	return err
+}
+
+// Partial é o agregado de um símbolo em uma faixa [Start, Start+Bucket).
+//
+// Todos os campos se combinam sem perda (somas, mínimo e máximo), então os
+// parciais de vários shards viram o resultado global sem trafegar as
+// transações. VWAP é derivado de Notional/Quantity e recalculado após a junção.
+type Partial struct {
+	Symbol   string    `json:"symbol"`
+	Start    time.Time `json:"start"` // Zero quando o pedido não divide em faixas
+	Count    int64     `json:"count"`
+	Quantity int64     `json:"quantity"`
+	Notional float64   `json:"notional"` // Soma de preço × quantidade
+	MinPrice float64   `json:"min_price"`
+	MaxPrice float64   `json:"max_price"`
+	VWAP     float64   `json:"vwap"`
+}
+
+type key struct {
+	symbol string
+	start  int64
+}
+
+// Accumulator agrega transações (ou parciais de outros nós) por símbolo e faixa.
+type Accumulator struct {
+	bucket time.Duration
+	parts  map[key]*Partial
+}
+
+func NewAccumulator(bucket time.Duration) *Accumulator {
+	return &Accumulator{bucket: bucket, parts: make(map[key]*Partial)}
+}
+
+func (a *Accumulator) start(ts time.Time) time.Time {
+	if a.bucket <= 0 || ts.IsZero() {
+		return time.Time{}
+	}
+	return ts.Truncate(a.bucket).UTC()
+}
+
+// Add incorpora uma transação.
+func (a *Accumulator) Add(tx model.Transaction) {
+	a.Merge(Partial{
+		Symbol:   tx.Symbol,
+		Start:    a.start(tx.Timestamp),
+		Count:    1,
+		Quantity: int64(tx.Quantity),
+		Notional: tx.Price * float64(tx.Quantity),
+		MinPrice: tx.Price,
+		MaxPrice: tx.Price,
+	})
+}
+
+// Merge incorpora um parcial calculado com a mesma faixa ou com uma faixa
+// que divide a do acumulador (ex: parciais de 1m em um acumulador de 1h).
+func (a *Accumulator) Merge(p Partial) {
+	if p.Count == 0 {
+		return
+	}
+	p.Start = a.start(p.Start)
+	k := key{p.Symbol, 0}
+	if !p.Start.IsZero() {
+		k.start = p.Start.UnixNano()
+	}
+	cur, ok := a.parts[k]
+	if !ok {
+		p.VWAP = 0
+		a.parts[k] = &p
+		return
+	}
+	cur.Count += p.Count
+	cur.Quantity += p.Quantity
+	cur.Notional += p.Notional
+	if p.MinPrice < cur.MinPrice {
+		cur.MinPrice = p.MinPrice
+	}
+	if p.MaxPrice > cur.MaxPrice {
+		cur.MaxPrice = p.MaxPrice
+	}
+}
+
+// Prune descarta as faixas do símbolo que começam antes de before.
+func (a *Accumulator) Prune(symbol string, before time.Time) {
+	for k, p := range a.parts {
+		if p.Symbol == symbol && p.Start.Before(before) {
+			delete(a.parts, k)
+		}
+	}
+}
+
+// Partials devolve os agregados ordenados por símbolo e início da faixa, com o VWAP calculado.
+func (a *Accumulator) Partials() []Partial {
+	out := make([]Partial, 0, len(a.parts))
+	for _, p := range a.parts {
+		r := *p
+		if r.Quantity > 0 {
+			r.VWAP = r.Notional / float64(r.Quantity)
+		}
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Symbol != out[j].Symbol {
+			return out[i].Symbol < out[j].Symbol
+		}
+		return out[i].Start.Before(out[j].Start)
+	})
+	return out
+}
+
+// Compute agrega as transações que passam pelos filtros do pedido, lendo as
+// páginas de run (ex: Store.Query) até o fim para aproveitar os índices.
+func Compute(run func(query.Query) (query.Page, error), req Request) ([]Partial, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	q := req.Query
+	q.Order, q.Limit, q.Cursor = query.OrderAsc, query.MaxLimit, ""
+
+	acc := NewAccumulator(req.Bucket)
+	for {
+		page, err := run(q)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range page.Transactions {
+			acc.Add(tx)
+		}
+		if page.NextCursor == "" {
+			return acc.Partials(), nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
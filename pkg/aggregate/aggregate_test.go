@@ -0,0 +1,103 @@
+package aggregate
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+// node simula um shard: consulta paginada sobre as transações dele.
+func node(txs []model.Transaction) func(query.Query) (query.Page, error) {
+	return func(q query.Query) (query.Page, error) {
+		return query.Run(func(fn func(model.Transaction) bool) {
+			for _, tx := range txs {
+				if !fn(tx) {
+					return
+				}
+			}
+		}, q)
+	}
+}
+
+// TestPartialsMergeIntoGlobalResult valida que juntar os parciais de vários
+// nós dá o mesmo resultado que agregar todas as transações em um lugar só.
+func TestPartialsMergeIntoGlobalResult(t *testing.T) {
+	var shards [3][]model.Transaction
+	all := NewAccumulator(time.Minute)
+	for i := 0; i < 3000; i++ {
+		symbol := "PETR4"
+		if i%2 == 0 {
+			symbol = "VALE3"
+		}
+		tx := model.Transaction{
+			ID:        fmt.Sprintf("tx-%d", i),
+			Symbol:    symbol,
+			Price:     20 + float64(i%17),
+			Quantity:  100 * (i%4 + 1),
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+		}
+		shards[i%3] = append(shards[i%3], tx)
+		if tx.Symbol == "PETR4" && tx.Timestamp.Before(base.Add(30*time.Minute)) {
+			all.Add(tx)
+		}
+	}
+
+	req := Request{Query: query.Query{Symbol: "PETR4", To: base.Add(30 * time.Minute)}, Bucket: time.Minute}
+	merged := NewAccumulator(req.Bucket)
+	for _, txs := range shards {
+		partials, err := Compute(node(txs), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(partials) != 30 {
+			t.Fatalf("Esperava 30 faixas de 1m por shard, recebeu %d", len(partials))
+		}
+		for _, p := range partials {
+			merged.Merge(p)
+		}
+	}
+
+	got, want := merged.Partials(), all.Partials()
+	if len(got) != len(want) {
+		t.Fatalf("Esperava %d faixas, recebeu %d", len(want), len(got))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Symbol != w.Symbol || !g.Start.Equal(w.Start) || g.Count != w.Count || g.Quantity != w.Quantity ||
+			g.MinPrice != w.MinPrice || g.MaxPrice != w.MaxPrice || math.Abs(g.VWAP-w.VWAP) > 1e-9 {
+			t.Errorf("Faixa %d: esperava %+v, recebeu %+v", i, w, g)
+		}
+	}
+	if got[0].Count != 30 || !got[0].Start.Equal(base) {
+		t.Errorf("Primeira faixa deveria ter 30 transações a partir de %v: %+v", base, got[0])
+	}
+}
+
+// TestComputeWithoutBucket valida a faixa única por símbolo e a validação do pedido
+func TestComputeWithoutBucket(t *testing.T) {
+	txs := []model.Transaction{
+		{ID: "a", Symbol: "PETR4", Price: 10, Quantity: 100, Timestamp: base},
+		{ID: "b", Symbol: "PETR4", Price: 20, Quantity: 300, Timestamp: base.Add(time.Hour)},
+		{ID: "c", Symbol: "VALE3", Price: 50, Quantity: 10, Timestamp: base},
+	}
+	partials, err := Compute(node(txs), Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partials) != 2 || partials[0].Symbol != "PETR4" || !partials[0].Start.IsZero() {
+		t.Fatalf("Esperava uma faixa por símbolo, recebeu %+v", partials)
+	}
+	p := partials[0]
+	if p.Count != 2 || p.Quantity != 400 || p.Notional != 7000 || p.VWAP != 17.5 || p.MinPrice != 10 || p.MaxPrice != 20 {
+		t.Errorf("Agregado incorreto: %+v", p)
+	}
+
+	if _, err := Compute(node(txs), Request{Bucket: -time.Minute}); err == nil {
+		t.Error("Faixa negativa deveria ser rejeitada")
+	}
+}
@@ -0,0 +1,277 @@
+package alerts
+
+import (
+	"distributed-system/pkg/model"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type pricePoint struct {
+	price float64
+	ts    time.Time
+}
+
+// ruleState é o estado de avaliação de uma regra (não persistido: é
+// reconstruído a partir das cotações seguintes).
+type ruleState struct {
+	rule      model.AlertRule
+	armedUp   bool // Vimos o preço abaixo de Threshold - Hysteresis
+	armedDown bool // Vimos o preço acima de Threshold + Hysteresis
+	lastFired time.Time
+	window    []pricePoint // Regras de variação
+}
+
+// Engine avalia as regras de alerta a cada cotação.
+//
+// Regras de cruzamento só disparam em uma transição observada: cross_above
+// precisa ver o preço abaixo de Threshold - Hysteresis antes de disparar ao
+// atingir Threshold, e só volta a disparar depois de voltar para baixo dessa
+// marca. Assim, ticks oscilando em torno do limiar não geram um alerta cada,
+// e um restart do serviço não redispara alertas já entregues.
+type Engine struct {
+	mu       sync.Mutex
+	path     string // Arquivo JSON onde as regras são persistidas ("" = apenas memória)
+	rules    map[string]*ruleState
+	lastSeen map[string]time.Time // Última cotação processada por símbolo
+}
+
+// NewEngine carrega as regras persistidas em path, se existirem.
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{
+		path:     path,
+		rules:    make(map[string]*ruleState),
+		lastSeen: make(map[string]time.Time),
+	}
+	if path == "" {
+		return e, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rules []model.AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("corrupted rules file %s: %v", path, err)
+	}
+	for _, rule := range rules {
+		e.rules[rule.ID] = &ruleState{rule: rule}
+	}
+	return e, nil
+}
+
+// Register valida e persiste uma nova regra, atribuindo seu ID.
+func (e *Engine) Register(rule model.AlertRule) (model.AlertRule, error) {
+	if err := validate(rule); err != nil {
+		return model.AlertRule{}, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rule.CreatedAt = time.Now()
+	rule.ID = rule.User + "-" + strconv.FormatInt(rule.CreatedAt.UnixNano(), 36)
+	e.rules[rule.ID] = &ruleState{rule: rule}
+	if err := e.save(); err != nil {
+		delete(e.rules, rule.ID)
+		return model.AlertRule{}, err
+	}
+	return rule, nil
+}
+
+// Delete remove a regra do usuário.
+func (e *Engine) Delete(user, id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, ok := e.rules[id]
+	if !ok || st.rule.User != user {
+		return fmt.Errorf("rule %q not found", id)
+	}
+	delete(e.rules, id)
+	return e.save()
+}
+
+// List retorna as regras do usuário (todas, se user for vazio), por data de criação.
+func (e *Engine) List(user string) []model.AlertRule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var rules []model.AlertRule
+	for _, st := range e.rules {
+		if user == "" || st.rule.User == user {
+			rules = append(rules, st.rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+	return rules
+}
+
+// Symbols retorna os símbolos com ao menos uma regra (tópicos a assinar).
+func (e *Engine) Symbols() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, st := range e.rules {
+		if !seen[st.rule.Symbol] {
+			seen[st.rule.Symbol] = true
+			symbols = append(symbols, st.rule.Symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Evaluate aplica a cotação a todas as regras do símbolo e retorna os alertas disparados.
+// Cotações repetidas ou mais antigas que a última processada são ignoradas.
+func (e *Engine) Evaluate(quote model.Quote) []model.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if last, ok := e.lastSeen[quote.Symbol]; ok && !quote.Timestamp.After(last) {
+		return nil
+	}
+	e.lastSeen[quote.Symbol] = quote.Timestamp
+
+	var fired []model.Alert
+	for _, st := range e.rules {
+		if st.rule.Symbol != quote.Symbol {
+			continue
+		}
+		message := st.evaluate(quote)
+		if message == "" {
+			continue
+		}
+		cooldown := time.Duration(st.rule.CooldownSeconds) * time.Second
+		if !st.lastFired.IsZero() && quote.Timestamp.Sub(st.lastFired) < cooldown {
+			continue
+		}
+		st.lastFired = quote.Timestamp
+		fired = append(fired, model.Alert{
+			RuleID:      st.rule.ID,
+			User:        st.rule.User,
+			Symbol:      quote.Symbol,
+			Kind:        st.rule.Kind,
+			Price:       quote.Price,
+			Message:     message,
+			TriggeredAt: time.Now(),
+		})
+	}
+	sort.Slice(fired, func(i, j int) bool { return fired[i].RuleID < fired[j].RuleID })
+	return fired
+}
+
+// evaluate atualiza o estado da regra e retorna a mensagem do alerta, se disparou.
+func (st *ruleState) evaluate(quote model.Quote) string {
+	r := st.rule
+	price := quote.Price
+
+	switch r.Kind {
+	case model.AlertCrossAbove, model.AlertCrossBelow, model.AlertCross:
+		checkUp := r.Kind != model.AlertCrossBelow
+		checkDown := r.Kind != model.AlertCrossAbove
+
+		if checkUp {
+			if price < r.Threshold-r.Hysteresis {
+				st.armedUp = true
+			} else if price >= r.Threshold && st.armedUp {
+				st.armedUp = false
+				return fmt.Sprintf("%s crossed above %.2f (price %.2f)", r.Symbol, r.Threshold, price)
+			}
+		}
+		if checkDown {
+			if price > r.Threshold+r.Hysteresis {
+				st.armedDown = true
+			} else if price <= r.Threshold && st.armedDown {
+				st.armedDown = false
+				return fmt.Sprintf("%s crossed below %.2f (price %.2f)", r.Symbol, r.Threshold, price)
+			}
+		}
+
+	case model.AlertMove:
+		window := time.Duration(r.WindowSeconds) * time.Second
+		kept := st.window[:0]
+		for _, p := range st.window {
+			if quote.Timestamp.Sub(p.ts) <= window {
+				kept = append(kept, p)
+			}
+		}
+		st.window = kept
+
+		var message string
+		if len(st.window) > 0 {
+			low, high := st.window[0].price, st.window[0].price
+			for _, p := range st.window {
+				if p.price < low {
+					low = p.price
+				}
+				if p.price > high {
+					high = p.price
+				}
+			}
+			if up := (price - low) / low * 100; up >= r.MovePct {
+				message = fmt.Sprintf("%s up %.2f%% within %v (%.2f -> %.2f)", r.Symbol, up, window, low, price)
+			} else if down := (high - price) / high * 100; down >= r.MovePct {
+				message = fmt.Sprintf("%s down %.2f%% within %v (%.2f -> %.2f)", r.Symbol, down, window, high, price)
+			}
+		}
+
+		if message != "" {
+			// Recomeçar a janela: o mesmo movimento não dispara de novo a cada tick
+			st.window = st.window[:0]
+		}
+		st.window = append(st.window, pricePoint{price: price, ts: quote.Timestamp})
+		return message
+	}
+	return ""
+}
+
+func validate(rule model.AlertRule) error {
+	switch {
+	case rule.User == "" || rule.Symbol == "":
+		return fmt.Errorf("user and symbol are required")
+	case rule.Hysteresis < 0 || rule.CooldownSeconds < 0:
+		return fmt.Errorf("hysteresis and cooldown must not be negative")
+	}
+	switch rule.Kind {
+	case model.AlertCrossAbove, model.AlertCrossBelow, model.AlertCross:
+		if rule.Threshold <= 0 {
+			return fmt.Errorf("threshold must be positive for %s rules", rule.Kind)
+		}
+	case model.AlertMove:
+		if rule.MovePct <= 0 || rule.WindowSeconds <= 0 {
+			return fmt.Errorf("move_pct and window_seconds must be positive for move rules")
+		}
+	default:
+		return fmt.Errorf("unknown alert kind %q", rule.Kind)
+	}
+	return nil
+}
+
+// save grava todas as regras de forma atômica (arquivo temporário + rename).
+// Deve ser chamada com o lock.
+func (e *Engine) save() error {
+	if e.path == "" {
+		return nil
+	}
+	rules := make([]model.AlertRule, 0, len(e.rules))
+	for _, st := range e.rules {
+		rules = append(rules, st.rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := e.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.path)
+}
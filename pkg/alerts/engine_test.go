@@ -0,0 +1,86 @@
+package alerts
+
+import (
+	"distributed-system/pkg/model"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// feed envia uma sequência de preços com timestamps crescentes e conta os alertas.
+func feed(e *Engine, start time.Time, step time.Duration, prices ...float64) []model.Alert {
+	var fired []model.Alert
+	for i, p := range prices {
+		quote := model.Quote{Symbol: "PETR4", Price: p, Timestamp: start.Add(time.Duration(i) * step)}
+		fired = append(fired, e.Evaluate(quote)...)
+	}
+	return fired
+}
+
+// TestCrossWithHysteresis valida que oscilações em torno do limiar não disparam a cada tick
+func TestCrossWithHysteresis(t *testing.T) {
+	e, _ := NewEngine("")
+	if _, err := e.Register(model.AlertRule{User: "ana", Symbol: "PETR4", Kind: model.AlertCrossAbove, Threshold: 25, Hysteresis: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Now()
+	// Começa acima do limiar: sem transição observada, nada dispara
+	if fired := feed(e, base, time.Second, 25.5, 26); len(fired) != 0 {
+		t.Fatalf("Não deveria disparar sem cruzamento observado: %+v", fired)
+	}
+	// Desce, cruza para cima e oscila perto do limiar (sem sair da histerese)
+	fired := feed(e, base.Add(time.Minute), time.Second, 24, 25.1, 24.8, 25.2, 24.9, 25.3)
+	if len(fired) != 1 || fired[0].Price != 25.1 {
+		t.Fatalf("Esperava exatamente 1 alerta no primeiro cruzamento, recebi %+v", fired)
+	}
+	// Sai da histerese para baixo e cruza de novo: novo alerta
+	if fired := feed(e, base.Add(2*time.Minute), time.Second, 24.4, 25.0); len(fired) != 1 {
+		t.Errorf("Esperava novo alerta após rearmar, recebi %+v", fired)
+	}
+	// Cotação repetida (mesmo timestamp) é ignorada
+	if fired := feed(e, base.Add(2*time.Minute), time.Second, 24.4, 25.0); len(fired) != 0 {
+		t.Errorf("Cotações repetidas não deveriam disparar: %+v", fired)
+	}
+}
+
+// TestMoveRuleAndCooldown valida a regra de variação percentual dentro da janela
+func TestMoveRuleAndCooldown(t *testing.T) {
+	e, _ := NewEngine("")
+	e.Register(model.AlertRule{User: "bia", Symbol: "PETR4", Kind: model.AlertMove, MovePct: 3, WindowSeconds: 600, CooldownSeconds: 60})
+
+	base := time.Now()
+	// 20 -> 20.7 = +3.5% em 2 minutos: dispara uma vez, e o mesmo movimento não redispara
+	fired := feed(e, base, time.Minute, 20, 20.3, 20.7, 20.8)
+	if len(fired) != 1 || fired[0].Price != 20.7 {
+		t.Fatalf("Esperava 1 alerta de variação, recebi %+v", fired)
+	}
+	// Queda fora da janela de 10 minutos não conta
+	if fired := feed(e, base.Add(time.Hour), 11*time.Minute, 21, 20); len(fired) != 0 {
+		t.Errorf("Variação fora da janela não deveria disparar: %+v", fired)
+	}
+}
+
+// TestRulesPersistAcrossRestarts valida que as regras sobrevivem a um restart
+func TestRulesPersistAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	e, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := e.Register(model.AlertRule{User: "caio", Symbol: "VALE3", Kind: model.AlertCross, Threshold: 60})
+	e.Register(model.AlertRule{User: "caio", Symbol: "PETR4", Kind: model.AlertCrossBelow, Threshold: 20})
+	if _, err := e.Register(model.AlertRule{User: "caio", Symbol: "PETR4", Kind: "explode"}); err == nil {
+		t.Error("Tipo de regra inválido deveria ser rejeitado")
+	}
+	e.Delete("caio", rule.ID)
+
+	restarted, err := NewEngine(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := restarted.List("caio")
+	if len(rules) != 1 || rules[0].Symbol != "PETR4" {
+		t.Errorf("Regras recuperadas incorretas: %+v", rules)
+	}
+}
@@ -0,0 +1,252 @@
+package bulk
+
+import (
+	"bytes"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/ring"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReaderReportsInvalidLinesAndContinues(t *testing.T) {
+	csvInput := `id,symbol,price,quantity,timestamp
+tx-1,PETR4,25.5,100,2024-03-01T10:00:00Z
+tx-2,PETR4,abc,100,2024-03-01T10:00:01Z
+tx-3,VALE3,60,0,2024-03-01T10:00:02Z
+tx-4,VALE3,60,10
+tx-5,VALE3,61,10,2024-03-01T10:00:04.5Z
+`
+	jsonlInput := `{"id":"tx-1","symbol":"PETR4","price":25.5,"quantity":100,"timestamp":"2024-03-01T10:00:00Z"}
+{"id":"tx-2","symbol":"PETR4","price":25.5,"quantity":100
+
+{"id":"","symbol":"VALE3","price":60,"quantity":10,"timestamp":"2024-03-01T10:00:02Z"}
+{"id":"tx-5","symbol":"VALE3","price":61,"quantity":10,"timestamp":"2024-03-01T10:00:04.5Z","version":3}
+`
+	for _, tc := range []struct {
+		format, input string
+		badLines      []int
+	}{
+		{FormatCSV, csvInput, []int{3, 4, 5}},
+		{FormatJSONL, jsonlInput, []int{2, 4}},
+	} {
+		r, err := NewReader(strings.NewReader(tc.input), tc.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		var bad []int
+		for {
+			tx, line, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if lineErr, ok := err.(*LineError); ok {
+				if lineErr.Line != line {
+					t.Errorf("%s: linha do erro (%d) diferente da devolvida (%d)", tc.format, lineErr.Line, line)
+				}
+				bad = append(bad, line)
+				continue
+			} else if err != nil {
+				t.Fatalf("%s: erro inesperado: %v", tc.format, err)
+			}
+			ids = append(ids, tx.ID)
+		}
+		if fmt.Sprint(ids) != "[tx-1 tx-5]" || fmt.Sprint(bad) != fmt.Sprint(tc.badLines) {
+			t.Errorf("%s: válidas %v e inválidas %v, esperava [tx-1 tx-5] e %v", tc.format, ids, bad, tc.badLines)
+		}
+	}
+
+	if _, err := NewReader(strings.NewReader("id,symbol,price\n"), FormatCSV); err == nil {
+		t.Error("Cabeçalho sem timestamp e quantity deveria ser rejeitado")
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	txs := []model.Transaction{
+		{ID: "tx-1", Symbol: "PETR4", Price: 25.123, Quantity: 100, Timestamp: time.Date(2024, 3, 1, 10, 0, 0, 123456789, time.UTC)},
+		{ID: "tx,2", Symbol: "VALE3", Price: 60, Quantity: 5, Timestamp: time.Date(2024, 3, 1, 11, 0, 0, 0, time.UTC), Version: 42},
+	}
+	for _, format := range []string{FormatCSV, FormatJSONL} {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, format)
+		for _, tx := range txs {
+			w.Write(tx)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := NewReader(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range txs {
+			got, _, err := r.Read()
+			if err != nil || got.ID != want.ID || got.Price != want.Price || got.Version != want.Version || !got.Timestamp.Equal(want.Timestamp) {
+				t.Errorf("%s: releu %+v (%v), esperava %+v", format, got, err, want)
+			}
+		}
+		if _, _, err := r.Read(); err != io.EOF {
+			t.Errorf("%s: esperava o fim da entrada, recebeu %v", format, err)
+		}
+	}
+}
+
+// fakeCluster guarda os lotes recebidos por nó e serve o histórico de cada nó.
+type fakeCluster struct {
+	batches map[string][][]model.Transaction
+	stored  map[string]map[string]model.Transaction
+	down    map[string]bool
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{
+		batches: make(map[string][][]model.Transaction),
+		stored:  make(map[string]map[string]model.Transaction),
+		down:    make(map[string]bool),
+	}
+}
+
+func (c *fakeCluster) StoreBatch(node string, txs []model.Transaction) (protocol.BatchResult, error) {
+	c.batches[node] = append(c.batches[node], txs)
+	if c.stored[node] == nil {
+		c.stored[node] = make(map[string]model.Transaction)
+	}
+	var res protocol.BatchResult
+	for _, tx := range txs {
+		if old, ok := c.stored[node][tx.ID]; ok && old.Version >= tx.Version {
+			res.Unchanged++
+			continue
+		}
+		c.stored[node][tx.ID] = tx
+		res.Applied++
+	}
+	return res, nil
+}
+
+func (c *fakeCluster) Page(node string, q query.Query) (query.Page, error) {
+	if c.down[node] {
+		return query.Page{}, fmt.Errorf("connection refused")
+	}
+	return query.Run(func(fn func(tx model.Transaction) bool) {
+		for _, tx := range c.stored[node] {
+			if !fn(tx) {
+				return
+			}
+		}
+	}, q)
+}
+
+func TestImporterRoutesBatchesAndDedupes(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("id,symbol,price,quantity,timestamp\n")
+	symbols := []string{"PETR4", "VALE3", "ITUB4", "BBDC4"}
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "tx-%d,%s,%d,10,2024-03-01T10:%02d:%02dZ\n", i%900, symbols[i%4], 20+i%7, i/60%60, i%60)
+	}
+	input.WriteString("broken,PETR4\n")
+
+	parts, _ := ring.ParsePartitions("s1|s1r,s2|s2r")
+	router, _ := ring.NewPartitionedRouter(parts, ring.KeyBySymbol)
+	cluster := newFakeCluster()
+	var invalid []int
+	progress := 0
+	im := &Importer{
+		Router:    router,
+		Quorum:    true,
+		Sink:      cluster,
+		BatchSize: 100,
+		Progress:  func(Stats) { progress++ },
+		Invalid:   func(e *LineError) { invalid = append(invalid, e.Line) },
+	}
+	r, _ := NewReader(strings.NewReader(input.String()), FormatCSV)
+	stats, err := im.Import(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Lines != 1002 || stats.Imported != 900 || stats.Duplicates != 100 || stats.Invalid != 1 || fmt.Sprint(invalid) != "[1002]" {
+		t.Errorf("Estatísticas inesperadas: %+v (inválidas %v)", stats, invalid)
+	}
+
+	total := 0
+	for _, p := range parts {
+		if len(cluster.stored[p.Primary]) != len(cluster.stored[p.Replicas[0]]) {
+			t.Errorf("No quórum a réplica %s deveria receber os mesmos lotes do primário", p.Replicas[0])
+		}
+		for _, batch := range cluster.batches[p.Primary] {
+			if len(batch) > 100 {
+				t.Errorf("Lote de %d transações excede o limite", len(batch))
+			}
+			progress--
+		}
+		for _, tx := range cluster.stored[p.Primary] {
+			if owner := router.OwnerOf(tx); owner != p.Primary {
+				t.Errorf("%s (%s) gravada em %s, dono é %s", tx.ID, tx.Symbol, p.Primary, owner)
+			}
+			total++
+		}
+	}
+	if total != 900 || progress != 0 {
+		t.Errorf("Esperava 900 transações e um aviso de progresso por lote, recebeu %d e sobra %d", total, progress)
+	}
+
+	// Reimportar não grava nada de novo
+	r, _ = NewReader(strings.NewReader(input.String()), FormatCSV)
+	if stats, _ = im.Import(r); stats.Imported != 0 || stats.Unchanged != 900 {
+		t.Errorf("Reimportação deveria ser idempotente: %+v", stats)
+	}
+}
+
+func TestExporterFailsOverAndDedupes(t *testing.T) {
+	cluster := newFakeCluster()
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	var all []model.Transaction
+	for i := 0; i < 2500; i++ {
+		tx := model.Transaction{ID: fmt.Sprintf("tx-%04d", i), Symbol: "PETR4", Price: 25, Quantity: 1, Timestamp: base.Add(time.Duration(i) * time.Second)}
+		all = append(all, tx)
+		node := "s1"
+		if i%2 == 1 {
+			node = "s2"
+		}
+		cluster.StoreBatch(node, []model.Transaction{tx})
+		if node == "s1" {
+			cluster.StoreBatch("s1r", []model.Transaction{tx})
+		}
+		if i < 100 { // Cópia de uma migração em andamento
+			cluster.StoreBatch("s3", []model.Transaction{tx})
+		}
+	}
+	cluster.down["s1"] = true
+
+	parts, _ := ring.ParsePartitions("s3,s1|s1r,s2")
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, FormatJSONL)
+	e := &Exporter{Partitions: parts, Source: cluster}
+	n, err := e.Export(query.Query{From: base.Add(10 * time.Second)}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewReader(&buf, FormatJSONL)
+	var ids []string
+	for {
+		tx, _, err := r.Read()
+		if err != nil {
+			break
+		}
+		ids = append(ids, tx.ID)
+	}
+	sort.Strings(ids)
+	if n != 2490 || len(ids) != 2490 || ids[0] != all[10].ID || ids[len(ids)-1] != all[2499].ID {
+		t.Errorf("Esperava tx-0010..tx-2499 sem repetição, exportou %d (%d lidas)", n, len(ids))
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			t.Fatalf("%s exportada duas vezes", ids[i])
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package bulk
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/query"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCPShards é o Sink e o Source sobre o protocolo dos shards.
+type TCPShards struct {
+	Timeout time.Duration
+}
+
+func (c TCPShards) StoreBatch(node string, txs []model.Transaction) (protocol.BatchResult, error) {
+	var res protocol.BatchResult
+	err := call(node, c.Timeout, protocol.NewMessage(protocol.MsgStoreBatch, txs), &res)
+	return res, err
+}
+
+func (c TCPShards) Page(node string, q query.Query) (query.Page, error) {
+	var page query.Page
+	err := call(node, c.Timeout, protocol.NewMessage(protocol.MsgReqHistory, q), &page)
+	return page, err
+}
+
+func call(addr string, timeout time.Duration, msg protocol.Message, out interface{}) error {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := protocol.SendJSON(conn, msg); err != nil {
+		return err
+	}
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Type == protocol.MsgError {
+		var reason string
+		json.Unmarshal(resp.Payload, &reason)
+		return fmt.Errorf("%s", reason)
+	}
+	return json.Unmarshal(resp.Payload, out)
+}
@@ -0,0 +1,85 @@
+package bulk
+
+import (
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/ring"
+	"fmt"
+	"strings"
+)
+
+// Source lê páginas de histórico de um nó.
+type Source interface {
+	Page(node string, q query.Query) (query.Page, error)
+}
+
+// Exporter grava o histórico de todas as partições em um Writer, página a
+// página. Cada partição é lida de um único nó (o primário, ou a réplica
+// seguinte se ele falhar); como o cursor vale em qualquer nó, a leitura
+// continua de onde parou. A ordem é por (Timestamp, ID) dentro de cada partição.
+type Exporter struct {
+	// Partitions a ler. Durante uma migração, as de destino devem vir antes:
+	// elas têm as gravações mais novas e as cópias antigas são descartadas pelo ID.
+	Partitions []ring.Partition
+	Source     Source
+	// Progress é chamado depois de cada página com o total exportado (opcional).
+	Progress func(exported int)
+}
+
+// Export grava as transações que atendem q (Order, Limit e Cursor são
+// ignorados) e devolve quantas foram gravadas.
+func (e *Exporter) Export(q query.Query, w *Writer) (int, error) {
+	q.Order = query.OrderAsc
+	q.Limit = query.MaxLimit
+	q.Cursor = ""
+	exported := 0
+	seen := make(map[string]bool)
+	done := make(map[string]bool)
+
+	for _, part := range e.Partitions {
+		if done[part.Primary] {
+			continue
+		}
+		done[part.Primary] = true
+
+		nodes := part.Nodes()
+		cursor := ""
+		for {
+			page, err := e.page(nodes, q, cursor)
+			if err != nil {
+				return exported, err
+			}
+			for _, tx := range page.Transactions {
+				if seen[tx.ID] {
+					continue
+				}
+				seen[tx.ID] = true
+				if err := w.Write(tx); err != nil {
+					return exported, err
+				}
+				exported++
+			}
+			if e.Progress != nil {
+				e.Progress(exported)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+	}
+	return exported, w.Flush()
+}
+
+// page lê a página a partir de cursor no primeiro nó que responder.
+func (e *Exporter) page(nodes []string, q query.Query, cursor string) (query.Page, error) {
+	q.Cursor = cursor
+	var errs []string
+	for _, node := range nodes {
+		page, err := e.Source.Page(node, q)
+		if err == nil {
+			return page, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", node, err))
+	}
+	return query.Page{}, fmt.Errorf("reading partition %s: %s", nodes[0], strings.Join(errs, "; "))
+}
@@ -0,0 +1,250 @@
+package bulk
+
+import (
+	"bufio"
+	"distributed-system/pkg/model"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formatos de arquivo suportados.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl" // Uma transação JSON por linha
+)
+
+// Colunas do CSV. O cabeçalho é obrigatório na leitura e define a ordem;
+// version é opcional.
+var csvColumns = []string{"id", "symbol", "price", "quantity", "timestamp", "version"}
+
+// maxLineSize limita o tamanho de uma linha JSONL.
+const maxLineSize = 1 << 20
+
+// FormatOf deduz o formato pela extensão do arquivo ("" se desconhecida).
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	}
+	return ""
+}
+
+func checkFormat(format string) error {
+	if format != FormatCSV && format != FormatJSONL {
+		return fmt.Errorf("unknown format %q (use %s or %s)", format, FormatCSV, FormatJSONL)
+	}
+	return nil
+}
+
+// LineError é uma linha inválida da entrada. A leitura pode continuar depois dela.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Validate rejeita transações que o cluster não aceitaria ou que não fazem sentido.
+func Validate(tx model.Transaction) error {
+	switch {
+	case tx.ID == "":
+		return fmt.Errorf("missing id")
+	case tx.Symbol == "":
+		return fmt.Errorf("missing symbol")
+	case tx.Price <= 0:
+		return fmt.Errorf("price must be positive")
+	case tx.Quantity <= 0:
+		return fmt.Errorf("quantity must be positive")
+	case tx.Timestamp.IsZero():
+		return fmt.Errorf("missing timestamp")
+	case tx.Version < 0:
+		return fmt.Errorf("version must not be negative")
+	}
+	return nil
+}
+
+// Reader lê transações de um CSV ou JSONL, uma por vez, sem carregar a
+// entrada inteira na memória.
+type Reader struct {
+	csv     *csv.Reader
+	cols    map[string]int
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader prepara a leitura; no CSV já consome e valida o cabeçalho.
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	if format == FormatJSONL {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &Reader{scanner: scanner}, nil
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // Contagem errada vira erro da linha, não da leitura
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty CSV: missing header")
+	} else if err != nil {
+		return nil, fmt.Errorf("reading CSV header: %v", err)
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns[:5] {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column %q", name)
+		}
+	}
+	return &Reader{csv: cr, cols: cols}, nil
+}
+
+// Read devolve a próxima transação válida e o número da linha dela. Linhas
+// inválidas devolvem um *LineError; io.EOF marca o fim da entrada. Qualquer
+// outro erro (de leitura) encerra a entrada.
+func (r *Reader) Read() (model.Transaction, int, error) {
+	if r.scanner != nil {
+		return r.readJSONL()
+	}
+	return r.readCSV()
+}
+
+func (r *Reader) readJSONL() (model.Transaction, int, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		var tx model.Transaction
+		if err := json.Unmarshal([]byte(line), &tx); err != nil {
+			return tx, r.line, &LineError{Line: r.line, Err: err}
+		}
+		if err := Validate(tx); err != nil {
+			return tx, r.line, &LineError{Line: r.line, Err: err}
+		}
+		return tx, r.line, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return model.Transaction{}, r.line, fmt.Errorf("line %d: %v", r.line+1, err)
+	}
+	return model.Transaction{}, r.line, io.EOF
+}
+
+func (r *Reader) readCSV() (model.Transaction, int, error) {
+	record, err := r.csv.Read()
+	if err == io.EOF {
+		return model.Transaction{}, r.line, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		r.line = parseErr.Line
+		return model.Transaction{}, r.line, &LineError{Line: parseErr.Line, Err: parseErr.Err}
+	} else if err != nil {
+		return model.Transaction{}, r.line, err
+	}
+	r.line, _ = r.csv.FieldPos(0)
+
+	field := func(name string) string {
+		if i, ok := r.cols[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	if len(record) < len(r.cols) {
+		return model.Transaction{}, r.line, &LineError{Line: r.line, Err: fmt.Errorf("expected %d fields, got %d", len(r.cols), len(record))}
+	}
+	tx, err := parseRecord(field)
+	if err == nil {
+		err = Validate(tx)
+	}
+	if err != nil {
+		return tx, r.line, &LineError{Line: r.line, Err: err}
+	}
+	return tx, r.line, nil
+}
+
+func parseRecord(field func(string) string) (model.Transaction, error) {
+	tx := model.Transaction{ID: field("id"), Symbol: field("symbol")}
+	var err error
+	if tx.Price, err = strconv.ParseFloat(field("price"), 64); err != nil {
+		return tx, fmt.Errorf("invalid price %q", field("price"))
+	}
+	if tx.Quantity, err = strconv.Atoi(field("quantity")); err != nil {
+		return tx, fmt.Errorf("invalid quantity %q", field("quantity"))
+	}
+	if tx.Timestamp, err = time.Parse(time.RFC3339Nano, field("timestamp")); err != nil {
+		return tx, fmt.Errorf("invalid timestamp %q (use RFC 3339)", field("timestamp"))
+	}
+	if v := field("version"); v != "" {
+		if tx.Version, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return tx, fmt.Errorf("invalid version %q", v)
+		}
+	}
+	return tx, nil
+}
+
+// Writer grava transações em CSV (com cabeçalho) ou JSONL.
+type Writer struct {
+	csv *csv.Writer
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func NewWriter(w io.Writer, format string) (*Writer, error) {
+	if err := checkFormat(format); err != nil {
+		return nil, err
+	}
+	if format == FormatJSONL {
+		buf := bufio.NewWriter(w)
+		return &Writer{buf: buf, enc: json.NewEncoder(buf)}, nil
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvColumns); err != nil {
+		return nil, err
+	}
+	return &Writer{csv: cw}, nil
+}
+
+func (w *Writer) Write(tx model.Transaction) error {
+	if w.enc != nil {
+		return w.enc.Encode(tx)
+	}
+	version := ""
+	if tx.Version != 0 {
+		version = strconv.FormatInt(tx.Version, 10)
+	}
+	return w.csv.Write([]string{
+		tx.ID,
+		tx.Symbol,
+		strconv.FormatFloat(tx.Price, 'f', -1, 64),
+		strconv.Itoa(tx.Quantity),
+		tx.Timestamp.UTC().Format(time.RFC3339Nano),
+		version,
+	})
+}
+
+// Flush descarrega o que estiver em buffer.
+func (w *Writer) Flush() error {
+	if w.buf != nil {
+		return w.buf.Flush()
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
@@ -0,0 +1,126 @@
+package bulk
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/ring"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultBatchSize é o tamanho padrão dos lotes enviados a cada shard.
+const DefaultBatchSize = 500
+
+// Sink grava lotes de transações em um nó.
+type Sink interface {
+	StoreBatch(node string, txs []model.Transaction) (protocol.BatchResult, error)
+}
+
+// Stats é o progresso de uma importação.
+type Stats struct {
+	Lines      int           `json:"lines"`      // Linhas lidas (inclui as inválidas)
+	Imported   int           `json:"imported"`   // Transações gravadas
+	Unchanged  int           `json:"unchanged"`  // Já estavam no cluster com a mesma versão ou mais nova
+	Duplicates int           `json:"duplicates"` // IDs repetidos na própria entrada (fica a primeira ocorrência)
+	Invalid    int           `json:"invalid"`    // Linhas rejeitadas na validação
+	Elapsed    time.Duration `json:"elapsed"`
+}
+
+// Importer envia transações lidas de um Reader para os shards donos.
+//
+// As transações são roteadas como o Core faria (o dono de destino durante
+// uma migração) e agrupadas em lotes por shard; no modo quórum cada lote vai
+// para todos os nós da partição. Reimportar o mesmo arquivo é seguro: o shard
+// só substitui um ID por uma versão maior, e a mesma versão conta como Unchanged.
+type Importer struct {
+	Router    *ring.Router
+	Quorum    bool
+	Sink      Sink
+	BatchSize int // Padrão DefaultBatchSize
+	// Progress é chamado depois de cada lote enviado (opcional).
+	Progress func(Stats)
+	// Invalid recebe cada linha rejeitada (opcional); a importação continua.
+	Invalid func(*LineError)
+}
+
+// Import lê toda a entrada. Um erro de leitura ou de um shard interrompe a
+// importação; o que já foi enviado continua gravado.
+func (im *Importer) Import(r *Reader) (Stats, error) {
+	size := im.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	start := time.Now()
+	var stats Stats
+	seen := make(map[string]bool)
+	pending := make(map[string][]model.Transaction)
+
+	flush := func(owner string) error {
+		batch := pending[owner]
+		if len(batch) == 0 {
+			return nil
+		}
+		delete(pending, owner)
+		targets := []string{owner}
+		if im.Quorum {
+			targets = im.Router.Partition(owner).Nodes()
+		}
+		for i, node := range targets {
+			res, err := im.Sink.StoreBatch(node, batch)
+			if err != nil {
+				return fmt.Errorf("storing batch on %s: %v", node, err)
+			}
+			if i == 0 { // Os outros nós do quórum recebem as mesmas transações
+				stats.Imported += res.Applied
+				stats.Unchanged += res.Unchanged
+			}
+		}
+		if im.Progress != nil {
+			stats.Elapsed = time.Since(start)
+			im.Progress(stats)
+		}
+		return nil
+	}
+
+	for {
+		tx, line, err := r.Read()
+		stats.Lines = line
+		if err == io.EOF {
+			break
+		}
+		if lineErr, ok := err.(*LineError); ok {
+			stats.Invalid++
+			if im.Invalid != nil {
+				im.Invalid(lineErr)
+			}
+			continue
+		} else if err != nil {
+			stats.Elapsed = time.Since(start)
+			return stats, err
+		}
+
+		if seen[tx.ID] {
+			stats.Duplicates++
+			continue
+		}
+		seen[tx.ID] = true
+
+		owner := im.Router.OwnerOf(tx)
+		pending[owner] = append(pending[owner], tx)
+		if len(pending[owner]) >= size {
+			if err := flush(owner); err != nil {
+				stats.Elapsed = time.Since(start)
+				return stats, err
+			}
+		}
+	}
+	for owner := range pending {
+		if err := flush(owner); err != nil {
+			stats.Elapsed = time.Since(start)
+			return stats, err
+		}
+	}
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
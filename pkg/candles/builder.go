@@ -0,0 +1,155 @@
+package candles
+
+import (
+	"distributed-system/pkg/model"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Label formata o intervalo como usado nos tópicos derivados ("1s", "1m", "5m", "1h").
+func Label(interval time.Duration) string {
+	switch {
+	case interval%time.Hour == 0:
+		return fmt.Sprintf("%dh", interval/time.Hour)
+	case interval%time.Minute == 0:
+		return fmt.Sprintf("%dm", interval/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", interval/time.Second)
+	}
+}
+
+// Topic é o tópico derivado onde os candles finalizados são publicados, ex: "candles.1m.PETR4".
+func Topic(interval time.Duration, symbol string) string {
+	return "candles." + Label(interval) + "." + symbol
+}
+
+// windowKey identifica uma janela aberta de um símbolo em um intervalo.
+type windowKey struct {
+	symbol   string
+	interval time.Duration
+	start    int64 // UnixNano do início da janela
+}
+
+// openCandle acumula os ticks de uma janela ainda não finalizada.
+// Open/Close seguem o timestamp do tick, não a ordem de chegada.
+type openCandle struct {
+	candle  model.Candle
+	openTs  time.Time
+	closeTs time.Time
+}
+
+// Builder agrega cotações em candles OHLCV de vários intervalos.
+//
+// As janelas são alinhadas ao relógio (ex: 1m começa no segundo zero) e
+// classificadas pelo timestamp da cotação. Uma janela só é finalizada quando o
+// relógio passa de End + grace, o que dá às cotações atrasadas (o Broker não
+// garante ordem) a chance de entrar no candle certo. Ticks que chegam depois
+// que a janela já foi finalizada são descartados dela e contados em Late.
+type Builder struct {
+	mu        sync.Mutex
+	intervals []time.Duration
+	grace     time.Duration
+	open      map[windowKey]*openCandle
+	watermark time.Time // Último instante passado a Flush
+	late      int64
+}
+
+func NewBuilder(intervals []time.Duration, grace time.Duration) *Builder {
+	return &Builder{
+		intervals: intervals,
+		grace:     grace,
+		open:      make(map[windowKey]*openCandle),
+	}
+}
+
+// Add incorpora uma cotação a todas as janelas que a contêm.
+func (b *Builder) Add(quote model.Quote) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, interval := range b.intervals {
+		start := quote.Timestamp.Truncate(interval)
+		end := start.Add(interval)
+
+		// Janela já finalizada: tick atrasado demais
+		if !b.watermark.IsZero() && !end.Add(b.grace).After(b.watermark) {
+			b.late++
+			continue
+		}
+
+		key := windowKey{symbol: quote.Symbol, interval: interval, start: start.UnixNano()}
+		oc, ok := b.open[key]
+		if !ok {
+			b.open[key] = &openCandle{
+				candle: model.Candle{
+					Symbol:   quote.Symbol,
+					Interval: Label(interval),
+					Start:    start,
+					End:      end,
+					Open:     quote.Price,
+					High:     quote.Price,
+					Low:      quote.Price,
+					Close:    quote.Price,
+					Volume:   1,
+				},
+				openTs:  quote.Timestamp,
+				closeTs: quote.Timestamp,
+			}
+			continue
+		}
+
+		c := &oc.candle
+		if quote.Price > c.High {
+			c.High = quote.Price
+		}
+		if quote.Price < c.Low {
+			c.Low = quote.Price
+		}
+		if quote.Timestamp.Before(oc.openTs) {
+			c.Open = quote.Price
+			oc.openTs = quote.Timestamp
+		}
+		if !quote.Timestamp.Before(oc.closeTs) {
+			c.Close = quote.Price
+			oc.closeTs = quote.Timestamp
+		}
+		c.Volume++
+	}
+}
+
+// Flush finaliza e retorna (em ordem de início) as janelas cujo End + grace já passou.
+func (b *Builder) Flush(now time.Time) []model.Candle {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.After(b.watermark) {
+		b.watermark = now
+	}
+
+	var done []model.Candle
+	for key, oc := range b.open {
+		if !oc.candle.End.Add(b.grace).After(b.watermark) {
+			done = append(done, oc.candle)
+			delete(b.open, key)
+		}
+	}
+	sort.Slice(done, func(i, j int) bool {
+		if !done[i].Start.Equal(done[j].Start) {
+			return done[i].Start.Before(done[j].Start)
+		}
+		if done[i].Interval != done[j].Interval {
+			return done[i].End.Before(done[j].End)
+		}
+		return done[i].Symbol < done[j].Symbol
+	})
+	return done
+}
+
+// Late retorna quantas vezes um tick foi descartado por chegar após a finalização da sua janela.
+func (b *Builder) Late() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.late
+}
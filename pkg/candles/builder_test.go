@@ -0,0 +1,72 @@
+package candles
+
+import (
+	"distributed-system/pkg/model"
+	"testing"
+	"time"
+)
+
+func tick(price float64, ts time.Time) model.Quote {
+	return model.Quote{Symbol: "PETR4", Price: price, Timestamp: ts}
+}
+
+// TestBuilderWindowBoundaries valida OHLCV e o alinhamento das janelas ao relógio
+func TestBuilderWindowBoundaries(t *testing.T) {
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	b := NewBuilder([]time.Duration{time.Minute}, time.Second)
+
+	b.Add(tick(20, base.Add(5*time.Second)))
+	b.Add(tick(23, base.Add(20*time.Second)))
+	b.Add(tick(19, base.Add(40*time.Second)))
+	b.Add(tick(21, base.Add(59*time.Second)))
+	b.Add(tick(30, base.Add(time.Minute))) // Exatamente no limite: pertence à próxima janela
+
+	// Antes de End + grace nada é finalizado
+	if got := b.Flush(base.Add(time.Minute)); len(got) != 0 {
+		t.Fatalf("Nenhum candle deveria estar pronto ainda, recebi %d", len(got))
+	}
+
+	got := b.Flush(base.Add(time.Minute + 2*time.Second))
+	if len(got) != 1 {
+		t.Fatalf("Esperava 1 candle finalizado, recebi %d", len(got))
+	}
+	c := got[0]
+	if c.Interval != "1m" || !c.Start.Equal(base) || !c.End.Equal(base.Add(time.Minute)) {
+		t.Errorf("Janela incorreta: %+v", c)
+	}
+	if c.Open != 20 || c.High != 23 || c.Low != 19 || c.Close != 21 || c.Volume != 4 {
+		t.Errorf("OHLCV incorreto: %+v", c)
+	}
+	if Topic(time.Minute, "PETR4") != "candles.1m.PETR4" {
+		t.Errorf("Tópico incorreto: %s", Topic(time.Minute, "PETR4"))
+	}
+}
+
+// TestBuilderLateTicks valida ticks fora de ordem dentro da tolerância e descarte após a finalização
+func TestBuilderLateTicks(t *testing.T) {
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	b := NewBuilder([]time.Duration{time.Second, 5 * time.Minute}, 500*time.Millisecond)
+
+	// Chegam fora de ordem: Open/Close seguem o timestamp, não a chegada
+	b.Add(tick(22, base.Add(900*time.Millisecond)))
+	b.Add(tick(20, base.Add(100*time.Millisecond)))
+
+	got := b.Flush(base.Add(1600 * time.Millisecond))
+	if len(got) != 1 || got[0].Interval != "1s" {
+		t.Fatalf("Esperava apenas o candle de 1s, recebi %+v", got)
+	}
+	if got[0].Open != 20 || got[0].Close != 22 {
+		t.Errorf("Open/Close deveriam seguir o timestamp: %+v", got[0])
+	}
+
+	// Tick da janela de 1s já finalizada: descartado dela, mas ainda entra no candle de 5m
+	b.Add(tick(50, base.Add(300*time.Millisecond)))
+	if b.Late() != 1 {
+		t.Errorf("Esperava 1 tick atrasado, contei %d", b.Late())
+	}
+
+	got = b.Flush(base.Add(5*time.Minute + time.Second))
+	if len(got) != 1 || got[0].Interval != "5m" || got[0].High != 50 || got[0].Volume != 3 {
+		t.Errorf("Candle de 5m incorreto: %+v", got)
+	}
+}
@@ -15,9 +15,44 @@ const (
 	StateHalfOpen
 )
 
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "CLOSED"
+	case StateOpen:
+		return "OPEN"
+	case StateHalfOpen:
+		return "HALF-OPEN"
+	}
+	return "UNKNOWN"
+}
+
+// Erros retornados quando o breaker rejeita a chamada sem executá-la.
+var (
+	ErrOpen       = errors.New("circuit breaker is OPEN")
+	ErrHalfOpen   = errors.New("circuit breaker is HALF-OPEN (waiting for probe result)")
+	ErrForcedOpen = errors.New("circuit breaker is FORCED OPEN")
+)
+
+// IsRejected indica se o erro veio do próprio breaker (a ação não chegou a rodar).
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrOpen) || errors.Is(err, ErrHalfOpen) || errors.Is(err, ErrForcedOpen)
+}
+
+// override representa uma intervenção manual do operador que ignora a máquina de estados.
+type override int
+
+const (
+	overrideNone override = iota
+	overrideOpen
+	overrideClosed
+)
+
 type CircuitBreaker struct {
 	mu           sync.Mutex
+	name         string
 	state        State
+	override     override
 	failureCount int
 	threshold    int
 	resetTimeout time.Duration
@@ -32,18 +67,36 @@ func NewCircuitBreaker(threshold int, timeout time.Duration) *CircuitBreaker {
 	}
 }
 
+// prefix identifica o breaker nos logs quando ele pertence a um Registry.
+func (cb *CircuitBreaker) prefix() string {
+	if cb.name == "" {
+		return "[CB]"
+	}
+	return fmt.Sprintf("[CB %s]", cb.name)
+}
+
 func (cb *CircuitBreaker) Execute(action func() (interface{}, error)) (interface{}, error) {
 	cb.mu.Lock()
 
+	// Intervenções manuais têm precedência sobre a máquina de estados
+	switch cb.override {
+	case overrideOpen:
+		cb.mu.Unlock()
+		return nil, ErrForcedOpen
+	case overrideClosed:
+		cb.mu.Unlock()
+		return action()
+	}
+
 	// Lógica de verificação de estado
 	if cb.state == StateOpen {
 		if time.Since(cb.lastFailure) > cb.resetTimeout {
 			// Transição para Half-Open (Permite UMA sonda)
-			fmt.Println("[CB] Circuit transitioning to HALF-OPEN (Probing...)")
+			fmt.Println(cb.prefix(), "Circuit transitioning to HALF-OPEN (Probing...)")
 			cb.state = StateHalfOpen
 		} else {
 			cb.mu.Unlock()
-			return nil, errors.New("circuit breaker is OPEN")
+			return nil, ErrOpen
 		}
 	} else if cb.state == StateHalfOpen {
 		// Se já estamos em Half-Open e outra requisição chega, rejeitamos.
@@ -51,7 +104,7 @@ func (cb *CircuitBreaker) Execute(action func() (interface{}, error)) (interface
 		// Nota: Na implementação acima, a goroutine que mudou o estado continua para baixo.
 		// As próximas cairão aqui até o estado mudar.
 		cb.mu.Unlock()
-		return nil, errors.New("circuit breaker is HALF-OPEN (waiting for probe result)")
+		return nil, ErrHalfOpen
 	}
 
 	cb.mu.Unlock()
@@ -62,18 +115,23 @@ func (cb *CircuitBreaker) Execute(action func() (interface{}, error)) (interface
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
+	// O operador pode ter forçado um estado enquanto a ação rodava
+	if cb.override != overrideNone {
+		return result, err
+	}
+
 	if err != nil {
 		// Falha
 		cb.failureCount++
 		cb.lastFailure = time.Now()
-		fmt.Printf("[CB] Failure detected. Count: %d/%d\n", cb.failureCount, cb.threshold)
+		fmt.Printf("%s Failure detected. Count: %d/%d\n", cb.prefix(), cb.failureCount, cb.threshold)
 
 		if cb.state == StateHalfOpen {
 			// Se a sonda falhou, volta para Open imediatamente
-			fmt.Println("[CB] Probe failed. Circuit returning to OPEN.")
+			fmt.Println(cb.prefix(), "Probe failed. Circuit returning to OPEN.")
 			cb.state = StateOpen
 		} else if cb.failureCount >= cb.threshold {
-			fmt.Println("[CB] Failure threshold reached. Circuit OPEN.")
+			fmt.Println(cb.prefix(), "Failure threshold reached. Circuit OPEN.")
 			cb.state = StateOpen
 		}
 		return nil, err
@@ -81,7 +139,7 @@ func (cb *CircuitBreaker) Execute(action func() (interface{}, error)) (interface
 
 	// Sucesso
 	if cb.state == StateHalfOpen {
-		fmt.Println("[CB] Success in Half-Open. Circuit CLOSED.")
+		fmt.Println(cb.prefix(), "Success in Half-Open. Circuit CLOSED.")
 		cb.state = StateClosed
 		cb.failureCount = 0
 	} else if cb.state == StateClosed {
@@ -90,4 +148,68 @@ func (cb *CircuitBreaker) Execute(action func() (interface{}, error)) (interface
 	}
 
 	return result, nil
-}
\ No newline at end of file
+}
+
+// State retorna o estado efetivo do breaker, considerando overrides manuais.
+func (cb *CircuitBreaker) State() State {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.override {
+	case overrideOpen:
+		return StateOpen
+	case overrideClosed:
+		return StateClosed
+	}
+	return cb.state
+}
+
+// ForceOpen tira a dependência de rotação até um ForceClose ou Reset.
+func (cb *CircuitBreaker) ForceOpen() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.override = overrideOpen
+	cb.state = StateOpen
+	fmt.Println(cb.prefix(), "Circuit FORCED OPEN by operator.")
+}
+
+// ForceClose deixa todas as chamadas passarem, ignorando falhas, até um Reset.
+func (cb *CircuitBreaker) ForceClose() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.override = overrideClosed
+	cb.state = StateClosed
+	cb.failureCount = 0
+	fmt.Println(cb.prefix(), "Circuit FORCED CLOSED by operator.")
+}
+
+// Reset remove qualquer override e devolve o breaker ao estado inicial (Closed).
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.override = overrideNone
+	cb.state = StateClosed
+	cb.failureCount = 0
+	cb.lastFailure = time.Time{}
+	fmt.Println(cb.prefix(), "Circuit RESET by operator.")
+}
+
+// Status é a visão serializável de um breaker, usada pelos comandos de administração.
+type Status struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	Threshold int    `json:"threshold"`
+	Forced    bool   `json:"forced,omitempty"`
+}
+
+func (cb *CircuitBreaker) Status() Status {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return Status{
+		Name:      cb.name,
+		State:     cb.state.String(),
+		Failures:  cb.failureCount,
+		Threshold: cb.threshold,
+		Forced:    cb.override != overrideNone,
+	}
+}
@@ -0,0 +1,125 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Settings define os parâmetros de um breaker individual.
+type Settings struct {
+	Threshold    int
+	ResetTimeout time.Duration
+}
+
+// Ações aceitas pelo comando de administração.
+const (
+	ActionList  = "list"
+	ActionOpen  = "open"
+	ActionClose = "close"
+	ActionReset = "reset"
+)
+
+// Registry mantém um breaker por dependência nomeada (ex: "shard:localhost:9001"),
+// criado sob demanda na primeira chamada a Get.
+type Registry struct {
+	mu       sync.Mutex
+	defaults Settings
+	settings map[string]Settings
+	breakers map[string]*CircuitBreaker
+}
+
+func NewRegistry(defaults Settings) *Registry {
+	return &Registry{
+		defaults: defaults,
+		settings: make(map[string]Settings),
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Configure define parâmetros específicos para uma dependência.
+// Se o breaker já existir, os novos limiares passam a valer imediatamente.
+func (r *Registry) Configure(name string, s Settings) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.settings[name] = s
+	if cb, ok := r.breakers[name]; ok {
+		cb.mu.Lock()
+		cb.threshold = s.Threshold
+		cb.resetTimeout = s.ResetTimeout
+		cb.mu.Unlock()
+	}
+}
+
+// Get retorna o breaker da dependência, criando-o com as configurações dela (ou as padrão).
+func (r *Registry) Get(name string) *CircuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cb, ok := r.breakers[name]; ok {
+		return cb
+	}
+	s, ok := r.settings[name]
+	if !ok {
+		s = r.defaults
+	}
+	cb := NewCircuitBreaker(s.Threshold, s.ResetTimeout)
+	cb.name = name
+	r.breakers[name] = cb
+	return cb
+}
+
+// Snapshot lista o estado de todos os breakers conhecidos, ordenados por nome.
+func (r *Registry) Snapshot() []Status {
+	r.mu.Lock()
+	cbs := make([]*CircuitBreaker, 0, len(r.breakers))
+	for _, cb := range r.breakers {
+		cbs = append(cbs, cb)
+	}
+	r.mu.Unlock()
+
+	statuses := make([]Status, 0, len(cbs))
+	for _, cb := range cbs {
+		statuses = append(statuses, cb.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Lookup retorna o breaker da dependência sem criá-lo.
+func (r *Registry) Lookup(name string) (*CircuitBreaker, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cb, ok := r.breakers[name]
+	return cb, ok
+}
+
+// Apply executa um comando de administração e devolve o estado resultante.
+// O comando só vale para breakers já registrados: um nome digitado errado
+// retorna erro em vez de criar um breaker que nenhuma dependência usa.
+func (r *Registry) Apply(action, name string) ([]Status, error) {
+	if action != ActionList && name == "" {
+		return nil, fmt.Errorf("breaker name is required for action %q", action)
+	}
+
+	var force func(cb *CircuitBreaker)
+	switch action {
+	case ActionList:
+		return r.Snapshot(), nil
+	case ActionOpen:
+		force = (*CircuitBreaker).ForceOpen
+	case ActionClose:
+		force = (*CircuitBreaker).ForceClose
+	case ActionReset:
+		force = (*CircuitBreaker).Reset
+	default:
+		return nil, fmt.Errorf("unknown breaker action %q", action)
+	}
+
+	cb, ok := r.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown breaker %q", name)
+	}
+	force(cb)
+	return r.Snapshot(), nil
+}
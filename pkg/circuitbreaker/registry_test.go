@@ -0,0 +1,78 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRegistryLazyPerNameSettings valida que cada dependência recebe seu próprio breaker e configuração
+func TestRegistryLazyPerNameSettings(t *testing.T) {
+	reg := NewRegistry(Settings{Threshold: 5, ResetTimeout: time.Second})
+	reg.Configure("fragile", Settings{Threshold: 1, ResetTimeout: time.Second})
+
+	if reg.Get("shard:a") != reg.Get("shard:a") {
+		t.Fatal("Get deveria retornar sempre o mesmo breaker para o mesmo nome")
+	}
+
+	failAction := func() (interface{}, error) { return nil, errors.New("boom") }
+
+	// Com threshold 1, uma falha já abre o breaker "fragile"
+	reg.Get("fragile").Execute(failAction)
+	if reg.Get("fragile").State() != StateOpen {
+		t.Errorf("Breaker 'fragile' deveria estar Open, está %v", reg.Get("fragile").State())
+	}
+
+	// O breaker padrão (threshold 5) continua fechado após uma falha
+	reg.Get("shard:a").Execute(failAction)
+	if reg.Get("shard:a").State() != StateClosed {
+		t.Errorf("Breaker 'shard:a' deveria continuar Closed, está %v", reg.Get("shard:a").State())
+	}
+}
+
+// TestRegistryAdminOverrides valida os comandos de operador: forçar abertura, forçar fechamento e reset
+func TestRegistryAdminOverrides(t *testing.T) {
+	reg := NewRegistry(Settings{Threshold: 1, ResetTimeout: time.Hour})
+	successAction := func() (interface{}, error) { return "ok", nil }
+	failAction := func() (interface{}, error) { return nil, errors.New("boom") }
+
+	// Só breakers registrados aceitam comandos; um nome desconhecido não cria um novo
+	if _, err := reg.Apply(ActionOpen, "core"); err == nil {
+		t.Error("Esperava erro ao forçar um breaker desconhecido")
+	}
+	if statuses, _ := reg.Apply(ActionList, ""); len(statuses) != 0 {
+		t.Errorf("O comando não deveria criar o breaker, listados: %+v", statuses)
+	}
+
+	// Forçar abertura rejeita chamadas mesmo com a dependência saudável
+	reg.Get("core")
+	if _, err := reg.Apply(ActionOpen, "core"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Get("core").Execute(successAction); !errors.Is(err, ErrForcedOpen) {
+		t.Errorf("Esperava ErrForcedOpen, recebeu %v", err)
+	}
+
+	// Forçar fechamento deixa passar e ignora falhas
+	reg.Apply(ActionClose, "core")
+	for i := 0; i < 3; i++ {
+		reg.Get("core").Execute(failAction)
+	}
+	if _, err := reg.Get("core").Execute(successAction); err != nil {
+		t.Errorf("Breaker forçado fechado não deveria rejeitar, recebeu %v", err)
+	}
+
+	// Reset devolve o controle à máquina de estados
+	statuses, _ := reg.Apply(ActionReset, "core")
+	if len(statuses) != 1 || statuses[0].Forced || statuses[0].State != "CLOSED" {
+		t.Errorf("Status inesperado após reset: %+v", statuses)
+	}
+	reg.Get("core").Execute(failAction)
+	if reg.Get("core").State() != StateOpen {
+		t.Error("Após reset, uma falha com threshold 1 deveria abrir o breaker")
+	}
+
+	if _, err := reg.Apply("explode", "core"); err == nil {
+		t.Error("Ação desconhecida deveria retornar erro")
+	}
+}
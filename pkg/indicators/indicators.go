@@ -0,0 +1,127 @@
+package indicators
+
+import "math"
+
+// SMA é a média móvel simples dos últimos N valores, atualizada em O(1).
+type SMA struct {
+	period int
+	window []float64
+	next   int
+	sum    float64
+}
+
+func NewSMA(period int) *SMA {
+	return &SMA{period: period, window: make([]float64, 0, period)}
+}
+
+// Add incorpora um valor e retorna a média atual.
+func (s *SMA) Add(v float64) float64 {
+	if len(s.window) < s.period {
+		s.window = append(s.window, v)
+	} else {
+		s.sum -= s.window[s.next]
+		s.window[s.next] = v
+		s.next = (s.next + 1) % s.period
+	}
+	s.sum += v
+	return s.Value()
+}
+
+func (s *SMA) Value() float64 {
+	if len(s.window) == 0 {
+		return 0
+	}
+	return s.sum / float64(len(s.window))
+}
+
+// Ready indica se a janela já tem N valores.
+func (s *SMA) Ready() bool {
+	return len(s.window) == s.period
+}
+
+// EMA é a média móvel exponencial com alfa = 2/(N+1). Até completar N valores
+// ela é semeada pela média simples, como é convencional.
+type EMA struct {
+	period int
+	alpha  float64
+	count  int
+	value  float64
+}
+
+func NewEMA(period int) *EMA {
+	return &EMA{period: period, alpha: 2 / float64(period+1)}
+}
+
+func (e *EMA) Add(v float64) float64 {
+	e.count++
+	if e.count <= e.period {
+		// Fase de aquecimento: média simples acumulada
+		e.value += (v - e.value) / float64(e.count)
+	} else {
+		e.value += e.alpha * (v - e.value)
+	}
+	return e.value
+}
+
+func (e *EMA) Value() float64 {
+	return e.value
+}
+
+// Bollinger calcula as bandas de Bollinger (média ± K desvios-padrão) sobre os últimos N valores.
+type Bollinger struct {
+	sma   *SMA
+	k     float64
+	sumSq float64
+}
+
+func NewBollinger(period int, k float64) *Bollinger {
+	return &Bollinger{sma: NewSMA(period), k: k}
+}
+
+// Add incorpora um valor e retorna (inferior, média, superior).
+func (b *Bollinger) Add(v float64) (float64, float64, float64) {
+	if b.sma.Ready() {
+		old := b.sma.window[b.sma.next]
+		b.sumSq -= old * old
+	}
+	b.sumSq += v * v
+	b.sma.Add(v)
+	return b.Bands()
+}
+
+func (b *Bollinger) Bands() (lower, middle, upper float64) {
+	n := float64(len(b.sma.window))
+	if n == 0 {
+		return 0, 0, 0
+	}
+	middle = b.sma.Value()
+	variance := b.sumSq/n - middle*middle
+	if variance < 0 {
+		variance = 0 // Erro de arredondamento com valores quase constantes
+	}
+	std := math.Sqrt(variance)
+	return middle - b.k*std, middle, middle + b.k*std
+}
+
+// VWAP é o preço médio ponderado por volume acumulado.
+type VWAP struct {
+	notional float64
+	volume   float64
+}
+
+func (w *VWAP) Add(price float64, quantity int) float64 {
+	w.notional += price * float64(quantity)
+	w.volume += float64(quantity)
+	return w.Value()
+}
+
+func (w *VWAP) Value() float64 {
+	if w.volume == 0 {
+		return 0
+	}
+	return w.notional / w.volume
+}
+
+func (w *VWAP) Volume() float64 {
+	return w.volume
+}
@@ -0,0 +1,120 @@
+package indicators
+
+import (
+	"distributed-system/pkg/model"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// TestMovingAverages valida SMA e EMA incrementais contra valores calculados à mão
+func TestMovingAverages(t *testing.T) {
+	sma := NewSMA(3)
+	ema := NewEMA(3)
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		sma.Add(v)
+		ema.Add(v)
+	}
+	// SMA(3) de [3 4 5] = 4
+	if !almostEqual(sma.Value(), 4) {
+		t.Errorf("SMA esperado 4, obtido %v", sma.Value())
+	}
+	// EMA semeada com a média de [1 2 3] = 2; alfa = 0.5: 2 -> 3 -> 4
+	if !almostEqual(ema.Value(), 4) {
+		t.Errorf("EMA esperado 4, obtido %v", ema.Value())
+	}
+}
+
+// TestBollingerAndVWAP valida as bandas (desvio-padrão populacional) e o VWAP
+func TestBollingerAndVWAP(t *testing.T) {
+	b := NewBollinger(4, 2)
+	for _, v := range []float64{100, 2, 4, 4, 6} { // O 100 sai da janela
+		b.Add(v)
+	}
+	// Janela [2 4 4 6]: média 4, desvio sqrt(2)
+	lower, mid, upper := b.Bands()
+	if !almostEqual(mid, 4) || !almostEqual(upper-mid, 2*math.Sqrt2) || !almostEqual(mid-lower, 2*math.Sqrt2) {
+		t.Errorf("Bandas incorretas: %v %v %v", lower, mid, upper)
+	}
+
+	set := NewSet(4, 2)
+	base := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	set.now = func() time.Time { return base.Add(time.Hour) }
+	txs := []model.Transaction{
+		{ID: "a", Symbol: "PETR4", Price: 10, Quantity: 100, Timestamp: base},
+		{ID: "b", Symbol: "PETR4", Price: 20, Quantity: 300, Timestamp: base.Add(time.Minute)},
+	}
+	set.AddTransactions(txs)
+	// Reenviar o mesmo histórico não pode contar em dobro
+	if changed := set.AddTransactions(txs); len(changed) != 0 {
+		t.Errorf("Transações repetidas não deveriam alterar o VWAP: %v", changed)
+	}
+	snap, _ := set.Snapshot("PETR4")
+	if !almostEqual(snap.VWAP, 17.5) || snap.VWAPVolume != 400 {
+		t.Errorf("VWAP esperado 17.5 com volume 400, obtido %+v", snap)
+	}
+}
+
+// TestVWAPDoesNotDependOnArrivalOrder valida que o mesmo conjunto de
+// transações dá o mesmo VWAP chegando do mais novo para o mais antigo (a
+// ordem de gravação da carga inicial) ou ao contrário, e que só conta a sessão
+// corrente
+func TestVWAPDoesNotDependOnArrivalOrder(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 0, 0, 0, time.UTC)
+	var txs []model.Transaction
+	for i := 0; i < 10; i++ {
+		txs = append(txs, model.Transaction{ID: fmt.Sprintf("tx-%d", i), Symbol: "PETR4", Price: 20 + float64(i), Quantity: 100 * (i + 1), Timestamp: now.Add(-time.Duration(i) * time.Hour)})
+	}
+	yesterday := model.Transaction{ID: "old", Symbol: "PETR4", Price: 99, Quantity: 1000, Timestamp: now.Add(-16 * time.Hour)}
+
+	vwapOf := func(txs []model.Transaction) model.Indicators {
+		set := NewSet(4, 2)
+		set.now = func() time.Time { return now }
+		set.AddTransactions(txs)
+		snap, _ := set.Snapshot("PETR4")
+		return snap
+	}
+	newestFirst := vwapOf(append(append([]model.Transaction{}, txs...), yesterday))
+	var oldestFirst []model.Transaction
+	oldestFirst = append(oldestFirst, yesterday)
+	for i := len(txs) - 1; i >= 0; i-- {
+		oldestFirst = append(oldestFirst, txs[i])
+	}
+	reversed := vwapOf(oldestFirst)
+
+	// 10 transações de hoje: volume 5500, notional sum((20+i)*100*(i+1)) = 143000
+	if newestFirst.VWAPVolume != 5500 || !almostEqual(newestFirst.VWAP, 143000.0/5500) {
+		t.Errorf("Esperava VWAP %.2f com volume 5500, obtido %+v", 143000.0/5500, newestFirst)
+	}
+	if reversed.VWAP != newestFirst.VWAP || reversed.VWAPVolume != newestFirst.VWAPVolume {
+		t.Errorf("Esperava o mesmo VWAP nas duas ordens, obtido %v/%d e %v/%d", newestFirst.VWAP, newestFirst.VWAPVolume, reversed.VWAP, reversed.VWAPVolume)
+	}
+}
+
+// TestVWAPSeenIsBounded valida que os IDs vistos recomeçam na virada da
+// sessão, mesmo com o histórico inteiro reenviado a cada leitura dos shards
+func TestVWAPSeenIsBounded(t *testing.T) {
+	set := NewSet(4, 2)
+	base := time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC)
+	now := base
+	set.now = func() time.Time { return now }
+	var history []model.Transaction
+	for i := 0; i < 10; i++ {
+		// Uma transação a cada hora, das 20h às 5h; o histórico cresce a cada leitura
+		now = base.Add(time.Duration(i) * time.Hour)
+		history = append(history, model.Transaction{ID: fmt.Sprintf("tx-%d", i), Symbol: "PETR4", Price: 10, Quantity: 1, Timestamp: now})
+		set.AddTransactions(history)
+	}
+	snap, _ := set.Snapshot("PETR4")
+	if snap.VWAPVolume != 6 {
+		t.Errorf("Esperava só as 6 transações da sessão nova, uma vez cada, volume %d", snap.VWAPVolume)
+	}
+	if n := len(set.symbols["PETR4"].vwapSeen); n != 6 {
+		t.Errorf("Esperava só os IDs da sessão corrente, há %d", n)
+	}
+}
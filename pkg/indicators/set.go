@@ -0,0 +1,140 @@
+package indicators
+
+import (
+	"distributed-system/pkg/model"
+	"sync"
+	"time"
+)
+
+// vwapSession é a sessão do VWAP: as transações do dia corrente (UTC) pelo
+// relógio, não pela transação mais recente recebida, então o resultado não
+// depende da ordem em que os shards as devolvem. Na virada da sessão o VWAP
+// e os IDs vistos recomeçam, o que mantém o conjunto limitado.
+const vwapSession = 24 * time.Hour
+
+// Set mantém os indicadores de todos os símbolos acompanhados.
+type Set struct {
+	mu      sync.Mutex
+	period  int
+	k       float64
+	symbols map[string]*symbolState
+	now     func() time.Time // Relógio da sessão do VWAP
+}
+
+type symbolState struct {
+	sma       *SMA
+	ema       *EMA
+	bollinger *Bollinger
+	vwap      VWAP
+	vwapFrom  time.Time       // Início da sessão do VWAP
+	vwapSeen  map[string]bool // IDs de transações da sessão já incluídas no VWAP
+	samples   int
+	last      model.Quote
+}
+
+func NewSet(period int, k float64) *Set {
+	return &Set{period: period, k: k, symbols: make(map[string]*symbolState), now: time.Now}
+}
+
+func (s *Set) state(symbol string) *symbolState {
+	st, ok := s.symbols[symbol]
+	if !ok {
+		st = &symbolState{
+			sma:       NewSMA(s.period),
+			ema:       NewEMA(s.period),
+			bollinger: NewBollinger(s.period, s.k),
+			vwapSeen:  make(map[string]bool),
+		}
+		s.symbols[symbol] = st
+	}
+	return st
+}
+
+// AddQuote atualiza SMA, EMA e Bollinger do símbolo com uma nova cotação.
+func (s *Set) AddQuote(quote model.Quote) model.Indicators {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.state(quote.Symbol)
+	st.sma.Add(quote.Price)
+	st.ema.Add(quote.Price)
+	st.bollinger.Add(quote.Price)
+	st.samples++
+	st.last = quote
+	return s.snapshot(quote.Symbol, st)
+}
+
+// AddTransactions incorpora ao VWAP as transações da sessão corrente ainda
+// não vistas. Retorna os símbolos cujo VWAP mudou. Transações de outras
+// sessões são ignoradas.
+func (s *Set) AddTransactions(txs []model.Transaction) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := s.session()
+	changed := make(map[string]bool)
+	for _, tx := range txs {
+		st := s.state(tx.Symbol)
+		st.roll(from)
+		if st.vwapSeen[tx.ID] || tx.Timestamp.Before(from) || !tx.Timestamp.Before(from.Add(vwapSession)) {
+			continue
+		}
+		st.vwapSeen[tx.ID] = true
+		st.vwap.Add(tx.Price, tx.Quantity)
+		changed[tx.Symbol] = true
+	}
+	symbols := make([]string, 0, len(changed))
+	for symbol := range changed {
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// VWAPSession devolve o início da sessão corrente do VWAP: transações
+// anteriores não entram nele.
+func (s *Set) VWAPSession() time.Time {
+	return s.session()
+}
+
+// session devolve o início da sessão corrente do VWAP.
+func (s *Set) session() time.Time {
+	return s.now().UTC().Truncate(vwapSession)
+}
+
+// roll recomeça o VWAP quando a sessão from começou depois da dele.
+func (st *symbolState) roll(from time.Time) {
+	if st.vwapFrom.Equal(from) {
+		return
+	}
+	st.vwap = VWAP{}
+	st.vwapFrom = from
+	st.vwapSeen = make(map[string]bool)
+}
+
+// Snapshot retorna os indicadores atuais do símbolo.
+func (s *Set) Snapshot(symbol string) (model.Indicators, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.symbols[symbol]
+	if !ok {
+		return model.Indicators{}, false
+	}
+	return s.snapshot(symbol, st), true
+}
+
+func (s *Set) snapshot(symbol string, st *symbolState) model.Indicators {
+	st.roll(s.session())
+	lower, middle, upper := st.bollinger.Bands()
+	return model.Indicators{
+		Symbol:         symbol,
+		Timestamp:      time.Now(),
+		Period:         s.period,
+		Samples:        st.samples,
+		LastPrice:      st.last.Price,
+		SMA:            st.sma.Value(),
+		EMA:            st.ema.Value(),
+		BollingerLower: lower,
+		BollingerMid:   middle,
+		BollingerUpper: upper,
+		VWAP:           st.vwap.Value(),
+		VWAPVolume:     int64(st.vwap.Volume()),
+	}
+}
@@ -0,0 +1,161 @@
+package merkle
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// DefaultDepth divide o espaço de IDs em 2^10 = 1024 faixas.
+const DefaultDepth = 10
+
+// Tree é uma árvore de Merkle sobre faixas do hash do ID das transações.
+//
+// Cada folha resume uma faixa: a soma dos hashes de (ID, versão) das
+// transações que caem nela e das remoções (tombstones) dos IDs dela. A soma não depende da ordem e permite tirar um
+// item, então a árvore é atualizada a cada gravação em O(profundidade).
+// Dois nós com o mesmo conteúdo têm a mesma raiz; quando as raízes diferem,
+// descer pelos filhos que diferem leva às faixas divergentes.
+type Tree struct {
+	mu    sync.RWMutex
+	depth int
+	nodes []uint64 // Heap: raiz em 1, filhos de i em 2i e 2i+1, folhas a partir de 1<<depth
+}
+
+func New(depth int) *Tree {
+	if depth <= 0 {
+		depth = DefaultDepth
+	}
+	return &Tree{depth: depth, nodes: make([]uint64, 2<<depth)}
+}
+
+// Depth devolve a profundidade (as folhas estão no nível Depth, a raiz no 0).
+func (t *Tree) Depth() int {
+	return t.depth
+}
+
+// Bucket devolve a faixa (folha) do ID.
+func (t *Tree) Bucket(id string) int {
+	return int(hash(id) >> (64 - t.depth))
+}
+
+// Add inclui a versão da transação na árvore.
+func (t *Tree) Add(id string, version int64) {
+	t.update(id, itemHash(id, version))
+}
+
+// Remove tira a versão da transação da árvore (substituída ou removida).
+func (t *Tree) Remove(id string, version int64) {
+	t.update(id, -itemHash(id, version))
+}
+
+// AddDeleted inclui o tombstone do ID: a remoção das versões até version.
+// Ele cai na mesma faixa do ID, então um par que ainda tem a transação diverge
+// nessa faixa.
+func (t *Tree) AddDeleted(id string, version int64) {
+	t.update(id, deletedHash(id, version))
+}
+
+// RemoveDeleted tira o tombstone do ID (substituído ou recriado).
+func (t *Tree) RemoveDeleted(id string, version int64) {
+	t.update(id, -deletedHash(id, version))
+}
+
+func (t *Tree) update(id string, delta uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := 1<<t.depth + t.Bucket(id)
+	t.nodes[i] += delta
+	for i > 1 {
+		i /= 2
+		t.nodes[i] = combine(t.nodes[2*i], t.nodes[2*i+1])
+	}
+}
+
+// Root devolve o hash da raiz.
+func (t *Tree) Root() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nodes[1]
+}
+
+// Hashes devolve os hashes dos nós indexes (0 .. 2^level-1) do nível level.
+func (t *Tree) Hashes(level int, indexes []int) ([]uint64, error) {
+	if level < 0 || level > t.depth {
+		return nil, fmt.Errorf("level %d out of range 0..%d", level, t.depth)
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	hashes := make([]uint64, len(indexes))
+	for k, i := range indexes {
+		if i < 0 || i >= 1<<level {
+			return nil, fmt.Errorf("node %d out of range at level %d", i, level)
+		}
+		hashes[k] = t.nodes[1<<level+i]
+	}
+	return hashes, nil
+}
+
+// Diff compara a árvore com a de outro nó, obtida nível a nível por remote, e
+// devolve as faixas divergentes. Só os filhos de nós que diferem são pedidos,
+// então árvores quase iguais trocam poucos hashes.
+func Diff(t *Tree, remote func(level int, indexes []int) ([]uint64, error)) ([]int, error) {
+	divergent := []int{0}
+	for level := 0; level <= t.depth && len(divergent) > 0; level++ {
+		if level > 0 {
+			children := make([]int, 0, 2*len(divergent))
+			for _, i := range divergent {
+				children = append(children, 2*i, 2*i+1)
+			}
+			divergent = children
+		}
+		theirs, err := remote(level, divergent)
+		if err != nil {
+			return nil, err
+		}
+		if len(theirs) != len(divergent) {
+			return nil, fmt.Errorf("remote returned %d hashes for %d nodes", len(theirs), len(divergent))
+		}
+		ours, err := t.Hashes(level, divergent)
+		if err != nil {
+			return nil, err
+		}
+		var next []int
+		for k, i := range divergent {
+			if ours[k] != theirs[k] {
+				next = append(next, i)
+			}
+		}
+		divergent = next
+	}
+	return divergent, nil
+}
+
+func itemHash(id string, version int64) uint64 {
+	return hash(id + "@" + strconv.FormatInt(version, 10))
+}
+
+func deletedHash(id string, version int64) uint64 {
+	return hash(id + "!" + strconv.FormatInt(version, 10))
+}
+
+func combine(left, right uint64) uint64 {
+	return mix(left*0x9e3779b97f4a7c15 ^ right)
+}
+
+// hash aplica FNV-1a seguido do finalizador do SplitMix64 (o mesmo do ring).
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return mix(h.Sum64())
+}
+
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,94 @@
+package merkle
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestRootIgnoresOrderAndTracksRemovals valida que a raiz só depende do conteúdo
+func TestRootIgnoresOrderAndTracksRemovals(t *testing.T) {
+	a, b := New(8), New(8)
+	for i := 0; i < 500; i++ {
+		a.Add(fmt.Sprintf("tx-%d", i), 1)
+	}
+	for i := 499; i >= 0; i-- {
+		b.Add(fmt.Sprintf("tx-%d", i), 1)
+	}
+	if a.Root() != b.Root() || a.Root() == 0 {
+		t.Fatalf("Mesmo conteúdo em ordens diferentes deveria ter a mesma raiz: %x %x", a.Root(), b.Root())
+	}
+
+	// Nova versão de uma transação muda a raiz; voltar à versão anterior restaura
+	root := a.Root()
+	a.Remove("tx-7", 1)
+	a.Add("tx-7", 2)
+	if a.Root() == root {
+		t.Error("Versão nova deveria mudar a raiz")
+	}
+	a.Remove("tx-7", 2)
+	a.Add("tx-7", 1)
+	if a.Root() != root {
+		t.Error("Voltar à versão anterior deveria restaurar a raiz")
+	}
+
+	// O tombstone de uma transação difere da transação, e os dois lados convergem ao removê-la
+	c := New(8)
+	c.Add("tx-7", 1)
+	d := New(8)
+	d.AddDeleted("tx-7", 1)
+	if c.Root() == d.Root() {
+		t.Error("Tombstone deveria diferir da transação viva")
+	}
+	c.Remove("tx-7", 1)
+	c.AddDeleted("tx-7", 1)
+	if c.Root() != d.Root() {
+		t.Error("Remover dos dois lados deveria dar a mesma raiz")
+	}
+
+	empty := New(8)
+	for i := 0; i < 500; i++ {
+		b.Remove(fmt.Sprintf("tx-%d", i), 1)
+	}
+	if b.Root() != empty.Root() {
+		t.Error("Remover tudo deveria deixar a raiz de uma árvore vazia")
+	}
+}
+
+// TestDiffFindsOnlyDivergentBuckets valida a descida pela árvore
+func TestDiffFindsOnlyDivergentBuckets(t *testing.T) {
+	a, b := New(DefaultDepth), New(DefaultDepth)
+	for i := 0; i < 5000; i++ {
+		id := fmt.Sprintf("tx-%d", i)
+		a.Add(id, 1)
+		b.Add(id, 1)
+	}
+	b.Add("only-b", 1)
+	a.Remove("tx-42", 1)
+	a.Add("tx-42", 3)
+
+	requested := 0
+	remote := func(level int, indexes []int) ([]uint64, error) {
+		requested += len(indexes)
+		return b.Hashes(level, indexes)
+	}
+	buckets, err := Diff(a, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]bool{a.Bucket("only-b"): true, a.Bucket("tx-42"): true}
+	if len(buckets) != len(want) {
+		t.Fatalf("Esperava as faixas %v, recebeu %v", want, buckets)
+	}
+	for _, bucket := range buckets {
+		if !want[bucket] {
+			t.Errorf("Faixa %d não deveria divergir", bucket)
+		}
+	}
+	if requested > 4*DefaultDepth+1 {
+		t.Errorf("Descida pediu %d hashes, esperava no máximo %d", requested, 4*DefaultDepth+1)
+	}
+
+	if buckets, _ := Diff(a, a.Hashes); len(buckets) != 0 {
+		t.Errorf("Árvore comparada consigo mesma não deveria divergir: %v", buckets)
+	}
+}
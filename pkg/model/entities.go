@@ -6,6 +6,18 @@ type Quote struct {
 	Symbol    string    `json:"symbol"`
 	Price     float64   `json:"price"`
 	Timestamp time.Time `json:"timestamp"`
+	Provider  string    `json:"provider,omitempty"` // Provedor externo que originou a cotação
+	// Stale indica que a cotação veio do cache do Core porque a fonte externa falhou.
+	Stale bool  `json:"stale,omitempty"`
+	AgeMs int64 `json:"age_ms,omitempty"` // Idade da cotação servida do cache
+}
+
+// QuarantinedQuote é publicada no tópico "quarantine.<SYMBOL>" quando o Core
+// rejeita uma cotação externa na validação.
+type QuarantinedQuote struct {
+	Quote      Quote     `json:"quote"`
+	Reason     string    `json:"reason"`
+	RejectedAt time.Time `json:"rejected_at"`
 }
 
 type Transaction struct {
@@ -14,4 +26,88 @@ type Transaction struct {
 	Price     float64   `json:"price"`
 	Quantity  int       `json:"quantity"`
 	Timestamp time.Time `json:"timestamp"`
+	// Version resolve conflitos entre réplicas (ver Supersedes).
+	Version int64 `json:"version,omitempty"`
+	// IdempotencyKey identifica a escrita que originou a transação: o shard
+	// grava uma única transação por chave e responde às repetições com ela.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// Supersedes informa se tx substitui other, outra versão do mesmo ID. É a
+// regra única de conflito das réplicas (gravação, reparo, anti-entropia e
+// merges): a maior versão prevalece, exceto nas transações com chave de
+// idempotência, em que a escrita mais antiga (menor versão) prevalece, já que
+// é ela que o shard confirma às repetições.
+func Supersedes(tx, other Transaction) bool {
+	if tx.IdempotencyKey != "" && other.IdempotencyKey != "" {
+		return tx.Version < other.Version
+	}
+	return tx.Version > other.Version
+}
+
+// Candle é uma barra OHLCV de um símbolo em uma janela [Start, End).
+// Cotações não trazem quantidade negociada, então Volume conta os ticks da janela.
+type Candle struct {
+	Symbol   string    `json:"symbol"`
+	Interval string    `json:"interval"` // Ex: "1s", "1m", "5m"
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Open     float64   `json:"open"`
+	High     float64   `json:"high"`
+	Low      float64   `json:"low"`
+	Close    float64   `json:"close"`
+	Volume   int64     `json:"volume"`
+}
+
+// Indicators é o estado dos indicadores técnicos de um símbolo.
+// SMA, EMA e Bollinger são calculados sobre as últimas Period cotações;
+// VWAP usa as transações registradas nos shards.
+type Indicators struct {
+	Symbol         string    `json:"symbol"`
+	Timestamp      time.Time `json:"timestamp"`
+	Period         int       `json:"period"`
+	Samples        int       `json:"samples"`
+	LastPrice      float64   `json:"last_price"`
+	SMA            float64   `json:"sma"`
+	EMA            float64   `json:"ema"`
+	BollingerLower float64   `json:"bollinger_lower"`
+	BollingerMid   float64   `json:"bollinger_mid"`
+	BollingerUpper float64   `json:"bollinger_upper"`
+	VWAP           float64   `json:"vwap"`
+	VWAPVolume     int64     `json:"vwap_volume"`
+}
+
+// Tipos de regra de alerta
+const (
+	AlertCrossAbove = "cross_above" // Preço cruza o limiar para cima
+	AlertCrossBelow = "cross_below" // Preço cruza o limiar para baixo
+	AlertCross      = "cross"       // Preço cruza o limiar em qualquer direção
+	AlertMove       = "move"        // Variação de MovePct% dentro de WindowSeconds
+)
+
+// AlertRule é uma regra de alerta registrada por um usuário.
+type AlertRule struct {
+	ID        string  `json:"id"`
+	User      string  `json:"user"`
+	Symbol    string  `json:"symbol"`
+	Kind      string  `json:"kind"`
+	Threshold float64 `json:"threshold,omitempty"` // Regras de cruzamento
+	// Hysteresis é a distância (em preço) que a cotação precisa se afastar do
+	// limiar, do lado oposto, para a regra poder disparar de novo.
+	Hysteresis      float64   `json:"hysteresis,omitempty"`
+	MovePct         float64   `json:"move_pct,omitempty"`       // Regras de variação
+	WindowSeconds   int       `json:"window_seconds,omitempty"` // Regras de variação
+	CooldownSeconds int       `json:"cooldown_seconds,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Alert é um disparo de regra, entregue no tópico "alerts.<user>".
+type Alert struct {
+	RuleID      string    `json:"rule_id"`
+	User        string    `json:"user"`
+	Symbol      string    `json:"symbol"`
+	Kind        string    `json:"kind"`
+	Price       float64   `json:"price"`
+	Message     string    `json:"message"`
+	TriggeredAt time.Time `json:"triggered_at"`
 }
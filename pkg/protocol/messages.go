@@ -3,6 +3,7 @@ package protocol
 import (
 	"encoding/json"
 	"net"
+	"time"
 )
 
 // Tipos de Mensagem
@@ -14,6 +15,43 @@ const (
 	MsgRespQuote    = "RESP_QUOTE"
 	MsgRespHistory  = "RESP_HIST"
 	MsgError        = "ERROR"
+
+	MsgReqReport    = "REQ_REPORT"
+	MsgAdminBreaker = "ADMIN_BREAKER"
+	MsgRespAdmin    = "RESP_ADMIN"
+	MsgReqMetrics   = "REQ_METRICS"
+	MsgRespMetrics  = "RESP_METRICS"
+
+	MsgReqIndicators  = "REQ_INDICATORS"
+	MsgRespIndicators = "RESP_INDICATORS"
+
+	MsgAlertRegister = "ALERT_REGISTER"
+	MsgAlertList     = "ALERT_LIST"
+	MsgAlertDelete   = "ALERT_DELETE"
+	MsgRespAlerts    = "RESP_ALERTS"
+
+	MsgSubmitTrade = "SUBMIT_TRADE" // Cliente -> Core
+	MsgTradeAck    = "TRADE_ACK"    // Core -> Cliente
+	MsgStoreTx     = "STORE_TX"     // Core -> Shard
+	MsgStoreBatch  = "STORE_BATCH"  // Importação -> Shard: lote de transações (resposta MsgRespStore com BatchResult)
+	MsgRespStore   = "RESP_STORE"   // Shard -> Core
+
+	MsgReplicate  = "REPLICATE"   // Réplica -> Primário: inicia o envio do log a partir de um LSN
+	MsgReplBatch  = "REPL_BATCH"  // Primário -> Réplica: entradas do log (ou heartbeat vazio)
+	MsgReplAck    = "REPL_ACK"    // Réplica -> Primário: último LSN aplicado
+	MsgReplResync = "REPL_RESYNC" // Primário -> Réplica: a réplica está à frente do log do primário e precisa recomeçar do LSN 0
+
+	MsgAdminTopology = "ADMIN_TOPOLOGY" // Operador -> Core/Aggregator: consulta ou muda a topologia dos shards
+	MsgReqLog        = "REQ_LOG"        // Migração -> Shard: um lote do log a partir de um LSN (resposta MsgReplBatch)
+	MsgDeleteTx      = "DELETE_TX"      // Migração -> Shard: remove uma transação que mudou de dono
+	MsgAdminBackup   = "ADMIN_BACKUP"   // Operador -> Shard: backup online em um diretório do shard (resposta MsgRespAdmin)
+
+	MsgReqMerkle  = "REQ_MERKLE"  // Shard -> Shard: hashes de um nível da árvore de Merkle
+	MsgRespMerkle = "RESP_MERKLE" // Shard -> Shard
+	MsgReqRange   = "REQ_RANGE"   // Shard -> Shard: transações das faixas divergentes (resposta MsgRespHistory)
+
+	MsgReqAggregate  = "REQ_AGGREGATE"  // Cliente -> Aggregator -> Shard: agregados por símbolo e faixa de tempo
+	MsgRespAggregate = "RESP_AGGREGATE" // Shard -> Aggregator: parciais; Aggregator -> Cliente: resultado global
 )
 
 type Message struct {
@@ -22,6 +60,124 @@ type Message struct {
 	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
+// QuoteRequest é o payload (opcional) de MsgRequestQuote.
+type QuoteRequest struct {
+	Symbol string `json:"symbol"`
+}
+
+// ReportRequest é o payload (opcional) de MsgReqReport ao Aggregator.
+// Com Symbol preenchido, o relatório cobre apenas aquele símbolo; From/To
+// restringem o período e Limit o número de transações do relatório, somando
+// todos os shards. Order ("asc" ou "desc", padrão desc) e Cursor (o
+// NextCursor do relatório anterior) paginam o histórico do cluster inteiro.
+//
+// R (modo quorum) sobrescreve o número de réplicas que precisam responder.
+type ReportRequest struct {
+	Symbol string    `json:"symbol,omitempty"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Limit  int       `json:"limit,omitempty"`
+	Order  string    `json:"order,omitempty"`
+	Cursor string    `json:"cursor,omitempty"`
+	R      int       `json:"r,omitempty"`
+}
+
+// IndicatorRequest é o payload de MsgReqIndicators.
+type IndicatorRequest struct {
+	Symbol string `json:"symbol"`
+}
+
+// AlertQuery é o payload de MsgAlertList e MsgAlertDelete.
+type AlertQuery struct {
+	User string `json:"user"`
+	ID   string `json:"id,omitempty"`
+}
+
+// TradeRequest é o payload de MsgSubmitTrade. ID e Timestamp são atribuídos pelo Core.
+// W (modo quorum) sobrescreve o número de réplicas que precisam confirmar.
+// Com IdempotencyKey, reenviar a mesma operação devolve a transação gravada
+// na primeira vez em vez de gravar outra.
+type TradeRequest struct {
+	Symbol         string  `json:"symbol"`
+	Price          float64 `json:"price"`
+	Quantity       int     `json:"quantity"`
+	W              int     `json:"w,omitempty"`
+	IdempotencyKey string  `json:"idempotency_key,omitempty"`
+}
+
+// BatchResult é a resposta a MsgStoreBatch. Unchanged conta as transações
+// que já estavam gravadas com a mesma versão (ou mais nova).
+type BatchResult struct {
+	Applied   int `json:"applied"`
+	Unchanged int `json:"unchanged"`
+}
+
+// ReplicateRequest é o payload de MsgReplicate.
+type ReplicateRequest struct {
+	Replica string `json:"replica"`  // ID da réplica (apenas para métricas e logs)
+	FromLSN uint64 `json:"from_lsn"` // Último LSN que a réplica já tem
+}
+
+// ReplicationAck é o payload de MsgReplAck.
+type ReplicationAck struct {
+	LSN uint64 `json:"lsn"`
+}
+
+// LogRequest é o payload de MsgReqLog.
+type LogRequest struct {
+	FromLSN uint64 `json:"from_lsn"`
+	Max     int    `json:"max,omitempty"`
+}
+
+// DeleteRequest é o payload de MsgDeleteTx. Com Tombstone, é uma remoção
+// vinda da anti-entropia: remove as versões até Version e fica registrada
+// mesmo que o ID não exista no destino, com o instante DeletedAt da remoção
+// original.
+type DeleteRequest struct {
+	ID        string    `json:"id"`
+	Tombstone bool      `json:"tombstone,omitempty"`
+	Version   int64     `json:"version,omitempty"`
+	DeletedAt time.Time `json:"deleted_at,omitempty"`
+}
+
+// MerkleRequest é o payload de MsgReqMerkle: os nós Nodes do nível Level (0 = raiz).
+type MerkleRequest struct {
+	Level int   `json:"level"`
+	Nodes []int `json:"nodes"`
+}
+
+// MerkleResponse é o payload de MsgRespMerkle.
+type MerkleResponse struct {
+	Depth  int      `json:"depth"`
+	Hashes []uint64 `json:"hashes"`
+}
+
+// RangeRequest é o payload de MsgReqRange: faixas (folhas da árvore de Merkle).
+type RangeRequest struct {
+	Buckets []int `json:"buckets"`
+}
+
+// TopologyCommand é o payload de MsgAdminTopology.
+// Action: "show", "begin" (inicia a migração para Shards) ou "commit"
+// (Shards passa a ser a única topologia). Shards usa o formato do -shards.
+type TopologyCommand struct {
+	Action string `json:"action"`
+	Shards string `json:"shards,omitempty"`
+}
+
+// BackupRequest é o payload de MsgAdminBackup. Dir é um caminho na máquina
+// do shard e precisa ser novo ou vazio.
+type BackupRequest struct {
+	Dir string `json:"dir"`
+}
+
+// BreakerCommand é o payload de MsgAdminBreaker.
+// Action: "list", "open" (forçar aberto), "close" (forçar fechado) ou "reset".
+type BreakerCommand struct {
+	Action string `json:"action"`
+	Name   string `json:"name,omitempty"`
+}
+
 func NewMessage(msgType string, data interface{}) Message {
 	payload, _ := json.Marshal(data)
 	return Message{Type: msgType, Payload: payload}
@@ -0,0 +1,184 @@
+package pubsub
+
+import (
+	"distributed-system/pkg/protocol"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout  = 500 * time.Millisecond
+	writeTimeout = 2 * time.Second
+)
+
+// Publisher gerencia a conexão com o Broker Pub/Sub de forma segura.
+type Publisher struct {
+	addr string
+	conn net.Conn
+	mu   sync.Mutex // Protege o acesso à conexão (Escritas Atômicas & Reconexão)
+}
+
+func NewPublisher(addr string) *Publisher {
+	return &Publisher{
+		addr: addr,
+	}
+}
+
+// Publish envia uma mensagem para o broker, conectando se necessário.
+// Em caso de erro a conexão é descartada; reenviar (com backoff) é papel do chamador.
+func (p *Publisher) Publish(topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// 1. Garantir Conexão
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.addr, dialTimeout)
+		if err != nil {
+			return fmt.Errorf("broker offline: %v", err)
+		}
+		p.conn = conn
+	}
+
+	// 2. Preparar Mensagem dentro do bloqueio para garantir sequência
+	msg := protocol.Message{
+		Type:    protocol.MsgPublish,
+		Topic:   topic,
+		Payload: payload,
+	}
+
+	// 3. Tentar Enviar (com prazo, para um Broker lento não travar o publicador)
+	p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := protocol.SendJSON(p.conn, msg); err != nil {
+		// 4. Forçar reconexão completa na próxima tentativa
+		p.conn.Close()
+		p.conn = nil
+		return fmt.Errorf("publish failed: %v", err)
+	}
+
+	return nil
+}
+
+// Close encerra a conexão atual, se houver.
+func (p *Publisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// Subscriber mantém uma assinatura de tópicos no Broker, reconectando (com backoff)
+// e reassinando sempre que a conexão cai.
+type Subscriber struct {
+	addr    string
+	topics  []string
+	handler func(protocol.Message)
+
+	mu   sync.Mutex
+	conn net.Conn
+	stop chan struct{}
+}
+
+func NewSubscriber(addr string, topics []string, handler func(protocol.Message)) *Subscriber {
+	return &Subscriber{
+		addr:    addr,
+		topics:  topics,
+		handler: handler,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Run bloqueia entregando as mensagens publicadas ao handler até Stop ser chamado.
+func (s *Subscriber) Run() {
+	backoff := 100 * time.Millisecond
+	for {
+		err := s.session()
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		fmt.Printf("[PubSub] Subscription to %s lost (%v). Reconnecting in %v...\n", s.addr, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			return
+		}
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
+
+// Subscribe adiciona um tópico à assinatura em andamento (e às futuras reconexões).
+func (s *Subscriber) Subscribe(topic string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.topics {
+		if t == topic {
+			return nil
+		}
+	}
+	s.topics = append(s.topics, topic)
+	if s.conn == nil {
+		return nil // Será assinado ao conectar
+	}
+	return sendSubscribe(s.conn, topic)
+}
+
+// Stop encerra a assinatura e faz Run retornar.
+func (s *Subscriber) Stop() {
+	close(s.stop)
+	s.mu.Lock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+// session conecta, assina todos os tópicos e consome mensagens até a conexão cair.
+func (s *Subscriber) session() error {
+	conn, err := net.DialTimeout("tcp", s.addr, dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.conn = conn
+	for _, topic := range s.topics {
+		if err := sendSubscribe(conn, topic); err != nil {
+			s.conn = nil
+			s.mu.Unlock()
+			return err
+		}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+
+	// Um único decoder para a sessão inteira: o Broker pode entregar várias mensagens de uma vez
+	decoder := json.NewDecoder(conn)
+	for {
+		var msg protocol.Message
+		if err := decoder.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Type == protocol.MsgPublish {
+			s.handler(msg)
+		}
+	}
+}
+
+func sendSubscribe(conn net.Conn, topic string) error {
+	sub := protocol.NewMessage(protocol.MsgSubscribe, nil)
+	sub.Topic = topic
+	return protocol.SendJSON(conn, sub)
+}
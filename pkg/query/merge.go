@@ -0,0 +1,149 @@
+package query
+
+import (
+	"container/heap"
+	"distributed-system/pkg/model"
+	"sync"
+)
+
+// Source devolve uma página de uma fonte (um shard) a partir do cursor.
+type Source func(cursor string) (Page, error)
+
+// stream é a página corrente de uma fonte durante o merge.
+type stream struct {
+	source Source
+	buf    []model.Transaction
+	pos    int
+	cursor string // Cursor da próxima página ("" = fonte esgotada)
+}
+
+func (s *stream) head() model.Transaction {
+	return s.buf[s.pos]
+}
+
+// fill lê a próxima página da fonte.
+func (s *stream) fill() error {
+	page, err := s.source(s.cursor)
+	if err != nil {
+		return err
+	}
+	s.buf, s.pos, s.cursor = page.Transactions, 0, page.NextCursor
+	return nil
+}
+
+// mergeHeap ordena as fontes pela transação na cabeça de cada uma.
+type mergeHeap struct {
+	q       Query
+	streams []*stream
+	order   []int
+}
+
+func (h *mergeHeap) Len() int { return len(h.order) }
+func (h *mergeHeap) Less(i, j int) bool {
+	return h.q.Less(h.streams[h.order[i]].head(), h.streams[h.order[j]].head())
+}
+func (h *mergeHeap) Swap(i, j int)      { h.order[i], h.order[j] = h.order[j], h.order[i] }
+func (h *mergeHeap) Push(x interface{}) { h.order = append(h.order, x.(int)) }
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.order)
+	i := h.order[n-1]
+	h.order = h.order[:n-1]
+	return i
+}
+
+// Merge junta as fontes em uma única página de q.Limit transações na ordem
+// de q (já normalizada), a partir de q.Cursor, como se todas estivessem em
+// um só lugar.
+//
+// É um k-way merge: cada fonte devolve as suas páginas já ordenadas, e a
+// próxima página de uma fonte só é pedida quando a anterior foi consumida.
+// As primeiras páginas são pedidas em paralelo. Uma transação que aparece em
+// mais de uma fonte (mesmo ID e Timestamp, durante uma migração) entra uma
+// vez, na versão que prevalece (model.Supersedes).
+//
+// errs[i] é o erro da fonte i. Uma fonte que falha na primeira página fica de
+// fora do resultado. Se a falha vier no meio do merge, a página termina ali,
+// com o cursor na última transação intercalada: as que faltam da fonte vêm
+// depois dele, e a próxima página as busca de novo em vez de pulá-las.
+func Merge(q Query, sources []Source) (page Page, errs []error) {
+	errs = make([]error, len(sources))
+	h := &mergeHeap{q: q, streams: make([]*stream, len(sources))}
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		h.streams[i] = &stream{source: source, cursor: q.Cursor}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = h.streams[i].fill()
+		}(i)
+	}
+	wg.Wait()
+	for i, s := range h.streams {
+		if errs[i] == nil && len(s.buf) > 0 {
+			h.order = append(h.order, i)
+		}
+	}
+	heap.Init(h)
+
+	failed := false // Uma fonte falhou ao buscar a próxima página
+	// advance consome a cabeça da fonte i e a devolve ao heap se ainda tiver transações
+	advance := func(i int) {
+		s := h.streams[i]
+		s.pos++
+		if s.pos == len(s.buf) {
+			if s.cursor == "" {
+				return
+			}
+			if err := s.fill(); err != nil {
+				errs[i] = err
+				failed = true
+				return
+			}
+			if len(s.buf) == 0 {
+				return
+			}
+		}
+		heap.Push(h, i)
+	}
+
+	page.Transactions = make([]model.Transaction, 0, q.Limit)
+	seen := make(map[string]bool)
+	var consumed []int         // Fontes cuja cabeça acabou de entrar na página
+	var last model.Transaction // Última transação intercalada
+	for {
+		// A fonte consumida só avança (e talvez busca outra página) se a página ainda não encheu
+		more := false
+		for _, i := range consumed {
+			if len(page.Transactions) < q.Limit {
+				advance(i)
+			} else if s := h.streams[i]; s.pos+1 < len(s.buf) || s.cursor != "" {
+				more = true
+			}
+		}
+		merged := len(consumed) > 0
+		consumed = consumed[:0]
+		if failed || h.Len() == 0 || len(page.Transactions) == q.Limit {
+			if (failed || more || h.Len() > 0) && merged {
+				page.NextCursor = EncodeCursor(last)
+			}
+			break
+		}
+
+		i := heap.Pop(h).(int)
+		tx := h.streams[i].head()
+		consumed = append(consumed, i)
+		for h.Len() > 0 && Compare(h.streams[h.order[0]].head(), tx) == 0 {
+			j := heap.Pop(h).(int)
+			if other := h.streams[j].head(); model.Supersedes(other, tx) {
+				tx = other
+			}
+			consumed = append(consumed, j)
+		}
+		last = tx
+		if !seen[tx.ID] {
+			seen[tx.ID] = true
+			page.Transactions = append(page.Transactions, tx)
+		}
+	}
+	return page, errs
+}
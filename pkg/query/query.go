@@ -0,0 +1,192 @@
+package query
+
+import (
+	"distributed-system/pkg/model"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Query é o payload de MsgReqHistory: filtros avaliados no próprio shard,
+// que devolve uma página por vez. Campos zerados não restringem nada.
+//
+// As transações são ordenadas por (Timestamp, ID), de modo que a ordem é
+// total mesmo com timestamps repetidos e o cursor nunca pula nem repete itens.
+type Query struct {
+	Symbol   string    `json:"symbol,omitempty"`
+	From     time.Time `json:"from"` // Inclusivo
+	To       time.Time `json:"to"`   // Exclusivo
+	MinPrice float64   `json:"min_price,omitempty"`
+	MaxPrice float64   `json:"max_price,omitempty"`
+	MinQty   int       `json:"min_qty,omitempty"`
+	MaxQty   int       `json:"max_qty,omitempty"`
+	Order    string    `json:"order,omitempty"` // "asc" (padrão) ou "desc"
+	Limit    int       `json:"limit,omitempty"` // Padrão DefaultLimit, no máximo MaxLimit
+	Cursor   string    `json:"cursor,omitempty"`
+}
+
+// Page é a resposta a uma Query. NextCursor vazio indica a última página.
+type Page struct {
+	Transactions []model.Transaction `json:"transactions"`
+	NextCursor   string              `json:"next_cursor,omitempty"`
+}
+
+// Cursor é a posição da última transação entregue.
+type Cursor struct {
+	Timestamp time.Time
+	ID        string
+}
+
+// EncodeCursor devolve o cursor opaco que aponta para depois de tx.
+func EncodeCursor(tx model.Transaction) string {
+	raw := strconv.FormatInt(tx.Timestamp.UnixNano(), 10) + ":" + tx.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	return Cursor{Timestamp: time.Unix(0, n), ID: id}, nil
+}
+
+// Normalize aplica os padrões e rejeita consultas inválidas.
+func (q Query) Normalize() (Query, error) {
+	switch q.Order {
+	case "":
+		q.Order = OrderAsc
+	case OrderAsc, OrderDesc:
+	default:
+		return q, fmt.Errorf("unknown order %q (use asc or desc)", q.Order)
+	}
+	if q.Limit < 0 {
+		return q, fmt.Errorf("limit must not be negative")
+	}
+	if q.Limit == 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+	if q.MinPrice < 0 || q.MaxPrice < 0 || (q.MaxPrice > 0 && q.MinPrice > q.MaxPrice) {
+		return q, fmt.Errorf("invalid price bounds")
+	}
+	if q.MinQty < 0 || q.MaxQty < 0 || (q.MaxQty > 0 && q.MinQty > q.MaxQty) {
+		return q, fmt.Errorf("invalid quantity bounds")
+	}
+	if q.Cursor != "" {
+		if _, err := DecodeCursor(q.Cursor); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// Matches informa se tx passa pelos filtros (sem considerar o cursor).
+func (q Query) Matches(tx model.Transaction) bool {
+	if q.Symbol != "" && tx.Symbol != q.Symbol {
+		return false
+	}
+	if !q.From.IsZero() && tx.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !tx.Timestamp.Before(q.To) {
+		return false
+	}
+	if q.MinPrice > 0 && tx.Price < q.MinPrice {
+		return false
+	}
+	if q.MaxPrice > 0 && tx.Price > q.MaxPrice {
+		return false
+	}
+	if q.MinQty > 0 && tx.Quantity < q.MinQty {
+		return false
+	}
+	if q.MaxQty > 0 && tx.Quantity > q.MaxQty {
+		return false
+	}
+	return true
+}
+
+// Compare ordena transações por (Timestamp, ID) crescente.
+func Compare(a, b model.Transaction) int {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		if a.Timestamp.Before(b.Timestamp) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// Less informa se a vem antes de b na ordem pedida.
+func (q Query) Less(a, b model.Transaction) bool {
+	if q.Order == OrderDesc {
+		return Compare(a, b) > 0
+	}
+	return Compare(a, b) < 0
+}
+
+// AfterCursor informa se tx vem depois do cursor na ordem pedida.
+func (q Query) AfterCursor(tx model.Transaction, c Cursor) bool {
+	return q.Less(model.Transaction{Timestamp: c.Timestamp, ID: c.ID}, tx)
+}
+
+// Run avalia a consulta sobre as transações percorridas por scan.
+func Run(scan func(fn func(tx model.Transaction) bool), q Query) (Page, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return Page{}, err
+	}
+	var cursor *Cursor
+	if q.Cursor != "" {
+		c, _ := DecodeCursor(q.Cursor)
+		cursor = &c
+	}
+
+	var matched []model.Transaction
+	scan(func(tx model.Transaction) bool {
+		if q.Matches(tx) && (cursor == nil || q.AfterCursor(tx, *cursor)) {
+			matched = append(matched, tx)
+		}
+		return true
+	})
+	sort.Slice(matched, func(i, j int) bool { return q.Less(matched[i], matched[j]) })
+	return Paginate(matched, q.Limit), nil
+}
+
+// Paginate corta uma lista já ordenada e filtrada em uma página de até limit itens.
+func Paginate(sorted []model.Transaction, limit int) Page {
+	page := Page{Transactions: sorted}
+	if len(sorted) > limit {
+		page.Transactions = sorted[:limit]
+		page.NextCursor = EncodeCursor(sorted[limit-1])
+	}
+	if page.Transactions == nil {
+		page.Transactions = []model.Transaction{}
+	}
+	return page
+}
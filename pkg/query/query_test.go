@@ -0,0 +1,260 @@
+package query
+
+import (
+	"distributed-system/pkg/model"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+func sample() []model.Transaction {
+	var txs []model.Transaction
+	for i := 0; i < 20; i++ {
+		symbol := "PETR4"
+		if i%2 == 1 {
+			symbol = "VALE3"
+		}
+		txs = append(txs, model.Transaction{
+			ID:        fmt.Sprintf("tx-%02d", i),
+			Symbol:    symbol,
+			Price:     20 + float64(i),
+			Quantity:  100 * (i%5 + 1),
+			Timestamp: base.Add(time.Duration(i/2) * time.Minute), // Pares com o mesmo timestamp
+		})
+	}
+	return txs
+}
+
+func scanOf(txs []model.Transaction) func(func(model.Transaction) bool) {
+	return func(fn func(model.Transaction) bool) {
+		for _, tx := range txs {
+			if !fn(tx) {
+				return
+			}
+		}
+	}
+}
+
+func TestRunFilters(t *testing.T) {
+	page, err := Run(scanOf(sample()), Query{
+		Symbol:   "PETR4",
+		From:     base.Add(2 * time.Minute),
+		To:       base.Add(8 * time.Minute),
+		MinPrice: 25,
+		MaxQty:   400,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// PETR4 em [2m, 8m): tx-04..tx-14 (pares); preço >= 25 exclui tx-04; qty <= 400 exclui tx-14 (500)
+	var got []string
+	for _, tx := range page.Transactions {
+		got = append(got, tx.ID)
+	}
+	want := []string{"tx-06", "tx-08", "tx-10", "tx-12"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Esperava %v, recebido %v", want, got)
+	}
+	if page.NextCursor != "" {
+		t.Errorf("Esperava a última página sem próximo cursor")
+	}
+}
+
+func TestRunPaginatesWithoutGapsOrDuplicates(t *testing.T) {
+	for _, order := range []string{OrderAsc, OrderDesc} {
+		q := Query{Order: order, Limit: 3}
+		var all []model.Transaction
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatalf("%s: a paginação não terminou", order)
+			}
+			page, err := Run(scanOf(sample()), q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, page.Transactions...)
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+
+		if len(all) != 20 {
+			t.Fatalf("%s: esperava 20 transações somando as páginas, recebido %d", order, len(all))
+		}
+		seen := map[string]bool{}
+		for i, tx := range all {
+			if seen[tx.ID] {
+				t.Fatalf("%s: %s duplicada", order, tx.ID)
+			}
+			seen[tx.ID] = true
+			if i > 0 && !q.Less(all[i-1], tx) {
+				t.Fatalf("%s: fora de ordem na posição %d: %s antes de %s", order, i, all[i-1].ID, tx.ID)
+			}
+		}
+	}
+}
+
+func TestNormalizeRejectsInvalidQueries(t *testing.T) {
+	bad := []Query{
+		{Order: "random"},
+		{Limit: -1},
+		{From: base, To: base},
+		{MinPrice: 30, MaxPrice: 20},
+		{MinQty: -1},
+		{Cursor: "not a cursor!"},
+	}
+	for _, q := range bad {
+		if _, err := q.Normalize(); err == nil {
+			t.Errorf("Esperava que %+v fosse rejeitada", q)
+		}
+	}
+
+	q, err := Query{Limit: 5000}.Normalize()
+	if err != nil || q.Limit != MaxLimit || q.Order != OrderAsc {
+		t.Errorf("Esperava os padrões e o limite em no máximo %d, recebido %+v (%v)", MaxLimit, q, err)
+	}
+}
+
+// sourceOf serve txs página a página como um shard e conta as páginas pedidas.
+func sourceOf(txs []model.Transaction, q Query, fetches *int) Source {
+	return func(cursor string) (Page, error) {
+		*fetches++
+		qq := q
+		qq.Cursor = cursor
+		return Run(scanOf(txs), qq)
+	}
+}
+
+func TestMergeOrdersAcrossSourcesAndPaginates(t *testing.T) {
+	all := sample()
+	// Empates de timestamp ficam em fontes diferentes; tx-00 também está na
+	// fonte 2, numa versão mais nova (migração em andamento)
+	var parts [3][]model.Transaction
+	for i, tx := range all {
+		parts[i%2] = append(parts[i%2], tx)
+	}
+	newer := all[0]
+	newer.Version, newer.Price = 2, 99
+	parts[2] = []model.Transaction{newer}
+
+	for _, order := range []string{OrderAsc, OrderDesc} {
+		q, _ := Query{Order: order, Limit: 3}.Normalize()
+		var ids []string
+		for pages := 0; pages < 20; pages++ {
+			sources := make([]Source, len(parts))
+			for i := range parts {
+				sources[i] = sourceOf(parts[i], q, new(int))
+			}
+			page, errs := Merge(q, sources)
+			for _, err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, tx := range page.Transactions {
+				if tx.ID == "tx-00" && tx.Version != 2 {
+					t.Errorf("%s: tx-00 deveria vir na versão mais nova, veio %+v", order, tx)
+				}
+				ids = append(ids, tx.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		want := ids[:0:0]
+		for _, tx := range all {
+			want = append(want, tx.ID)
+		}
+		if order == OrderDesc {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("%s: esperava %v, recebido %v", order, want, ids)
+		}
+	}
+}
+
+func TestMergeFetchesLazilyAndReportsFailures(t *testing.T) {
+	all := sample()
+	q, _ := Query{Order: OrderDesc, Limit: 4}.Normalize()
+
+	// As mais recentes estão todas na primeira fonte: só ela precisa de mais páginas
+	var recent, old int
+	failed := func(string) (Page, error) { return Page{}, fmt.Errorf("connection refused") }
+	page, errs := Merge(q, []Source{sourceOf(all[:10], q, &old), sourceOf(all[10:], q, &recent), failed})
+	if len(page.Transactions) != 4 || page.Transactions[0].ID != "tx-19" || page.Transactions[3].ID != "tx-16" {
+		t.Errorf("Esperava tx-19..tx-16, recebido %+v", page.Transactions)
+	}
+	if old != 1 || recent != 1 {
+		t.Errorf("Esperava uma página de cada fonte, pediu %d e %d", old, recent)
+	}
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("Esperava erro apenas na terceira fonte, recebido %v", errs)
+	}
+
+	// Fontes que devolvem páginas menores que o limite são lidas até completar a página
+	small := q
+	small.Limit = 3
+	recent, old = 0, 0
+	page, _ = Merge(Query{Order: OrderDesc, Limit: 8}, []Source{sourceOf(all[:10], small, &old), sourceOf(all[10:], small, &recent)})
+	if len(page.Transactions) != 8 || page.Transactions[7].ID != "tx-12" || page.NextCursor == "" {
+		t.Errorf("Esperava tx-19..tx-12 com mais páginas, recebido %+v", page)
+	}
+	if old != 1 || recent != 3 {
+		t.Errorf("Só a fonte consumida deveria pedir outras páginas, pediu %d e %d", old, recent)
+	}
+}
+
+func TestMergeStopsAtMidMergeFailure(t *testing.T) {
+	all := sample()
+	q, _ := Query{Order: OrderAsc, Limit: 10}.Normalize()
+	small := q
+	small.Limit = 2
+
+	// A segunda página da fonte 0 falha: a página termina antes do que ficou faltando dela
+	var even, odd []model.Transaction
+	for i, tx := range all {
+		if i%2 == 0 {
+			even = append(even, tx)
+		} else {
+			odd = append(odd, tx)
+		}
+	}
+	pages := 0
+	flaky := func(cursor string) (Page, error) {
+		if pages++; pages > 1 {
+			return Page{}, fmt.Errorf("connection reset")
+		}
+		return sourceOf(even, small, new(int))(cursor)
+	}
+	page, errs := Merge(q, []Source{flaky, sourceOf(odd, q, new(int))})
+	if errs[0] == nil || errs[1] != nil {
+		t.Fatalf("Esperava erro só na primeira fonte, recebido %v", errs)
+	}
+	if len(page.Transactions) >= q.Limit || page.NextCursor == "" {
+		t.Fatalf("Esperava uma página curta com cursor, recebido %d transações e cursor %q", len(page.Transactions), page.NextCursor)
+	}
+
+	// Continuando do cursor com a fonte de volta, nada fica de fora nem se repete
+	ids := make(map[string]int)
+	for _, tx := range page.Transactions {
+		ids[tx.ID]++
+	}
+	for q.Cursor = page.NextCursor; q.Cursor != ""; q.Cursor = page.NextCursor {
+		page, _ = Merge(q, []Source{sourceOf(even, q, new(int)), sourceOf(odd, q, new(int))})
+		for _, tx := range page.Transactions {
+			ids[tx.ID]++
+		}
+	}
+	for _, tx := range all {
+		if ids[tx.ID] != 1 {
+			t.Errorf("%s apareceu %d vezes", tx.ID, ids[tx.ID])
+		}
+	}
+}
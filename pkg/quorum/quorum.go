@@ -0,0 +1,142 @@
+package quorum
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Validate confere W e R para uma partição com n nós. Com W + R > n toda
+// leitura encontra pelo menos um nó que recebeu a última gravação confirmada.
+func Validate(n, w, r int) error {
+	if w < 1 || w > n {
+		return fmt.Errorf("write quorum %d out of range 1..%d", w, n)
+	}
+	if r < 1 || r > n {
+		return fmt.Errorf("read quorum %d out of range 1..%d", r, n)
+	}
+	return nil
+}
+
+type result struct {
+	node string
+	page query.Page
+	err  error
+}
+
+// Write envia a gravação a todos os nós em paralelo e retorna assim que w
+// confirmarem (os demais continuam em segundo plano), ou com erro quando
+// falhas suficientes tornam o quorum impossível.
+func Write(nodes []string, w int, send func(node string) error) (acked []string, err error) {
+	results := make(chan result, len(nodes))
+	for _, node := range nodes {
+		go func(node string) {
+			results <- result{node: node, err: send(node)}
+		}(node)
+	}
+
+	var errs []string
+	for range nodes {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", res.node, res.err))
+			if len(errs) > len(nodes)-w {
+				return acked, fmt.Errorf("write quorum not reached: %d/%d acks (%s)", len(acked), w, strings.Join(errs, "; "))
+			}
+			continue
+		}
+		acked = append(acked, res.node)
+		if len(acked) >= w {
+			return acked, nil
+		}
+	}
+	return acked, fmt.Errorf("write quorum not reached: %d/%d acks", len(acked), w)
+}
+
+// Response é a página devolvida por um nó.
+type Response struct {
+	Node string
+	Page query.Page
+}
+
+// Read consulta todos os nós em paralelo e retorna as r primeiras respostas.
+func Read(nodes []string, r int, fetch func(node string) (query.Page, error)) ([]Response, error) {
+	results := make(chan result, len(nodes))
+	for _, node := range nodes {
+		go func(node string) {
+			page, err := fetch(node)
+			results <- result{node: node, page: page, err: err}
+		}(node)
+	}
+
+	var responses []Response
+	var errs []string
+	for range nodes {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", res.node, res.err))
+			if len(errs) > len(nodes)-r {
+				return responses, fmt.Errorf("read quorum not reached: %d/%d responses (%s)", len(responses), r, strings.Join(errs, "; "))
+			}
+			continue
+		}
+		responses = append(responses, Response{Node: res.node, Page: res.page})
+		if len(responses) >= r {
+			return responses, nil
+		}
+	}
+	return responses, fmt.Errorf("read quorum not reached: %d/%d responses", len(responses), r)
+}
+
+// Merge combina as páginas das réplicas para a consulta q (já normalizada):
+// de cada ID fica a versão que prevalece (model.Supersedes) e o resultado é
+// cortado em q.Limit.
+//
+// Também devolve os reparos de leitura: para cada nó, as transações que ele
+// devolveu numa versão antiga ou que deveria ter devolvido e não devolveu.
+// Um nó que parou a página antes (NextCursor) só é cobrado pelas transações
+// que caem dentro do trecho que ele cobriu.
+func Merge(responses []Response, q query.Query) (query.Page, map[string][]model.Transaction) {
+	latest := make(map[string]model.Transaction)
+	hasMore := false
+	for _, resp := range responses {
+		hasMore = hasMore || resp.Page.NextCursor != ""
+		for _, tx := range resp.Page.Transactions {
+			if cur, ok := latest[tx.ID]; !ok || model.Supersedes(tx, cur) {
+				latest[tx.ID] = tx
+			}
+		}
+	}
+
+	merged := make([]model.Transaction, 0, len(latest))
+	for _, tx := range latest {
+		merged = append(merged, tx)
+	}
+	sort.Slice(merged, func(i, j int) bool { return q.Less(merged[i], merged[j]) })
+
+	repairs := make(map[string][]model.Transaction)
+	for _, resp := range responses {
+		have := make(map[string]model.Transaction, len(resp.Page.Transactions))
+		for _, tx := range resp.Page.Transactions {
+			have[tx.ID] = tx
+		}
+		txs := resp.Page.Transactions
+		for _, tx := range merged {
+			// Fora do trecho coberto por uma página incompleta: não dá para saber se o nó tem
+			if resp.Page.NextCursor != "" && len(txs) > 0 && q.Less(txs[len(txs)-1], tx) {
+				break
+			}
+			if cur, ok := have[tx.ID]; !ok || model.Supersedes(tx, cur) {
+				repairs[resp.Node] = append(repairs[resp.Node], tx)
+			}
+		}
+	}
+
+	page := query.Paginate(merged, q.Limit)
+	if page.NextCursor == "" && hasMore && len(page.Transactions) > 0 {
+		page.NextCursor = query.EncodeCursor(page.Transactions[len(page.Transactions)-1])
+	}
+	return page, repairs
+}
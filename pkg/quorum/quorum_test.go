@@ -0,0 +1,135 @@
+package quorum
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var base = time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+func tx(i int, version int64) model.Transaction {
+	return model.Transaction{
+		ID:        fmt.Sprintf("tx-%d", i),
+		Symbol:    "PETR4",
+		Price:     20 + float64(version),
+		Quantity:  100,
+		Timestamp: base.Add(time.Duration(i) * time.Minute),
+		Version:   version,
+	}
+}
+
+// faultySender simula os nós: cada chamada de Write recebe o próprio conjunto
+// de nós fora, e wait bloqueia até todos os envios (inclusive os que Write
+// deixou em segundo plano) terminarem
+func faultySender(nodes []string, down ...string) (send func(node string) error, wait func()) {
+	var wg sync.WaitGroup
+	wg.Add(len(nodes))
+	failing := make(map[string]bool, len(down))
+	for _, node := range down {
+		failing[node] = true
+	}
+	send = func(node string) error {
+		defer wg.Done()
+		if failing[node] {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	return send, wg.Wait
+}
+
+// TestWriteQuorum valida que W confirmações bastam e que falhas demais abortam a gravação
+func TestWriteQuorum(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+
+	send, wait := faultySender(nodes, "c")
+	acked, err := Write(nodes, 2, send)
+	wait()
+	if err != nil || len(acked) != 2 {
+		t.Fatalf("W=2 com um nó fora deveria passar: acks=%v err=%v", acked, err)
+	}
+
+	send, wait = faultySender(nodes, "b", "c")
+	_, err = Write(nodes, 2, send)
+	wait()
+	if err == nil {
+		t.Error("W=2 com dois nós fora deveria falhar")
+	}
+
+	send, wait = faultySender(nodes, "b", "c")
+	acked, err = Write(nodes, 1, send)
+	wait()
+	if err != nil || len(acked) != 1 || acked[0] != "a" {
+		t.Errorf("W=1 deveria aceitar a confirmação de a: acks=%v err=%v", acked, err)
+	}
+}
+
+// TestReadQuorumReturnsFirstResponses valida que a leitura não espera o nó lento
+func TestReadQuorumReturnsFirstResponses(t *testing.T) {
+	slow := make(chan struct{})
+	defer close(slow)
+	fetch := func(node string) (query.Page, error) {
+		if node == "slow" {
+			<-slow
+		}
+		return query.Page{Transactions: []model.Transaction{tx(1, 1)}}, nil
+	}
+
+	start := time.Now()
+	responses, err := Read([]string{"a", "slow", "b"}, 2, fetch)
+	if err != nil || len(responses) != 2 {
+		t.Fatalf("Esperava 2 respostas: %v err=%v", responses, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Leitura não deveria esperar o nó lento")
+	}
+	if _, err := Read([]string{"a"}, 1, func(string) (query.Page, error) { return query.Page{}, fmt.Errorf("down") }); err == nil {
+		t.Error("Leitura sem respostas suficientes deveria falhar")
+	}
+}
+
+// TestMergeResolvesVersionsAndPlansRepairs valida a resolução por versão e o reparo de leitura
+func TestMergeResolvesVersionsAndPlansRepairs(t *testing.T) {
+	q, _ := query.Query{Limit: 10}.Normalize()
+	responses := []Response{
+		{Node: "a", Page: query.Page{Transactions: []model.Transaction{tx(1, 2), tx(2, 1), tx(3, 1)}}},
+		{Node: "b", Page: query.Page{Transactions: []model.Transaction{tx(1, 1), tx(3, 1)}}}, // tx-1 antiga, sem tx-2
+	}
+
+	page, repairs := Merge(responses, q)
+	if len(page.Transactions) != 3 || page.Transactions[0].Version != 2 {
+		t.Fatalf("Esperava 3 transações com tx-1 na versão 2: %+v", page.Transactions)
+	}
+	if len(repairs["a"]) != 0 {
+		t.Errorf("Nó a está atualizado, reparos: %v", repairs["a"])
+	}
+	got := repairs["b"]
+	if len(got) != 2 || got[0].ID != "tx-1" || got[0].Version != 2 || got[1].ID != "tx-2" {
+		t.Errorf("Nó b deveria receber tx-1 v2 e tx-2, recebeu %+v", got)
+	}
+}
+
+// TestMergeRespectsTruncatedPages valida que um nó com página incompleta não é
+// reparado com transações além do trecho que ele cobriu
+func TestMergeRespectsTruncatedPages(t *testing.T) {
+	q, _ := query.Query{Limit: 2}.Normalize()
+	responses := []Response{
+		{Node: "a", Page: query.Page{Transactions: []model.Transaction{tx(1, 1), tx(2, 1)}, NextCursor: "more"}},
+		{Node: "b", Page: query.Page{Transactions: []model.Transaction{tx(2, 1), tx(3, 1)}, NextCursor: "more"}},
+	}
+
+	page, repairs := Merge(responses, q)
+	if len(page.Transactions) != 2 || page.Transactions[0].ID != "tx-1" || page.NextCursor == "" {
+		t.Fatalf("Esperava tx-1, tx-2 e próximo cursor: %+v", page)
+	}
+	if len(repairs["a"]) != 0 {
+		t.Errorf("tx-3 está além da página de a, não deveria ser reparada: %v", repairs["a"])
+	}
+	if len(repairs["b"]) != 1 || repairs["b"][0].ID != "tx-1" {
+		t.Errorf("Nó b deveria receber tx-1, recebeu %+v", repairs["b"])
+	}
+}
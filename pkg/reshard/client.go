@@ -0,0 +1,74 @@
+package reshard
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/protocol"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCPCluster é o Cluster sobre o protocolo dos shards.
+type TCPCluster struct {
+	Timeout time.Duration
+}
+
+func (c TCPCluster) ReadLog(node string, fromLSN uint64, max int) ([]storage.Entry, uint64, error) {
+	var batch struct {
+		Entries    []storage.Entry `json:"entries"`
+		PrimaryLSN uint64          `json:"primary_lsn"`
+	}
+	err := c.request(node, protocol.NewMessage(protocol.MsgReqLog, protocol.LogRequest{FromLSN: fromLSN, Max: max}), &batch)
+	return batch.Entries, batch.PrimaryLSN, err
+}
+
+func (c TCPCluster) Store(node string, tx model.Transaction) error {
+	return c.request(node, protocol.NewMessage(protocol.MsgStoreTx, tx), nil)
+}
+
+func (c TCPCluster) Delete(node, id string) error {
+	return c.request(node, protocol.NewMessage(protocol.MsgDeleteTx, protocol.DeleteRequest{ID: id}), nil)
+}
+
+func (c TCPCluster) request(addr string, msg protocol.Message, out interface{}) error {
+	return call(addr, c.Timeout, msg, out)
+}
+
+// TopologySwitch aplica os comandos de topologia via MsgAdminTopology no
+// serviço em addr (Core ou Aggregator).
+func TopologySwitch(addr string, timeout time.Duration) Switch {
+	return func(action, shards string) error {
+		return call(addr, timeout, protocol.NewMessage(protocol.MsgAdminTopology, protocol.TopologyCommand{Action: action, Shards: shards}), nil)
+	}
+}
+
+func call(addr string, timeout time.Duration, msg protocol.Message, out interface{}) error {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := protocol.SendJSON(conn, msg); err != nil {
+		return err
+	}
+	var resp protocol.Message
+	if err := protocol.ReceiveJSON(conn, &resp); err != nil {
+		return err
+	}
+	if resp.Type == protocol.MsgError {
+		var reason string
+		json.Unmarshal(resp.Payload, &reason)
+		return fmt.Errorf("%s", reason)
+	}
+	if out != nil {
+		return json.Unmarshal(resp.Payload, out)
+	}
+	return nil
+}
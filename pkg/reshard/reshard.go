@@ -0,0 +1,289 @@
+package reshard
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/ring"
+	"distributed-system/pkg/storage"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Fases de uma migração.
+const (
+	PhaseCopy    = "copy"    // Copiando para os novos donos; leituras nas duas topologias
+	PhaseCleanup = "cleanup" // Topologia nova em uso; removendo as cópias dos donos antigos
+	PhaseDone    = "done"
+)
+
+const batchSize = 500
+
+// Cluster é o acesso da migração aos nós de histórico.
+type Cluster interface {
+	// ReadLog devolve até max entradas do log do nó com LSN maior que fromLSN,
+	// junto com o último LSN do nó.
+	ReadLog(node string, fromLSN uint64, max int) ([]storage.Entry, uint64, error)
+	Store(node string, tx model.Transaction) error
+	Delete(node, id string) error
+}
+
+// Switch aplica um comando de topologia (ring.ActionBegin ou ring.ActionCommit)
+// a um serviço que roteia para os shards, como o Core ou o Aggregator.
+type Switch func(action, shards string) error
+
+// SourceProgress é o avanço da migração sobre o log de um nó de origem.
+type SourceProgress struct {
+	CopiedLSN  uint64 `json:"copied_lsn"`  // Log lido até aqui na cópia
+	Copied     int    `json:"copied"`      // Transações gravadas no novo dono
+	CleanedLSN uint64 `json:"cleaned_lsn"` // Log lido até aqui na limpeza
+	Deleted    int    `json:"deleted"`     // Cópias removidas do dono antigo
+}
+
+// Progress é o estado de uma migração, gravado a cada lote para que ela possa
+// ser retomada do ponto em que parou.
+type Progress struct {
+	From    string                     `json:"from"`
+	To      string                     `json:"to"`
+	Phase   string                     `json:"phase"`
+	Sources map[string]*SourceProgress `json:"sources"`
+	Updated time.Time                  `json:"updated"`
+}
+
+// LoadProgress lê o progresso gravado em path.
+func LoadProgress(path string) (*Progress, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Progress
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("corrupted progress file %s: %v", path, err)
+	}
+	return &p, nil
+}
+
+// Migrator move as transações da topologia From para a topologia To sem
+// parar leituras nem gravações:
+//
+//  1. Os leitores (Aggregator) passam a consultar as duas topologias e em
+//     seguida os escritores (Core) passam a gravar no dono de destino.
+//  2. O log de cada nó de origem é lido até a posição que tinha depois da
+//     troca, e cada transação que muda de dono é gravada no novo dono. Como a
+//     gravação é idempotente por ID e versão, repetir um lote não duplica nada.
+//  3. Escritores e leitores passam a usar apenas a topologia de destino.
+//  4. As cópias que ficaram nos donos antigos são removidas.
+//
+// O progresso fica em State, e Run pode ser chamado de novo depois de uma
+// falha para continuar de onde parou.
+//
+// Só as transações (entradas put do log) são movidas. Os agregados da
+// retenção de um primário com -retention ficam nele: o Aggregator reporta
+// como erro as agregações que dependem deles durante a migração, e depois
+// dela o histórico compactado que mudou de dono não é mais consultado.
+type Migrator struct {
+	From, To []ring.Partition
+	KeyBy    string
+	Quorum   bool // Nós de cada partição são pares: lê o log de todos e grava em todos
+	Cluster  Cluster
+	Readers  []Switch
+	Writers  []Switch
+	Settle   time.Duration // Espera depois de trocar os escritores, para gravações em andamento terminarem
+	State    string        // Arquivo de progresso ("" = não persiste)
+	Logf     func(format string, args ...interface{})
+
+	next     *ring.Router
+	progress *Progress
+}
+
+// Run executa (ou retoma) a migração até o fim.
+func (m *Migrator) Run() error {
+	next, err := ring.NewPartitionedRouter(m.To, m.KeyBy)
+	if err != nil {
+		return err
+	}
+	m.next = next
+	if err := m.loadProgress(); err != nil {
+		return err
+	}
+	to := ring.FormatPartitions(m.To)
+
+	if m.progress.Phase == PhaseCopy {
+		if err := m.switchAll(ring.ActionBegin, to, m.Readers, m.Writers); err != nil {
+			return err
+		}
+		time.Sleep(m.Settle)
+		if err := m.forEachSource(m.copyFrom); err != nil {
+			return err
+		}
+		m.progress.Phase = PhaseCleanup
+		if err := m.save(); err != nil {
+			return err
+		}
+	}
+
+	if m.progress.Phase == PhaseCleanup {
+		if err := m.switchAll(ring.ActionCommit, to, m.Writers, m.Readers); err != nil {
+			return err
+		}
+		if err := m.forEachSource(m.cleanup); err != nil {
+			return err
+		}
+		m.progress.Phase = PhaseDone
+		if err := m.save(); err != nil {
+			return err
+		}
+	}
+	m.logf("Migration to %s done", to)
+	return nil
+}
+
+// Progress devolve o estado atual da migração.
+func (m *Migrator) Progress() Progress {
+	return *m.progress
+}
+
+func (m *Migrator) loadProgress() error {
+	from, to := ring.FormatPartitions(m.From), ring.FormatPartitions(m.To)
+	if m.State != "" {
+		p, err := LoadProgress(m.State)
+		if err == nil && p.Phase != PhaseDone {
+			if p.From != from || p.To != to {
+				return fmt.Errorf("unfinished migration from %s to %s in %s", p.From, p.To, m.State)
+			}
+			m.progress = p
+			m.logf("Resuming migration in phase %s", p.Phase)
+			return nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	m.progress = &Progress{From: from, To: to, Phase: PhaseCopy, Sources: make(map[string]*SourceProgress)}
+	return m.save()
+}
+
+func (m *Migrator) save() error {
+	m.progress.Updated = time.Now()
+	if m.State == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.progress, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.State + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.State)
+}
+
+func (m *Migrator) switchAll(action, shards string, groups ...[]Switch) error {
+	for _, group := range groups {
+		for _, sw := range group {
+			if err := sw(action, shards); err != nil {
+				return fmt.Errorf("topology %s: %v", action, err)
+			}
+		}
+	}
+	return nil
+}
+
+// forEachSource chama fn para cada nó de origem: os primários, ou todos os
+// nós no modo quorum (cada par pode ter gravações que os outros não têm).
+func (m *Migrator) forEachSource(fn func(p ring.Partition, node string, sp *SourceProgress) error) error {
+	for _, p := range m.From {
+		nodes := []string{p.Primary}
+		if m.Quorum {
+			nodes = p.Nodes()
+		}
+		for _, node := range nodes {
+			sp := m.progress.Sources[node]
+			if sp == nil {
+				sp = &SourceProgress{}
+				m.progress.Sources[node] = sp
+			}
+			if err := fn(p, node, sp); err != nil {
+				return fmt.Errorf("%s: %v", node, err)
+			}
+		}
+	}
+	return nil
+}
+
+// walk percorre o log do nó a partir de *lsn até a posição que ele tinha no
+// início, chamando fn para cada transação que mudou de dono, e grava o
+// progresso a cada lote. Gravações posteriores já seguiram a topologia nova.
+func (m *Migrator) walk(p ring.Partition, node string, lsn *uint64, fn func(tx model.Transaction, owner string) error) error {
+	var end uint64
+	for {
+		entries, last, err := m.Cluster.ReadLog(node, *lsn, batchSize)
+		if err != nil {
+			return err
+		}
+		if end == 0 {
+			end = last
+		}
+		for _, e := range entries {
+			if e.LSN > end {
+				return m.save()
+			}
+			if e.Op == storage.OpPut {
+				if owner := m.next.OwnerOf(e.Tx); owner != p.Primary {
+					if err := fn(e.Tx, owner); err != nil {
+						return err
+					}
+				}
+			}
+			*lsn = e.LSN
+		}
+		if err := m.save(); err != nil {
+			return err
+		}
+		if len(entries) == 0 || *lsn >= end {
+			return nil
+		}
+	}
+}
+
+func (m *Migrator) copyFrom(p ring.Partition, node string, sp *SourceProgress) error {
+	err := m.walk(p, node, &sp.CopiedLSN, func(tx model.Transaction, owner string) error {
+		for _, target := range m.targets(owner) {
+			if err := m.Cluster.Store(target, tx); err != nil {
+				return fmt.Errorf("copy %s to %s: %v", tx.ID, target, err)
+			}
+		}
+		sp.Copied++
+		return nil
+	})
+	m.logf("Copy from %s: %d transactions moved (LSN %d)", node, sp.Copied, sp.CopiedLSN)
+	return err
+}
+
+func (m *Migrator) cleanup(p ring.Partition, node string, sp *SourceProgress) error {
+	err := m.walk(p, node, &sp.CleanedLSN, func(tx model.Transaction, _ string) error {
+		if err := m.Cluster.Delete(node, tx.ID); err != nil {
+			return fmt.Errorf("delete %s: %v", tx.ID, err)
+		}
+		sp.Deleted++
+		return nil
+	})
+	m.logf("Cleanup of %s: %d copies removed (LSN %d)", node, sp.Deleted, sp.CleanedLSN)
+	return err
+}
+
+// targets lista os nós que recebem a cópia: o primário do novo dono (as
+// réplicas recebem pelo log dele) ou, no modo quorum, todos os nós.
+func (m *Migrator) targets(owner string) []string {
+	if m.Quorum {
+		return m.next.Partition(owner).Nodes()
+	}
+	return []string{owner}
+}
+
+func (m *Migrator) logf(format string, args ...interface{}) {
+	if m.Logf != nil {
+		m.Logf(format, args...)
+	}
+}
@@ -0,0 +1,93 @@
+package retention
+
+import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/storage"
+	"sort"
+	"time"
+)
+
+// Result é o efeito de uma rodada de compactação.
+type Result struct {
+	Symbols int `json:"symbols"` // Símbolos com alguma regra aplicada
+	Buckets int `json:"buckets"` // Faixas (do nível mais fino) geradas a partir de transações brutas
+	Expired int `json:"expired"` // Transações brutas removidas
+	Dropped int `json:"dropped"` // Níveis de agregados podados
+}
+
+// Compactor aplica a política de retenção a um Store.
+//
+// Cada rodada, por símbolo: as transações brutas mais antigas que Raw (até
+// um limite alinhado à maior faixa) são agregadas em todos os níveis e os
+// agregados são gravados; só então as transações são expiradas do Store.
+// Um crash entre os dois passos não conta nada duas vezes: o que está antes
+// de Compacted nunca é agregado de novo, apenas expirado. As leituras do
+// Store são feitas página a página, sem segurar o Store entre elas.
+type Compactor struct {
+	Store   storage.Store
+	Rollups *Rollups
+	Policy  Policy
+}
+
+// Run faz uma rodada de compactação considerando o instante now.
+func (c *Compactor) Run(now time.Time) (Result, error) {
+	var res Result
+	symbols := append(c.Store.Symbols(), c.Rollups.Symbols()...)
+	sort.Strings(symbols)
+
+	cutoffs := make(map[string]time.Time)
+	for i, symbol := range symbols {
+		if i > 0 && symbol == symbols[i-1] {
+			continue
+		}
+		rule, ok := c.Policy.For(symbol)
+		if !ok {
+			continue
+		}
+		res.Symbols++
+
+		// 1. Transações brutas vencidas viram agregados
+		cutoff := now.Add(-rule.Raw)
+		if n := len(rule.Tiers); n > 0 {
+			cutoff = cutoff.Truncate(rule.Tiers[n-1].Bucket)
+			if compacted := c.Rollups.Compacted(symbol); cutoff.After(compacted) {
+				req := aggregate.Request{Query: query.Query{Symbol: symbol, From: compacted, To: cutoff}, Bucket: rule.Tiers[0].Bucket}
+				partials, err := aggregate.Compute(c.Store.Query, req)
+				if err != nil {
+					return res, err
+				}
+				c.Rollups.commit(symbol, cutoff, rule.Tiers, partials)
+				res.Buckets += len(partials)
+			}
+		}
+		cutoffs[symbol] = cutoff
+
+		// 2. Agregados vencidos são podados, alinhados à faixa do nível seguinte
+		for k, tier := range rule.Tiers {
+			if tier.Keep == 0 {
+				continue
+			}
+			align := tier.Bucket
+			if k+1 < len(rule.Tiers) {
+				align = rule.Tiers[k+1].Bucket
+			}
+			if c.Rollups.drop(symbol, tier.Bucket, now.Add(-tier.Keep).Truncate(align)) {
+				res.Dropped++
+			}
+		}
+	}
+
+	// 3. Com os agregados gravados, as transações brutas podem sair
+	if err := c.Rollups.Save(); err != nil {
+		return res, err
+	}
+	for symbol, cutoff := range cutoffs {
+		n, err := c.Store.Expire(symbol, cutoff)
+		if err != nil {
+			return res, err
+		}
+		res.Expired += n
+	}
+	return res, nil
+}
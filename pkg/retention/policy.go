@@ -0,0 +1,168 @@
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default é a chave da regra aplicada aos símbolos sem regra própria.
+const Default = "*"
+
+// Tier é um nível de agregados: faixas de Bucket mantidas por Keep (zero = para sempre).
+type Tier struct {
+	Bucket time.Duration
+	Keep   time.Duration
+}
+
+// Rule é a retenção de um símbolo: transações brutas ficam Raw e depois só
+// existem como agregados nos Tiers, do mais fino para o mais grosso.
+type Rule struct {
+	Raw   time.Duration
+	Tiers []Tier
+}
+
+// Policy associa regras a símbolos.
+type Policy map[string]Rule
+
+// For devolve a regra do símbolo (ou a padrão).
+func (p Policy) For(symbol string) (Rule, bool) {
+	if rule, ok := p[symbol]; ok {
+		return rule, true
+	}
+	rule, ok := p[Default]
+	return rule, ok
+}
+
+// ParsePolicy lê regras no formato "SÍMBOLO=raw:7d,1m:30d,1h;*=raw:30d,1h:365d":
+// regras separadas por ";", e em cada uma a retenção das transações brutas
+// seguida dos níveis de agregados (faixa:retenção, sem retenção = para sempre).
+// Durações aceitam o sufixo "d" (dias) além das unidades de time.ParseDuration.
+func ParsePolicy(s string) (Policy, error) {
+	policy := make(Policy)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		symbol, spec, ok := strings.Cut(part, "=")
+		symbol = strings.TrimSpace(symbol)
+		if !ok || symbol == "" {
+			return nil, fmt.Errorf("invalid retention rule %q (use SYMBOL=raw:7d,1m:30d)", part)
+		}
+		if _, dup := policy[symbol]; dup {
+			return nil, fmt.Errorf("duplicate retention rule for %s", symbol)
+		}
+		rule, err := parseRule(spec)
+		if err != nil {
+			return nil, fmt.Errorf("retention rule for %s: %v", symbol, err)
+		}
+		policy[symbol] = rule
+	}
+	if len(policy) == 0 {
+		return nil, fmt.Errorf("empty retention policy")
+	}
+	return policy, nil
+}
+
+func parseRule(spec string) (Rule, error) {
+	var rule Rule
+	for i, item := range strings.Split(spec, ",") {
+		name, keep, hasKeep := strings.Cut(strings.TrimSpace(item), ":")
+		if i == 0 {
+			if name != "raw" || !hasKeep {
+				return rule, fmt.Errorf("must start with raw:<duration>")
+			}
+			d, err := ParseDuration(keep)
+			if err != nil || d <= 0 {
+				return rule, fmt.Errorf("invalid raw retention %q", keep)
+			}
+			rule.Raw = d
+			continue
+		}
+
+		bucket, err := ParseDuration(name)
+		if err != nil || bucket < time.Second {
+			return rule, fmt.Errorf("invalid bucket %q (at least 1s)", name)
+		}
+		tier := Tier{Bucket: bucket}
+		if hasKeep {
+			if tier.Keep, err = ParseDuration(keep); err != nil || tier.Keep <= 0 {
+				return rule, fmt.Errorf("invalid retention %q for %s buckets", keep, name)
+			}
+		}
+		if err := rule.add(tier); err != nil {
+			return rule, err
+		}
+	}
+	return rule, nil
+}
+
+// add valida o próximo nível: faixas maiores, múltiplas da anterior, guardadas por mais tempo.
+func (r *Rule) add(tier Tier) error {
+	keep := r.Raw
+	if n := len(r.Tiers); n > 0 {
+		prev := r.Tiers[n-1]
+		if prev.Keep == 0 {
+			return fmt.Errorf("%v buckets after %v buckets that are kept forever", tier.Bucket, prev.Bucket)
+		}
+		if tier.Bucket <= prev.Bucket || tier.Bucket%prev.Bucket != 0 {
+			return fmt.Errorf("%v buckets must be a multiple of %v", tier.Bucket, prev.Bucket)
+		}
+		keep = prev.Keep
+	}
+	if tier.Keep != 0 && tier.Keep <= keep {
+		return fmt.Errorf("%v buckets must be kept longer than %v", tier.Bucket, keep)
+	}
+	r.Tiers = append(r.Tiers, tier)
+	return nil
+}
+
+// String devolve a política no formato aceito por ParsePolicy.
+func (p Policy) String() string {
+	symbols := make([]string, 0, len(p))
+	for symbol := range p {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	rules := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		rule := p[symbol]
+		items := []string{"raw:" + formatDuration(rule.Raw)}
+		for _, tier := range rule.Tiers {
+			item := formatDuration(tier.Bucket)
+			if tier.Keep > 0 {
+				item += ":" + formatDuration(tier.Keep)
+			}
+			items = append(items, item)
+		}
+		rules[i] = symbol + "=" + strings.Join(items, ",")
+	}
+	return strings.Join(rules, ";")
+}
+
+// ParseDuration aceita "7d" além do formato de time.ParseDuration.
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
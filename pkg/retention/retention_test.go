@@ -0,0 +1,144 @@
+package retention
+
+import (
+	"distributed-system/pkg/aggregate"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"distributed-system/pkg/storage"
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("PETR4=raw:7d,1m:30d,1h ; *=raw:12h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, _ := p.For("PETR4")
+	if rule.Raw != 7*24*time.Hour || len(rule.Tiers) != 2 || rule.Tiers[0].Bucket != time.Minute || rule.Tiers[1].Keep != 0 {
+		t.Errorf("Regra de PETR4 incorreta: %+v", rule)
+	}
+	if rule, ok := p.For("VALE3"); !ok || rule.Raw != 12*time.Hour || len(rule.Tiers) != 0 {
+		t.Errorf("VALE3 deveria usar a regra padrão, recebeu %+v", rule)
+	}
+	if p.String() != "*=raw:12h;PETR4=raw:7d,1m:30d,1h" {
+		t.Errorf("Formatação inesperada: %s", p.String())
+	}
+
+	for _, bad := range []string{
+		"",
+		"PETR4=1m:30d",               // Falta raw
+		"PETR4=raw:7d,1h:30d,1m:60d", // Faixas fora de ordem
+		"PETR4=raw:7d,1m:3d",         // Agregado guardado por menos tempo que o bruto
+		"PETR4=raw:7d,1m,1h",         // Nível depois de um guardado para sempre
+		"PETR4=raw:7d,7m:30d,1h",     // 1h não é múltiplo de 7m
+		"PETR4=raw:1d;PETR4=raw:2d",
+	} {
+		if _, err := ParsePolicy(bad); err == nil {
+			t.Errorf("Política %q deveria ser rejeitada", bad)
+		}
+	}
+}
+
+// totals soma os parciais (mesmo resultado qualquer que seja a faixa).
+func totals(partials []aggregate.Partial) aggregate.Partial {
+	acc := aggregate.NewAccumulator(0)
+	for _, p := range partials {
+		acc.Merge(p)
+	}
+	if all := acc.Partials(); len(all) == 1 {
+		return all[0]
+	}
+	return aggregate.Partial{}
+}
+
+// view é a leitura que o shard faz: agregados compactados + transações brutas depois deles.
+func view(t *testing.T, store storage.Store, rollups *Rollups, bucket time.Duration) []aggregate.Partial {
+	req := aggregate.Request{Query: query.Query{Symbol: "PETR4"}, Bucket: bucket}
+	acc := aggregate.NewAccumulator(bucket)
+	for _, p := range rollups.Query(req) {
+		acc.Merge(p)
+	}
+	raw, err := aggregate.Compute(store.Query, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range raw {
+		acc.Merge(p)
+	}
+	return acc.Partials()
+}
+
+// TestCompactorKeepsTotalsAcrossTiers valida que compactar e podar níveis
+// mantém os totais, que rodadas repetidas ou reiniciadas não contam nada duas
+// vezes e que o nível mais fino é descartado antes do mais grosso.
+func TestCompactorKeepsTotalsAcrossTiers(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	store := storage.NewMemStore()
+	for i := 0; i < 3*24*360; i++ { // Uma transação a cada 10s nos últimos 3 dias
+		store.Put(model.Transaction{
+			ID:        fmt.Sprintf("tx-%d", i),
+			Symbol:    "PETR4",
+			Price:     20 + float64(i%13),
+			Quantity:  100 * (i%3 + 1),
+			Timestamp: now.Add(-time.Duration(i) * 10 * time.Second),
+		})
+	}
+	store.Put(model.Transaction{ID: "other", Symbol: "VALE3", Price: 50, Quantity: 10, Timestamp: now.Add(-72 * time.Hour)})
+	policy, _ := ParsePolicy("PETR4=raw:1d,1m:2d,1h")
+	path := filepath.Join(t.TempDir(), "rollups.json")
+	rollups, _ := OpenRollups(path)
+	c := &Compactor{Store: store, Rollups: rollups, Policy: policy}
+
+	want := totals(view(t, store, rollups, time.Hour))
+	check := func(when string, got aggregate.Partial) {
+		t.Helper()
+		if got.Count != want.Count || got.Quantity != want.Quantity || math.Abs(got.Notional-want.Notional) > 1e-6 ||
+			got.MinPrice != want.MinPrice || got.MaxPrice != want.MaxPrice {
+			t.Errorf("%s: totais %+v, esperava %+v", when, got, want)
+		}
+	}
+
+	res, err := c.Run(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A transação exatamente no limite continua bruta; o nível de 1m já nasce podado (> 2 dias)
+	if res.Symbols != 1 || res.Expired != 2*24*360-1 || res.Buckets != 2*24*60 || res.Dropped != 1 {
+		t.Errorf("Rodada inesperada: %+v", res)
+	}
+	if store.Len() != 24*360+2 || !rollups.Compacted("PETR4").Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("Esperava só o último dia bruto, restaram %d (compactado até %v)", store.Len(), rollups.Compacted("PETR4"))
+	}
+	check("após compactar", totals(view(t, store, rollups, time.Hour)))
+	if got := totals(view(t, store, rollups, time.Minute)); got.Count != 2*24*360+1 {
+		t.Errorf("Faixas de 1m deveriam cobrir só os últimos 2 dias, somaram %d transações", got.Count)
+	}
+
+	// Rodada repetida e agregados relidos do disco (restart) não mudam nada
+	if res, _ := c.Run(now); res.Buckets != 0 || res.Expired != 0 {
+		t.Errorf("Rodada repetida não deveria compactar nada: %+v", res)
+	}
+	if rollups, _ = OpenRollups(path); !rollups.Compacted("PETR4").Equal(now.Add(-24 * time.Hour)) {
+		t.Fatalf("Estado da compactação perdido no restart: %v", rollups.Compacted("PETR4"))
+	}
+	c.Rollups = rollups
+	check("após restart", totals(view(t, store, rollups, time.Hour)))
+
+	// Dois dias depois: todo o bruto virou agregado e o nível de 1m foi podado
+	later := now.Add(48 * time.Hour)
+	if res, _ = c.Run(later); res.Dropped != 1 || res.Expired != 24*360+1 || store.Len() != 1 {
+		t.Errorf("Esperava o nível de 1m podado e nenhum PETR4 bruto: %+v, %d restantes", res, store.Len())
+	}
+	check("após podar 1m", totals(view(t, store, rollups, time.Hour)))
+	minutes := view(t, store, rollups, time.Minute)
+	if len(minutes) != 1 || !minutes[0].Start.Equal(now) {
+		t.Errorf("Só a faixa de 1m de now deveria restar, recebeu %d a partir de %v", len(minutes), minutes[0].Start)
+	}
+	if hours := view(t, store, rollups, time.Hour); len(hours) != 73 {
+		t.Errorf("Esperava 73 faixas de 1h, recebeu %d", len(hours))
+	}
+}
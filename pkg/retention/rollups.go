@@ -0,0 +1,254 @@
+package retention
+
+import (
+	"distributed-system/pkg/aggregate"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RollupsFile é o nome do arquivo dos agregados no diretório de dados e nos backups.
+const RollupsFile = "rollups.json"
+
+// symbolState é o quanto de um símbolo já foi compactado.
+type symbolState struct {
+	// Compacted: transações anteriores a este instante só existem nos agregados.
+	Compacted time.Time `json:"compacted"`
+	// Dropped: por faixa, agregados anteriores a este instante já foram descartados.
+	Dropped map[time.Duration]time.Time `json:"dropped,omitempty"`
+}
+
+type tierFile struct {
+	Bucket   time.Duration       `json:"bucket"`
+	Partials []aggregate.Partial `json:"partials"`
+}
+
+type rollupsFile struct {
+	Symbols map[string]*symbolState `json:"symbols"`
+	Tiers   []tierFile              `json:"tiers"`
+}
+
+// Rollups guarda os agregados das transações compactadas, um acumulador por
+// tamanho de faixa. Todos os níveis de um símbolo são calculados a partir das
+// mesmas transações, então cada nível cobre de Dropped até Compacted.
+type Rollups struct {
+	mu      sync.RWMutex
+	path    string // Arquivo JSON onde os agregados são persistidos ("" = apenas memória)
+	tiers   map[time.Duration]*aggregate.Accumulator
+	symbols map[string]*symbolState
+	gen     uint64 // Incrementado a cada mudança
+}
+
+// OpenRollups carrega os agregados persistidos em path, se existirem.
+func OpenRollups(path string) (*Rollups, error) {
+	r := &Rollups{
+		path:    path,
+		tiers:   make(map[time.Duration]*aggregate.Accumulator),
+		symbols: make(map[string]*symbolState),
+	}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var file rollupsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("corrupted rollups file %s: %v", path, err)
+	}
+	for symbol, st := range file.Symbols {
+		r.symbols[symbol] = st
+	}
+	for _, tier := range file.Tiers {
+		acc := r.tier(tier.Bucket)
+		for _, p := range tier.Partials {
+			acc.Merge(p)
+		}
+	}
+	return r, nil
+}
+
+func (r *Rollups) tier(bucket time.Duration) *aggregate.Accumulator {
+	acc, ok := r.tiers[bucket]
+	if !ok {
+		acc = aggregate.NewAccumulator(bucket)
+		r.tiers[bucket] = acc
+	}
+	return acc
+}
+
+func (r *Rollups) state(symbol string) *symbolState {
+	st, ok := r.symbols[symbol]
+	if !ok {
+		st = &symbolState{Dropped: make(map[time.Duration]time.Time)}
+		r.symbols[symbol] = st
+	}
+	if st.Dropped == nil {
+		st.Dropped = make(map[time.Duration]time.Time)
+	}
+	return st
+}
+
+// Compacted devolve até onde as transações do símbolo já viraram agregados.
+func (r *Rollups) Compacted(symbol string) time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if st, ok := r.symbols[symbol]; ok {
+		return st.Compacted
+	}
+	return time.Time{}
+}
+
+// Generation muda a cada compactação ou poda: quem combina os agregados com
+// as transações brutas refaz a leitura se ela mudou no meio.
+func (r *Rollups) Generation() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.gen
+}
+
+// Symbols devolve os símbolos com agregados.
+func (r *Rollups) Symbols() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	symbols := make([]string, 0, len(r.symbols))
+	for symbol := range r.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// commit incorpora os parciais (na faixa do nível mais fino) a todos os níveis
+// e marca o símbolo como compactado até compacted.
+func (r *Rollups) commit(symbol string, compacted time.Time, tiers []Tier, partials []aggregate.Partial) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, tier := range tiers {
+		acc := r.tier(tier.Bucket)
+		for _, p := range partials {
+			acc.Merge(p)
+		}
+	}
+	r.state(symbol).Compacted = compacted
+	r.gen++
+}
+
+// drop descarta os agregados do símbolo no nível bucket anteriores a before.
+// Devolve false se eles já tinham sido descartados.
+func (r *Rollups) drop(symbol string, bucket time.Duration, before time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.state(symbol)
+	if !before.After(st.Dropped[bucket]) {
+		return false
+	}
+	r.tier(bucket).Prune(symbol, before)
+	st.Dropped[bucket] = before
+	r.gen++
+	return true
+}
+
+// Query devolve os agregados compactados que atendem o pedido, na faixa do
+// pedido. Cada período vem do nível mais fino que ainda o cobre e cuja faixa
+// divide a pedida. Filtros de preço ou quantidade não se aplicam a agregados,
+// então pedidos com eles não usam os agregados.
+func (r *Rollups) Query(req aggregate.Request) []aggregate.Partial {
+	q := req.Query
+	if q.MinPrice > 0 || q.MaxPrice > 0 || q.MinQty > 0 || q.MaxQty > 0 {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	buckets := make([]time.Duration, 0, len(r.tiers))
+	for bucket := range r.tiers {
+		if req.Bucket == 0 || req.Bucket%bucket == 0 {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+
+	acc := aggregate.NewAccumulator(req.Bucket)
+	for symbol, st := range r.symbols {
+		if q.Symbol != "" && symbol != q.Symbol {
+			continue
+		}
+		until := st.Compacted
+		if !q.To.IsZero() && q.To.Before(until) {
+			until = q.To
+		}
+		for _, bucket := range buckets {
+			from := st.Dropped[bucket]
+			for _, p := range r.tiers[bucket].Partials() {
+				if p.Symbol == symbol && !p.Start.Before(from) && !p.Start.Before(q.From) && p.Start.Before(until) {
+					acc.Merge(p)
+				}
+			}
+			if from.IsZero() {
+				break
+			}
+			until = from
+		}
+	}
+	return acc.Partials()
+}
+
+// Save grava os agregados de forma atômica (arquivo temporário + rename).
+func (r *Rollups) Save() error {
+	if r.path == "" {
+		return nil
+	}
+	return r.saveTo(r.path)
+}
+
+// Backup grava uma cópia dos agregados em memória no diretório de backup dir.
+// Como a compactação só expira as transações depois de gravar os agregados,
+// uma cópia feita depois do backup do Store cobre tudo o que saiu dele.
+func (r *Rollups) Backup(dir string) error {
+	return r.saveTo(filepath.Join(dir, RollupsFile))
+}
+
+// RestoreRollups copia os agregados do backup em backupDir para dataDir.
+// Devolve false se o backup não tem agregados.
+func RestoreRollups(backupDir, dataDir string) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(backupDir, RollupsFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	var file rollupsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return false, fmt.Errorf("corrupted rollups file in %s: %v", backupDir, err)
+	}
+	return true, os.WriteFile(filepath.Join(dataDir, RollupsFile), data, 0644)
+}
+
+func (r *Rollups) saveTo(path string) error {
+	r.mu.RLock()
+	file := rollupsFile{Symbols: r.symbols}
+	for bucket, acc := range r.tiers {
+		file.Tiers = append(file.Tiers, tierFile{Bucket: bucket, Partials: acc.Partials()})
+	}
+	sort.Slice(file.Tiers, func(i, j int) bool { return file.Tiers[i].Bucket < file.Tiers[j].Bucket })
+	data, err := json.Marshal(file)
+	r.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
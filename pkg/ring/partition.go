@@ -0,0 +1,64 @@
+package ring
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Partition é um shard lógico: o primário recebe as gravações e as réplicas
+// recebem o log dele. O ring distribui as chaves entre os primários.
+type Partition struct {
+	Primary  string
+	Replicas []string
+}
+
+// Nodes lista o primário seguido das réplicas (ordem de preferência para leitura).
+func (p Partition) Nodes() []string {
+	return append([]string{p.Primary}, p.Replicas...)
+}
+
+// ParsePartitions lê a lista de shards no formato
+// "primario|replica1|replica2,primario2|...". Sem "|", o shard não tem réplicas.
+func ParsePartitions(spec string) ([]Partition, error) {
+	var parts []Partition
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		nodes := strings.Split(item, "|")
+		for _, n := range nodes {
+			if n == "" {
+				return nil, fmt.Errorf("empty node in partition %q", item)
+			}
+			if seen[n] {
+				return nil, fmt.Errorf("node %s listed twice", n)
+			}
+			seen[n] = true
+		}
+		parts = append(parts, Partition{Primary: nodes[0], Replicas: nodes[1:]})
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no shards configured")
+	}
+	return parts, nil
+}
+
+// Primaries devolve os primários, que são os nós do ring.
+func Primaries(parts []Partition) []string {
+	primaries := make([]string, len(parts))
+	for i, p := range parts {
+		primaries[i] = p.Primary
+	}
+	return primaries
+}
+
+// FormatPartitions escreve as partições no formato aceito por ParsePartitions.
+func FormatPartitions(parts []Partition) string {
+	items := make([]string, len(parts))
+	for i, p := range parts {
+		items[i] = strings.Join(p.Nodes(), "|")
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,114 @@
+package ring
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes é a quantidade de pontos por nó no anel. Mais pontos
+// espalham melhor a carga, ao custo de memória e tempo de busca.
+const DefaultVirtualNodes = 128
+
+type point struct {
+	hash uint64
+	node string
+}
+
+// Ring é um anel de hash consistente com nós virtuais: cada nó ocupa vários
+// pontos do anel e uma chave pertence ao primeiro ponto no sentido horário.
+// Adicionar ou remover um nó move apenas ~1/N das chaves.
+type Ring struct {
+	mu     sync.RWMutex
+	vnodes int
+	points []point // Ordenados por hash
+	nodes  map[string]bool
+}
+
+func New(vnodes int, nodes ...string) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{vnodes: vnodes, nodes: make(map[string]bool)}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+// Add inclui o nó no anel (sem efeito se ele já estiver presente).
+func (r *Ring) Add(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nodes[node] {
+		return
+	}
+	r.nodes[node] = true
+	for i := 0; i < r.vnodes; i++ {
+		r.points = append(r.points, point{hash: hash(node + "#" + strconv.Itoa(i)), node: node})
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+}
+
+// Remove retira o nó e todos os seus pontos virtuais.
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.nodes[node] {
+		return
+	}
+	delete(r.nodes, node)
+	kept := r.points[:0]
+	for _, p := range r.points {
+		if p.node != node {
+			kept = append(kept, p)
+		}
+	}
+	r.points = kept
+}
+
+// Owner retorna o nó dono da chave, ou "" se o anel estiver vazio.
+func (r *Ring) Owner(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.points) == 0 {
+		return ""
+	}
+	return r.points[r.search(hash(key))].node
+}
+
+// search retorna o índice do primeiro ponto com hash >= h (dando a volta no anel).
+func (r *Ring) search(h uint64) int {
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return i
+}
+
+// Nodes lista os nós do anel em ordem alfabética.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// hash aplica FNV-1a seguido do finalizador do SplitMix64: o FNV sozinho
+// espalha mal chaves quase iguais como "shard#1" e "shard#2".
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
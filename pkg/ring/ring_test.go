@@ -0,0 +1,139 @@
+package ring
+
+import (
+	"distributed-system/pkg/model"
+	"fmt"
+	"testing"
+)
+
+// TestRingBalance valida que as chaves se distribuem de forma razoavelmente uniforme
+func TestRingBalance(t *testing.T) {
+	r := New(DefaultVirtualNodes, "localhost:9001", "localhost:9002", "localhost:9003")
+	counts := make(map[string]int)
+	const keys = 30000
+	for i := 0; i < keys; i++ {
+		counts[r.Owner(fmt.Sprintf("tx-%d", i))]++
+	}
+	for node, n := range counts {
+		share := float64(n) / keys
+		if share < 0.25 || share > 0.42 {
+			t.Errorf("Nó %s recebeu %.1f%% das chaves (esperado ~33%%)", node, share*100)
+		}
+	}
+}
+
+// TestRingMinimalMovement valida que adicionar um nó só move chaves para o nó novo
+func TestRingMinimalMovement(t *testing.T) {
+	r := New(DefaultVirtualNodes, "a", "b", "c")
+	before := make(map[string]string)
+	const keys = 10000
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("k%d", i)
+		before[key] = r.Owner(key)
+	}
+
+	r.Add("d")
+	moved := 0
+	for key, owner := range before {
+		now := r.Owner(key)
+		if now != owner {
+			moved++
+			if now != "d" {
+				t.Fatalf("Chave %s mudou de %s para %s (só deveria ir para o nó novo)", key, owner, now)
+			}
+		}
+	}
+	if share := float64(moved) / keys; share < 0.15 || share > 0.35 {
+		t.Errorf("Esperava ~25%% das chaves movidas, foram %.1f%%", share*100)
+	}
+
+	// Remover o nó devolve exatamente a distribuição anterior
+	r.Remove("d")
+	for key, owner := range before {
+		if r.Owner(key) != owner {
+			t.Fatalf("Chave %s não voltou para %s após remover o nó", key, owner)
+		}
+	}
+}
+
+// TestParsePartitions valida o formato primario|replica,... e os erros de configuração
+func TestParsePartitions(t *testing.T) {
+	parts, err := ParsePartitions("a:1|a:2|a:3, b:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 2 || parts[0].Primary != "a:1" || len(parts[0].Replicas) != 2 || len(parts[1].Replicas) != 0 {
+		t.Fatalf("Partições inesperadas: %+v", parts)
+	}
+	if got := parts[0].Nodes(); got[0] != "a:1" || got[2] != "a:3" {
+		t.Errorf("Ordem de leitura deveria começar pelo primário: %v", got)
+	}
+	if p := Primaries(parts); len(p) != 2 || p[1] != "b:1" {
+		t.Errorf("Primários inesperados: %v", p)
+	}
+
+	for _, bad := range []string{"", "a:1|", "a:1,a:1|b:1"} {
+		if _, err := ParsePartitions(bad); err == nil {
+			t.Errorf("Configuração %q deveria ser rejeitada", bad)
+		}
+	}
+}
+
+// TestRouterMigration valida o roteamento entre Begin e Commit: gravações no
+// dono de destino, leituras nos dois donos e comandos repetidos sem efeito
+func TestRouterMigration(t *testing.T) {
+	r, _ := NewRouter([]string{"a", "b", "c"}, KeyBySymbol)
+	before := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		symbol := fmt.Sprintf("S%d", i)
+		before[symbol] = r.OwnerOf(model.Transaction{Symbol: symbol})
+	}
+
+	status, err := r.Apply(ActionBegin, "a,b,c,d|d2,e")
+	if err != nil || status.Next != "a,b,c,d|d2,e" {
+		t.Fatalf("Begin falhou: %+v %v", status, err)
+	}
+	if _, err := r.Apply(ActionBegin, "a,b,c,d|d2,e"); err != nil {
+		t.Errorf("Begin repetido com o mesmo destino deveria ser aceito: %v", err)
+	}
+	if _, err := r.Apply(ActionBegin, "a,b"); err == nil {
+		t.Error("Begin com outro destino durante a migração deveria falhar")
+	}
+	if p := r.Partition("d"); len(p.Replicas) != 1 {
+		t.Errorf("Partição de destino deveria ter a réplica d2: %+v", p)
+	}
+
+	next, _ := NewRouter([]string{"a", "b", "c", "d", "e"}, KeyBySymbol)
+	moved := 0
+	for symbol, old := range before {
+		owner := next.OwnerOf(model.Transaction{Symbol: symbol})
+		if got := r.OwnerOf(model.Transaction{Symbol: symbol}); got != owner {
+			t.Fatalf("Gravação de %s deveria ir para %s, foi para %s", symbol, owner, got)
+		}
+		shards := r.ShardsFor(symbol)
+		if owner != old {
+			moved++
+			if len(shards) != 2 {
+				t.Fatalf("Leitura de %s deveria consultar %s e %s, consulta %v", symbol, old, owner, shards)
+			}
+		}
+	}
+	if moved == 0 {
+		t.Fatal("Nenhum símbolo mudou de dono")
+	}
+	if got := len(r.Shards()); got != 5 {
+		t.Errorf("Esperava 5 shards durante a migração, há %d", got)
+	}
+
+	if _, err := r.Apply(ActionCommit, "a,b,c,d|d2,e"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Apply(ActionCommit, "a,b,c,d|d2,e"); err != nil {
+		t.Errorf("Commit repetido deveria ser aceito: %v", err)
+	}
+	for symbol := range before {
+		if shards := r.ShardsFor(symbol); len(shards) != 1 {
+			t.Fatalf("Depois do commit, %s deveria ter um único dono: %v", symbol, shards)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package ring
+
+import (
+	"distributed-system/pkg/model"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Chaves de particionamento suportadas pelo Router.
+const (
+	KeyBySymbol = "symbol" // Todas as transações de um símbolo no mesmo shard
+	KeyByID     = "id"     // Transações espalhadas por ID (melhor balanceamento)
+)
+
+// Ações de administração da topologia (MsgAdminTopology).
+const (
+	ActionShow   = "show"
+	ActionBegin  = "begin"
+	ActionCommit = "commit"
+)
+
+// Status descreve a topologia de um Router no formato de ParsePartitions.
+type Status struct {
+	Shards string `json:"shards"`
+	Next   string `json:"next,omitempty"` // Destino da migração em andamento
+}
+
+// Router decide o shard dono de cada transação. Escritores e leitores precisam
+// usar a mesma lista de shards e a mesma chave para concordarem sobre o dono.
+//
+// A topologia pode mudar em execução: entre Begin e Commit o Router conhece a
+// topologia atual e a de destino. Gravações já vão para o dono de destino e
+// leituras consultam os dois donos, enquanto os dados antigos são copiados.
+type Router struct {
+	mu    sync.RWMutex
+	keyBy string
+	ring  *Ring
+	parts []Partition
+	next  *Ring       // Anel de destino durante uma migração (nil fora dela)
+	nextP []Partition // Partições de destino
+}
+
+func NewRouter(shards []string, keyBy string) (*Router, error) {
+	parts := make([]Partition, len(shards))
+	for i, shard := range shards {
+		parts[i] = Partition{Primary: shard}
+	}
+	return NewPartitionedRouter(parts, keyBy)
+}
+
+// NewPartitionedRouter cria um Router cujos nós são os primários das partições.
+func NewPartitionedRouter(parts []Partition, keyBy string) (*Router, error) {
+	if keyBy != KeyBySymbol && keyBy != KeyByID {
+		return nil, fmt.Errorf("unknown routing key %q (use %q or %q)", keyBy, KeyBySymbol, KeyByID)
+	}
+	return &Router{ring: New(DefaultVirtualNodes, Primaries(parts)...), parts: parts, keyBy: keyBy}, nil
+}
+
+func (r *Router) key(tx model.Transaction) string {
+	if r.keyBy == KeyBySymbol {
+		return tx.Symbol
+	}
+	return tx.ID
+}
+
+// OwnerOf retorna o shard onde a transação deve ser gravada. Durante uma
+// migração é o dono na topologia de destino.
+func (r *Router) OwnerOf(tx model.Transaction) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.next != nil {
+		return r.next.Owner(r.key(tx))
+	}
+	return r.ring.Owner(r.key(tx))
+}
+
+// ShardsFor retorna os shards que podem ter transações do símbolo: apenas o
+// dono quando particionamos por símbolo, ou todos quando por ID. Símbolo vazio
+// significa todos os shards. Durante uma migração inclui os donos das duas
+// topologias.
+func (r *Router) ShardsFor(symbol string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if symbol == "" || r.keyBy != KeyBySymbol {
+		return r.shardsLocked()
+	}
+	owners := []string{r.ring.Owner(symbol)}
+	if r.next != nil {
+		if owner := r.next.Owner(symbol); owner != owners[0] {
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// Shards lista todos os shards conhecidos (das duas topologias durante uma migração).
+func (r *Router) Shards() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.shardsLocked()
+}
+
+func (r *Router) shardsLocked() []string {
+	if r.next == nil {
+		return r.ring.Nodes()
+	}
+	seen := make(map[string]bool)
+	var shards []string
+	for _, node := range append(r.ring.Nodes(), r.next.Nodes()...) {
+		if !seen[node] {
+			seen[node] = true
+			shards = append(shards, node)
+		}
+	}
+	sort.Strings(shards)
+	return shards
+}
+
+// Partition devolve a partição cujo primário é primary, procurando primeiro
+// na topologia de destino (que pode ter acrescentado réplicas).
+func (r *Router) Partition(primary string) Partition {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, parts := range [][]Partition{r.nextP, r.parts} {
+		for _, p := range parts {
+			if p.Primary == primary {
+				return p
+			}
+		}
+	}
+	return Partition{Primary: primary}
+}
+
+// Topology devolve as partições atuais e as de destino (nil fora de uma migração).
+func (r *Router) Topology() (current, next []Partition) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.parts, r.nextP
+}
+
+// Begin inicia a migração para as partições next. Repetir Begin com o mesmo
+// destino não tem efeito (retomada de uma migração interrompida).
+func (r *Router) Begin(next []Partition) error {
+	if len(next) == 0 {
+		return fmt.Errorf("empty target topology")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next != nil {
+		if FormatPartitions(r.nextP) == FormatPartitions(next) {
+			return nil
+		}
+		return fmt.Errorf("migration to %s already in progress", FormatPartitions(r.nextP))
+	}
+	r.next = New(DefaultVirtualNodes, Primaries(next)...)
+	r.nextP = next
+	return nil
+}
+
+// Commit encerra a migração: a topologia de destino passa a ser a única.
+// Sem migração em andamento, next igual à topologia atual é aceito (retomada
+// depois de um Commit que já tinha sido aplicado).
+func (r *Router) Commit(next []Partition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next == nil {
+		if FormatPartitions(r.parts) == FormatPartitions(next) {
+			return nil
+		}
+		return fmt.Errorf("no migration in progress")
+	}
+	if FormatPartitions(r.nextP) != FormatPartitions(next) {
+		return fmt.Errorf("migration in progress targets %s", FormatPartitions(r.nextP))
+	}
+	r.ring, r.parts = r.next, r.nextP
+	r.next, r.nextP = nil, nil
+	return nil
+}
+
+// Apply executa um comando de administração da topologia (shards no formato
+// de ParsePartitions) e devolve o estado resultante.
+func (r *Router) Apply(action, shards string) (Status, error) {
+	switch action {
+	case ActionShow:
+	case ActionBegin, ActionCommit:
+		next, err := ParsePartitions(shards)
+		if err != nil {
+			return Status{}, err
+		}
+		if action == ActionBegin {
+			err = r.Begin(next)
+		} else {
+			err = r.Commit(next)
+		}
+		if err != nil {
+			return Status{}, err
+		}
+	default:
+		return Status{}, fmt.Errorf("unknown topology action %q", action)
+	}
+	current, next := r.Topology()
+	return Status{Shards: FormatPartitions(current), Next: FormatPartitions(next)}, nil
+}
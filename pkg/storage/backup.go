@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// backupManifest é gravado por último: um backup sem ele está incompleto.
+const backupManifest = "backup.json"
+
+// BackupInfo descreve um backup. Ele contém o snapshot do Store (BaseLSN) e
+// as entradas do log gravadas depois dele até LSN; qualquer ponto nesse
+// intervalo pode ser restaurado.
+type BackupInfo struct {
+	Dir      string    `json:"dir"`
+	BaseLSN  uint64    `json:"base_lsn"`  // Posição coberta pelo snapshot (0 = sem snapshot)
+	BaseTime time.Time `json:"base_time"` // Instante do snapshot (zero = sem snapshot)
+	LSN      uint64    `json:"lsn"`       // Última posição incluída
+	Entries  int       `json:"entries"`   // Entradas do log depois do snapshot
+	Time     time.Time `json:"time"`      // Instante do backup
+	// Expired são os símbolos com transações expiradas pela retenção: o
+	// histórico compactado deles está nos agregados do shard, fora do Store.
+	Expired []string `json:"expired,omitempty"`
+}
+
+// RestorePoint escolhe até onde o log é reaplicado: até a posição LSN e/ou
+// até as entradas gravadas no instante Time. Zerado, restaura o backup inteiro.
+type RestorePoint struct {
+	LSN  uint64
+	Time time.Time
+}
+
+// RestoreInfo descreve o estado restaurado.
+type RestoreInfo struct {
+	LSN          uint64 `json:"lsn"`          // Última posição aplicada
+	Replayed     int    `json:"replayed"`     // Entradas do log reaplicadas sobre o snapshot
+	Transactions int    `json:"transactions"` // Transações no Store restaurado
+}
+
+// Backup copia o snapshot e o log do DiskStore. As gravações só ficam
+// bloqueadas enquanto a posição atual do log é lida; a cópia é feita com elas
+// em andamento (o log só cresce, e snapshots esperam o fim do backup).
+func (d *DiskStore) Backup(dir string) (BackupInfo, error) {
+	if err := requireEmpty(dir); err != nil {
+		return BackupInfo{}, err
+	}
+	d.files.Lock()
+	defer d.files.Unlock()
+
+	d.mu.Lock()
+	lsn := d.mem.LastLSN()
+	size, err := d.wal.size()
+	d.mu.Unlock()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	err = copyFileSync(filepath.Join(dir, snapshotFile), filepath.Join(d.dir, snapshotFile), -1)
+	if err != nil && !os.IsNotExist(err) {
+		return BackupInfo{}, err
+	}
+	if err := copyFileSync(filepath.Join(dir, walFile), filepath.Join(d.dir, walFile), size); err != nil {
+		return BackupInfo{}, err
+	}
+	return finishBackup(dir, lsn)
+}
+
+// Backup grava o conteúdo atual como snapshot, sem log: só a posição atual
+// pode ser restaurada.
+func (m *MemStore) Backup(dir string) (BackupInfo, error) {
+	if err := requireEmpty(dir); err != nil {
+		return BackupInfo{}, err
+	}
+	m.mu.RLock()
+	txs, lsns := m.liveLocked()
+	snap := snapshot{LSN: m.lsn, Time: time.Now().UnixNano(), Transactions: txs, LSNs: lsns, Deleted: m.tombstonesLocked(), Pruned: m.pruned}
+	m.mu.RUnlock()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if err := writeFileSync(filepath.Join(dir, snapshotFile), data); err != nil {
+		return BackupInfo{}, err
+	}
+	return finishBackup(dir, snap.LSN)
+}
+
+// finishBackup confere os arquivos copiados para dir e grava o manifesto.
+func finishBackup(dir string, lsn uint64) (BackupInfo, error) {
+	base, entries, err := readBackup(dir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	info := BackupInfo{Dir: dir, BaseLSN: base.LSN, LSN: base.LSN, Entries: len(entries), Time: time.Now().UTC()}
+	if base.Time != 0 {
+		info.BaseTime = time.Unix(0, base.Time).UTC()
+	}
+	if n := len(entries); n > 0 {
+		info.LSN = entries[n-1].LSN
+	}
+	expired := make(map[string]bool)
+	for _, e := range append(base.Deleted, entries...) {
+		if e.Op == OpExpire && !expired[e.Tx.Symbol] {
+			expired[e.Tx.Symbol] = true
+			info.Expired = append(info.Expired, e.Tx.Symbol)
+		}
+	}
+	sort.Strings(info.Expired)
+	if info.LSN != lsn {
+		return info, fmt.Errorf("backup in %s ends at LSN %d, expected %d", dir, info.LSN, lsn)
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return info, err
+	}
+	return info, writeFileSync(filepath.Join(dir, backupManifest), data)
+}
+
+// readBackup lê o snapshot e as entradas do log posteriores a ele.
+func readBackup(dir string) (snapshot, []Entry, error) {
+	base, _, err := readSnapshot(dir)
+	if err != nil {
+		return base, nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, walFile))
+	if os.IsNotExist(err) {
+		return base, nil, nil
+	} else if err != nil {
+		return base, nil, err
+	}
+	defer f.Close()
+	all, _, err := readEntries(f)
+	if err != nil {
+		return base, nil, err
+	}
+	entries := all[:0]
+	for _, e := range all {
+		if e.LSN > base.LSN { // Entradas já cobertas pelo snapshot (crash antes da limpeza do log)
+			entries = append(entries, e)
+		}
+	}
+	return base, entries, nil
+}
+
+// Restore recria em dataDir, que precisa estar vazio, o Store do backup em
+// backupDir no ponto pedido. O resultado é um snapshot sem log, pronto para
+// ser aberto com Open.
+func Restore(backupDir, dataDir string, point RestorePoint) (RestoreInfo, error) {
+	data, err := os.ReadFile(filepath.Join(backupDir, backupManifest))
+	if os.IsNotExist(err) {
+		return RestoreInfo{}, fmt.Errorf("%s is not a complete backup", backupDir)
+	} else if err != nil {
+		return RestoreInfo{}, err
+	}
+	var info BackupInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return RestoreInfo{}, fmt.Errorf("corrupted backup manifest in %s: %v", backupDir, err)
+	}
+	if point.LSN != 0 && (point.LSN < info.BaseLSN || point.LSN > info.LSN) {
+		return RestoreInfo{}, fmt.Errorf("LSN %d is outside the backup (LSN %d to %d)", point.LSN, info.BaseLSN, info.LSN)
+	}
+	if !point.Time.IsZero() && (point.Time.Before(info.BaseTime) || point.Time.After(info.Time)) {
+		return RestoreInfo{}, fmt.Errorf("time %s is outside the backup (%s to %s)",
+			point.Time.Format(time.RFC3339), info.BaseTime.Format(time.RFC3339), info.Time.Format(time.RFC3339))
+	}
+	base, entries, err := readBackup(backupDir)
+	if err != nil {
+		return RestoreInfo{}, err
+	}
+	if err := requireEmpty(dataDir); err != nil {
+		return RestoreInfo{}, err
+	}
+
+	d, err := Open(dataDir, Options{Sync: SyncNone})
+	if err != nil {
+		return RestoreInfo{}, err
+	}
+	d.mem.loadSnapshot(base)
+	res := RestoreInfo{LSN: base.LSN}
+	for _, e := range entries {
+		if point.LSN != 0 && e.LSN > point.LSN {
+			break
+		}
+		if !point.Time.IsZero() && e.Time != 0 && time.Unix(0, e.Time).After(point.Time) {
+			break
+		}
+		d.mem.apply(e)
+		res.LSN = e.LSN
+		res.Replayed++
+	}
+	res.Transactions = d.Len()
+	if err := d.Snapshot(); err != nil {
+		d.Close()
+		return res, err
+	}
+	return res, d.Close()
+}
+
+// requireEmpty cria dir se preciso e recusa diretórios com arquivos, para
+// que um backup ou uma restauração nunca sobrescreva dados.
+func requireEmpty(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	return nil
+}
+
+// copyFileSync copia os primeiros n bytes de src (todos se n < 0) para dst, com fsync.
+func copyFileSync(dst, src string, n int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var r io.Reader = in
+	if n >= 0 {
+		r = io.LimitReader(in, n)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,333 @@
+package storage
+
+import (
+	"distributed-system/pkg/merkle"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+)
+
+// Options configura a durabilidade de um DiskStore.
+type Options struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration // Usado com SyncInterval
+}
+
+// Recovery descreve o que foi recuperado ao abrir um DiskStore.
+type Recovery struct {
+	SnapshotLSN    uint64 // Posição coberta pelo snapshot carregado (0 = sem snapshot)
+	Replayed       int    // Entradas do log reaplicadas sobre o snapshot
+	TruncatedBytes int64  // Bytes descartados de uma cauda corrompida do log
+}
+
+type snapshot struct {
+	LSN          uint64              `json:"lsn"`
+	Time         int64               `json:"time,omitempty"` // Instante do snapshot (Unix nanos)
+	Transactions []model.Transaction `json:"transactions"`
+	LSNs         []uint64            `json:"lsns"`              // LSN de cada transação
+	Deleted      []Entry             `json:"deleted,omitempty"` // Remoções e expirações ainda visíveis para réplicas
+	Pruned       uint64              `json:"pruned,omitempty"`  // Maior LSN de uma remoção já descartada
+}
+
+// DiskStore é um Store durável: toda gravação vai primeiro para o
+// write-ahead log e só então para a memória. Na abertura, o último snapshot é
+// carregado e as entradas do log posteriores a ele são reaplicadas.
+type DiskStore struct {
+	mu       sync.Mutex // Serializa gravações e snapshots (a ordem do log é a ordem do LSN)
+	files    sync.Mutex // Impede que um snapshot troque os arquivos durante um backup
+	dir      string
+	mem      *MemStore
+	wal      *wal
+	recovery Recovery
+}
+
+// Open abre (ou cria) o armazenamento em dir.
+func Open(dir string, opts Options) (*DiskStore, error) {
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	d := &DiskStore{dir: dir, mem: NewMemStore()}
+
+	snap, found, err := readSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		d.mem.loadSnapshot(snap)
+		d.recovery.SnapshotLSN = snap.LSN
+	}
+
+	w, entries, truncated, err := openWAL(filepath.Join(dir, walFile), opts.Sync, opts.SyncInterval)
+	if err != nil {
+		return nil, err
+	}
+	d.wal = w
+	d.recovery.TruncatedBytes = truncated
+
+	// Entradas já cobertas pelo snapshot (crash entre o snapshot e a limpeza do log) são ignoradas
+	for _, e := range entries {
+		if e.LSN <= d.mem.lsn {
+			continue
+		}
+		d.mem.apply(e)
+		d.recovery.Replayed++
+	}
+	return d, nil
+}
+
+// readSnapshot lê o snapshot de dir, se existir.
+func readSnapshot(dir string) (snapshot, bool, error) {
+	var snap snapshot
+	data, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return snap, false, nil
+	} else if err != nil {
+		return snap, false, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, false, fmt.Errorf("corrupted snapshot in %s: %v", dir, err)
+	}
+	if len(snap.LSNs) != len(snap.Transactions) {
+		return snap, false, fmt.Errorf("corrupted snapshot in %s: %d transactions but %d positions", dir, len(snap.Transactions), len(snap.LSNs))
+	}
+	return snap, true, nil
+}
+
+// Recovery informa o que foi recuperado na abertura.
+func (d *DiskStore) Recovery() Recovery {
+	return d.recovery
+}
+
+func (d *DiskStore) Put(tx model.Transaction) (PutResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Gravações que não mudam nada não vão para o log
+	d.mem.mu.RLock()
+	res, rejected := d.mem.rejectLocked(tx)
+	d.mem.mu.RUnlock()
+	if rejected {
+		return res, nil
+	}
+
+	e := Entry{LSN: d.mem.LastLSN() + 1, Op: OpPut, Tx: tx, Time: time.Now().UnixNano()}
+	if err := d.wal.append(e); err != nil {
+		return PutResult{}, err
+	}
+	return d.mem.apply(e).PutResult, nil
+}
+
+func (d *DiskStore) Delete(id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.mem.Get(id); !ok {
+		return false, nil
+	}
+	now := time.Now()
+	e := Entry{LSN: d.mem.LastLSN() + 1, Op: OpDelete, Tx: model.Transaction{ID: id, Timestamp: now}, Time: now.UnixNano()}
+	if err := d.wal.append(e); err != nil {
+		return false, err
+	}
+	d.mem.apply(e)
+	return true, nil
+}
+
+func (d *DiskStore) Tombstone(t Tombstone) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.mem.mu.RLock()
+	applies := d.mem.tombstoneAppliesLocked(t.ID, t.Version)
+	d.mem.mu.RUnlock()
+	if !applies {
+		return false, nil
+	}
+	e := tombstoneEntry(d.mem.LastLSN()+1, t)
+	e.Time = time.Now().UnixNano()
+	if err := d.wal.append(e); err != nil {
+		return false, err
+	}
+	d.mem.apply(e)
+	return true, nil
+}
+
+func (d *DiskStore) Tombstones(fn func(t Tombstone) bool) {
+	d.mem.Tombstones(fn)
+}
+
+// PruneTombstones descarta as remoções só na memória, sem entrada no log: o
+// descarte é determinístico pela idade, e o próximo snapshot o torna durável.
+// Depois de um restart, as remoções ainda no log voltam e saem de novo na
+// próxima rodada.
+func (d *DiskStore) PruneTombstones(before time.Time) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mem.PruneTombstones(before)
+}
+
+func (d *DiskStore) PrunedLSN() uint64 {
+	return d.mem.PrunedLSN()
+}
+
+func (d *DiskStore) Expire(symbol string, before time.Time) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !before.After(d.mem.Horizon(symbol)) {
+		return 0, nil
+	}
+	e := Entry{LSN: d.mem.LastLSN() + 1, Op: OpExpire, Tx: model.Transaction{Symbol: symbol, Timestamp: before}, Time: time.Now().UnixNano()}
+	if err := d.wal.append(e); err != nil {
+		return 0, err
+	}
+	return d.mem.apply(e).expired, nil
+}
+
+func (d *DiskStore) Horizon(symbol string) time.Time {
+	return d.mem.Horizon(symbol)
+}
+
+func (d *DiskStore) Horizons() map[string]time.Time {
+	return d.mem.Horizons()
+}
+
+func (d *DiskStore) Symbols() []string {
+	return d.mem.Symbols()
+}
+
+func (d *DiskStore) Apply(e Entry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e.LSN <= d.mem.LastLSN() {
+		return nil
+	}
+	e.Time = time.Now().UnixNano()
+	if err := d.wal.append(e); err != nil {
+		return err
+	}
+	d.mem.apply(e)
+	return nil
+}
+
+// Snapshot grava o estado atual em disco e descarta o log já coberto por ele.
+// Gravações ficam bloqueadas enquanto o snapshot é escrito.
+func (d *DiskStore) Snapshot() error {
+	d.files.Lock()
+	defer d.files.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.mem.mu.RLock()
+	txs, lsns := d.mem.liveLocked()
+	snap := snapshot{LSN: d.mem.lsn, Time: time.Now().UnixNano(), Transactions: txs, LSNs: lsns, Deleted: d.mem.tombstonesLocked(), Pruned: d.mem.pruned}
+	data, err := json.Marshal(snap)
+	d.mem.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(d.dir, snapshotFile), data); err != nil {
+		return err
+	}
+	return d.wal.reset()
+}
+
+// Reset esvazia a memória e troca os arquivos por um snapshot vazio, sem log.
+func (d *DiskStore) Reset() error {
+	d.files.Lock()
+	defer d.files.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	data, err := json.Marshal(snapshot{Time: time.Now().UnixNano(), Transactions: []model.Transaction{}, LSNs: []uint64{}})
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(filepath.Join(d.dir, snapshotFile), data); err != nil {
+		return err
+	}
+	if err := d.wal.reset(); err != nil {
+		return err
+	}
+	d.mem.load(0, nil, nil, nil)
+	return nil
+}
+
+func (d *DiskStore) Get(id string) (model.Transaction, bool) {
+	return d.mem.Get(id)
+}
+
+func (d *DiskStore) Query(q query.Query) (query.Page, error) {
+	return d.mem.Query(q)
+}
+
+func (d *DiskStore) Since(lsn uint64, max int) []Entry {
+	return d.mem.Since(lsn, max)
+}
+
+func (d *DiskStore) Scan(fn func(tx model.Transaction) bool) {
+	d.mem.Scan(fn)
+}
+
+func (d *DiskStore) Len() int {
+	return d.mem.Len()
+}
+
+func (d *DiskStore) LastLSN() uint64 {
+	return d.mem.LastLSN()
+}
+
+func (d *DiskStore) Tree() *merkle.Tree {
+	return d.mem.Tree()
+}
+
+func (d *DiskStore) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.wal.close()
+}
+
+// writeFileSync substitui path de forma atômica: arquivo temporário, fsync e rename.
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Garante que o rename em si sobreviva a um crash
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
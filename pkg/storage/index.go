@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"sort"
+)
+
+// index mantém, sobre a lista de transações de um MemStore:
+//   - byID: posição de cada transação pelo ID (busca O(1));
+//   - byKey: posição de cada transação pela chave de idempotência, se houver;
+//   - bySymbol: posições de cada símbolo ordenadas por (Timestamp, ID), para
+//     que consultas por símbolo e período façam busca binária em vez de
+//     percorrer o shard inteiro.
+//
+// Transações chegam quase sempre em ordem de tempo, então a inserção no
+// índice por símbolo costuma ser um append.
+type index struct {
+	byID     map[string]int
+	byKey    map[string]int
+	bySymbol map[string][]int
+}
+
+func newIndex() *index {
+	return &index{byID: make(map[string]int), byKey: make(map[string]int), bySymbol: make(map[string][]int)}
+}
+
+// add indexa txs[pos].
+func (ix *index) add(txs []model.Transaction, pos int) {
+	tx := txs[pos]
+	ix.byID[tx.ID] = pos
+	if tx.IdempotencyKey != "" {
+		ix.byKey[tx.IdempotencyKey] = pos
+	}
+
+	positions := ix.bySymbol[tx.Symbol]
+	n := len(positions)
+	if n == 0 || query.Compare(txs[positions[n-1]], tx) <= 0 {
+		ix.bySymbol[tx.Symbol] = append(positions, pos)
+		return
+	}
+	i := sort.Search(n, func(i int) bool { return query.Compare(txs[positions[i]], tx) > 0 })
+	positions = append(positions, 0)
+	copy(positions[i+1:], positions[i:])
+	positions[i] = pos
+	ix.bySymbol[tx.Symbol] = positions
+}
+
+// remove tira txs[pos] do índice (versão substituída).
+func (ix *index) remove(txs []model.Transaction, pos int) {
+	tx := txs[pos]
+	if ix.byID[tx.ID] == pos {
+		delete(ix.byID, tx.ID)
+	}
+	if kpos, ok := ix.byKey[tx.IdempotencyKey]; ok && kpos == pos {
+		delete(ix.byKey, tx.IdempotencyKey)
+	}
+	positions := ix.bySymbol[tx.Symbol]
+	i := sort.Search(len(positions), func(i int) bool { return query.Compare(txs[positions[i]], tx) >= 0 })
+	for ; i < len(positions); i++ {
+		if positions[i] == pos {
+			ix.bySymbol[tx.Symbol] = append(positions[:i], positions[i+1:]...)
+			return
+		}
+	}
+}
+
+// rebuild reconstrói o índice inteiro sobre os slots vivos (recuperação a partir de snapshot).
+func (ix *index) rebuild(txs []model.Transaction, dead []bool) {
+	ix.byID = make(map[string]int, len(txs))
+	ix.byKey = make(map[string]int)
+	ix.bySymbol = make(map[string][]int)
+	for pos := range txs {
+		if !dead[pos] {
+			ix.add(txs, pos)
+		}
+	}
+}
+
+// query avalia uma consulta já normalizada e com símbolo usando o índice por símbolo.
+func (ix *index) query(txs []model.Transaction, q query.Query) query.Page {
+	positions := ix.bySymbol[q.Symbol]
+	at := func(i int) model.Transaction { return txs[positions[i]] }
+	n := len(positions)
+
+	// Faixa [lo, hi) de posições dentro do período pedido
+	lo, hi := 0, n
+	if !q.From.IsZero() {
+		lo = sort.Search(n, func(i int) bool { return !at(i).Timestamp.Before(q.From) })
+	}
+	if !q.To.IsZero() {
+		hi = sort.Search(n, func(i int) bool { return !at(i).Timestamp.Before(q.To) })
+	}
+	if q.Cursor != "" {
+		c, _ := query.DecodeCursor(q.Cursor)
+		mark := model.Transaction{Timestamp: c.Timestamp, ID: c.ID}
+		if q.Order == query.OrderDesc {
+			if i := sort.Search(n, func(i int) bool { return query.Compare(at(i), mark) >= 0 }); i < hi {
+				hi = i
+			}
+		} else {
+			if i := sort.Search(n, func(i int) bool { return query.Compare(at(i), mark) > 0 }); i > lo {
+				lo = i
+			}
+		}
+	}
+
+	// Percorre a faixa na ordem pedida até completar a página (+1 para saber se há mais)
+	matched := make([]model.Transaction, 0, q.Limit+1)
+	for k := 0; k < hi-lo && len(matched) <= q.Limit; k++ {
+		i := lo + k
+		if q.Order == query.OrderDesc {
+			i = hi - 1 - k
+		}
+		if tx := at(i); q.Matches(tx) {
+			matched = append(matched, tx)
+		}
+	}
+	return query.Paginate(matched, q.Limit)
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+var symbols = []string{"PETR4", "VALE3", "ITUB4", "BBDC4", "ABEV3", "WEGE3", "MGLU3", "BBAS3"}
+
+// randomTxs gera n transações com timestamps quase em ordem (alguns atrasados)
+// e colisões de timestamp, para exercitar a inserção fora de ordem no índice.
+func randomTxs(n int, seed int64) []model.Transaction {
+	rng := rand.New(rand.NewSource(seed))
+	start := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	txs := make([]model.Transaction, n)
+	for i := range txs {
+		ts := start.Add(time.Duration(i) * time.Second)
+		if rng.Intn(10) == 0 {
+			ts = ts.Add(-time.Duration(rng.Intn(600)) * time.Second)
+		}
+		txs[i] = model.Transaction{
+			ID:        fmt.Sprintf("tx-%08d", i),
+			Symbol:    symbols[rng.Intn(len(symbols))],
+			Price:     10 + rng.Float64()*40,
+			Quantity:  100 * (1 + rng.Intn(10)),
+			Timestamp: ts,
+		}
+	}
+	return txs
+}
+
+func fill(s Store, txs []model.Transaction) {
+	for _, tx := range txs {
+		s.Put(tx)
+	}
+}
+
+// allPages percorre todas as páginas de q.
+func allPages(t testing.TB, run func(query.Query) (query.Page, error), q query.Query) []string {
+	var ids []string
+	for {
+		page, err := run(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tx := range page.Transactions {
+			ids = append(ids, tx.ID)
+		}
+		if page.NextCursor == "" {
+			return ids
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestIndexedQueryMatchesFullScan(t *testing.T) {
+	txs := randomTxs(5000, 1)
+	s := NewMemStore()
+	fill(s, txs)
+	scan := func(q query.Query) (query.Page, error) { return query.Run(s.Scan, q) }
+
+	from := txs[1000].Timestamp
+	queries := []query.Query{
+		{Symbol: "PETR4"},
+		{Symbol: "VALE3", Order: query.OrderDesc, Limit: 37},
+		{Symbol: "ITUB4", From: from, To: from.Add(30 * time.Minute), Limit: 10},
+		{Symbol: "BBDC4", From: from, MinPrice: 20, MaxPrice: 30, MinQty: 300, Order: query.OrderDesc, Limit: 7},
+		{Symbol: "NONE"},
+	}
+	for _, q := range queries {
+		indexed := allPages(t, s.Query, q)
+		scanned := allPages(t, scan, q)
+		if fmt.Sprint(indexed) != fmt.Sprint(scanned) {
+			t.Errorf("Consulta %+v: resultado pelo índice difere da varredura completa (%d vs %d registros)", q, len(indexed), len(scanned))
+		}
+	}
+}
+
+func TestIndexGetAndRebuildOnRecovery(t *testing.T) {
+	txs := randomTxs(500, 2)
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill(s, txs[:300])
+	s.Snapshot()
+	fill(s, txs[300:])
+	before := allPages(t, s.Query, query.Query{Symbol: "PETR4"})
+	s.Close()
+
+	// Índices reconstruídos a partir do snapshot + log
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, i := range []int{0, 299, 300, 499} {
+		got, ok := s.Get(txs[i].ID)
+		if !ok || got.Symbol != txs[i].Symbol || got.Price != txs[i].Price {
+			t.Errorf("Esperava %s após a recuperação, recebido %+v (%v)", txs[i].ID, got, ok)
+		}
+	}
+	if _, ok := s.Get("missing"); ok {
+		t.Error("Esperava que um ID desconhecido não fosse encontrado")
+	}
+	if after := allPages(t, s.Query, query.Query{Symbol: "PETR4"}); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Errorf("Esperava que o índice por símbolo sobrevivesse à recuperação")
+	}
+}
+
+// Benchmarks sobre 2 milhões de transações (carregadas uma vez).
+const benchSize = 2000000
+
+var (
+	benchOnce  sync.Once
+	benchStore *MemStore
+	benchTxs   []model.Transaction
+)
+
+func benchData(b *testing.B) (*MemStore, []model.Transaction) {
+	benchOnce.Do(func() {
+		benchTxs = randomTxs(benchSize, 42)
+		benchStore = NewMemStore()
+		fill(benchStore, benchTxs)
+	})
+	b.ResetTimer()
+	return benchStore, benchTxs
+}
+
+func BenchmarkPut(b *testing.B) {
+	txs := randomTxs(b.N, 7)
+	s := NewMemStore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Put(txs[i])
+	}
+}
+
+func BenchmarkGetByID(b *testing.B) {
+	s, txs := benchData(b)
+	for i := 0; i < b.N; i++ {
+		if _, ok := s.Get(txs[(i*7919)%len(txs)].ID); !ok {
+			b.Fatal("missing transaction")
+		}
+	}
+}
+
+// Última hora de um símbolo, mais recentes primeiro: o caso típico do relatório.
+func BenchmarkQuerySymbolRangeIndexed(b *testing.B) {
+	s, txs := benchData(b)
+	q := query.Query{Symbol: "PETR4", From: txs[len(txs)-1].Timestamp.Add(-time.Hour), Order: query.OrderDesc, Limit: 100}
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Query(q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// A mesma consulta sem índice, percorrendo o shard inteiro.
+func BenchmarkQuerySymbolRangeScan(b *testing.B) {
+	s, txs := benchData(b)
+	q := query.Query{Symbol: "PETR4", From: txs[len(txs)-1].Timestamp.Add(-time.Hour), Order: query.OrderDesc, Limit: 100}
+	for i := 0; i < b.N; i++ {
+		if _, err := query.Run(s.Scan, q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRebuildIndex(b *testing.B) {
+	_, txs := benchData(b)
+	ix := newIndex()
+	dead := make([]bool, len(txs))
+	for i := 0; i < b.N; i++ {
+		ix.rebuild(txs, dead)
+	}
+}
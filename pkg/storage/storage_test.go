@@ -0,0 +1,694 @@
+package storage
+
+import (
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeTx(i int) model.Transaction {
+	return model.Transaction{
+		ID:        fmt.Sprintf("tx-%d", i),
+		Symbol:    "PETR4",
+		Price:     20 + float64(i),
+		Quantity:  100,
+		Timestamp: time.Unix(int64(1700000000+i), 0).UTC(),
+	}
+}
+
+func ids(s Store) []string {
+	var out []string
+	s.Scan(func(tx model.Transaction) bool {
+		out = append(out, tx.ID)
+		return true
+	})
+	return out
+}
+
+func TestDiskStoreRecoversFromLog(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := s.Put(makeTx(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	s, err = Open(dir, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := ids(s); len(got) != 5 || got[0] != "tx-0" || got[4] != "tx-4" {
+		t.Fatalf("Esperava 5 transações em ordem após o restart, recebido %v", got)
+	}
+	if s.LastLSN() != 5 || s.Recovery().Replayed != 5 {
+		t.Errorf("Esperava LSN 5 com 5 entradas reaplicadas, recebido %d / %+v", s.LastLSN(), s.Recovery())
+	}
+}
+
+func TestDiskStoreSnapshotPlusLog(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncInterval, SyncInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s.Put(makeTx(i))
+	}
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 3; i < 5; i++ {
+		s.Put(makeTx(i))
+	}
+	s.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rec := s.Recovery()
+	if rec.SnapshotLSN != 3 || rec.Replayed != 2 {
+		t.Errorf("Esperava snapshot no LSN 3 mais 2 entradas reaplicadas, recebido %+v", rec)
+	}
+	if got := ids(s); len(got) != 5 || got[3] != "tx-3" {
+		t.Fatalf("Esperava 5 transações, recebido %v", got)
+	}
+
+	// Novas gravações continuam a numeração
+	if res, _ := s.Put(makeTx(5)); res.LSN != 6 {
+		t.Errorf("Esperava o próximo LSN 6, recebido %d", res.LSN)
+	}
+}
+
+func TestDiskStoreTruncatesCorruptedTail(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s.Put(makeTx(i))
+	}
+	s.Close()
+
+	// Simula um crash no meio de uma gravação: cabeçalho de um registro sem o payload completo
+	path := filepath.Join(dir, walFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 50, 1, 2, 3, 4, '{', '"'})
+	f.Close()
+	before, _ := os.Stat(path)
+
+	s, err = Open(dir, Options{Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(s); len(got) != 3 {
+		t.Fatalf("Esperava que os 3 registros completos sobrevivessem, recebido %v", got)
+	}
+	if s.Recovery().TruncatedBytes != 10 {
+		t.Errorf("Esperava 10 bytes truncados, recebido %d", s.Recovery().TruncatedBytes)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() != before.Size()-10 {
+		t.Errorf("Esperava o log truncado em disco")
+	}
+
+	// O log continua utilizável depois do truncamento
+	s.Put(makeTx(3))
+	s.Close()
+	s, _ = Open(dir, Options{})
+	defer s.Close()
+	if got := ids(s); len(got) != 4 || got[3] != "tx-3" {
+		t.Errorf("Esperava recuperar as gravações feitas após o truncamento, recebido %v", got)
+	}
+}
+
+func TestDiskStoreStopsAtBadChecksum(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := Open(dir, Options{Sync: SyncAlways})
+	for i := 0; i < 3; i++ {
+		s.Put(makeTx(i))
+	}
+	s.Close()
+
+	// Corrompe o último byte do log (payload do terceiro registro)
+	path := filepath.Join(dir, walFile)
+	data, _ := os.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	os.WriteFile(path, data, 0644)
+
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := ids(s); len(got) != 2 {
+		t.Errorf("Esperava apenas os 2 registros íntegros, recebido %v", got)
+	}
+}
+
+func TestSinceAndApplyShipTheLog(t *testing.T) {
+	dir := t.TempDir()
+	primary, err := Open(dir, Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		primary.Put(makeTx(i))
+	}
+	primary.Snapshot()
+	for i := 4; i < 6; i++ {
+		primary.Put(makeTx(i))
+	}
+	primary.Close()
+
+	// As posições sobrevivem ao snapshot: o envio pode recomeçar de qualquer LSN
+	primary, _ = Open(dir, Options{})
+	defer primary.Close()
+	if got := primary.Since(2, 100); len(got) != 4 || got[0].LSN != 3 || got[0].Tx.ID != "tx-2" {
+		t.Fatalf("Esperava as entradas 3..6 após o LSN 2, recebido %+v", got)
+	}
+	if got := primary.Since(0, 3); len(got) != 3 {
+		t.Errorf("Esperava que max limitasse o lote, recebido %d entradas", len(got))
+	}
+
+	replica := NewMemStore()
+	for _, e := range primary.Since(0, 100) {
+		replica.Apply(e)
+	}
+	replica.Apply(primary.Since(0, 1)[0]) // Reenvio é ignorado
+	if replica.Len() != 6 || replica.LastLSN() != 6 {
+		t.Errorf("Esperava a réplica no LSN 6 com 6 registros, recebido LSN %d com %d", replica.LastLSN(), replica.Len())
+	}
+}
+
+func TestVersionedWritesKeepOneRecordPerID(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := makeTx(1)
+	v1.Version = 1
+	if res, _ := s.Put(v1); !res.Applied || res.LSN != 1 {
+		t.Fatalf("Esperava a primeira gravação aplicada no LSN 1, recebido %+v", res)
+	}
+
+	// Reenvio da mesma versão não grava nada e devolve o que está armazenado
+	if res, _ := s.Put(v1); res.Applied || res.LSN != 1 || s.LastLSN() != 1 {
+		t.Errorf("Esperava que a gravação repetida não mudasse nada, recebido %+v (LSN %d)", res, s.LastLSN())
+	}
+
+	v2 := v1
+	v2.Version = 2
+	v2.Price = 99
+	v2.Timestamp = v1.Timestamp.Add(time.Hour)
+	if res, _ := s.Put(v2); !res.Applied || res.LSN != 2 {
+		t.Errorf("Esperava a versão mais nova aplicada no LSN 2, recebido %+v", res)
+	}
+	if res, _ := s.Put(v1); res.Applied || res.Stored.Price != 99 {
+		t.Errorf("Esperava que a versão mais antiga perdesse para a gravada, recebido %+v", res)
+	}
+
+	check := func(stage string) {
+		if s.Len() != 1 {
+			t.Errorf("%s: esperava um único registro, recebido %d", stage, s.Len())
+		}
+		if got, _ := s.Get(v1.ID); got.Version != 2 {
+			t.Errorf("%s: esperava a versão 2, recebido %+v", stage, got)
+		}
+		page, _ := s.Query(query.Query{Symbol: "PETR4"})
+		if len(page.Transactions) != 1 || page.Transactions[0].Price != 99 {
+			t.Errorf("%s: esperava apenas a versão nova no índice, recebido %+v", stage, page.Transactions)
+		}
+		if entries := s.Since(0, 10); len(entries) != 1 || entries[0].LSN != 2 {
+			t.Errorf("%s: esperava apenas a versão nova enviada, recebido %+v", stage, entries)
+		}
+	}
+	check("before restart")
+
+	s.Snapshot()
+	s.Close()
+	s, _ = Open(dir, Options{})
+	defer s.Close()
+	check("after restart")
+}
+
+func TestDeleteSurvivesRecoveryAndReachesReplicas(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		s.Put(makeTx(i))
+	}
+	if ok, err := s.Delete("tx-1"); !ok || err != nil {
+		t.Fatalf("Esperava tx-1 removida, recebido %v %v", ok, err)
+	}
+	if ok, _ := s.Delete("missing"); ok {
+		t.Error("Esperava false ao remover um ID desconhecido")
+	}
+	s.Snapshot()
+	s.Delete("tx-2") // Uma remoção no snapshot e outra no log
+	s.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := ids(s); fmt.Sprint(got) != "[tx-0 tx-3]" {
+		t.Errorf("Esperava [tx-0 tx-3] após a recuperação, recebido %v", got)
+	}
+	if _, ok := s.Get("tx-1"); ok {
+		t.Error("Transação removida ainda encontrada pelo ID")
+	}
+	if page, _ := s.Query(query.Query{Symbol: "PETR4"}); len(page.Transactions) != 2 {
+		t.Errorf("Esperava 2 transações no índice, recebido %d", len(page.Transactions))
+	}
+
+	// Uma réplica que ficou no LSN 2 recebe as duas remoções
+	replica := NewMemStore()
+	for _, e := range s.Since(0, 2) {
+		replica.Apply(e)
+	}
+	for _, e := range s.Since(2, 100) {
+		replica.Apply(e)
+	}
+	if fmt.Sprint(ids(replica)) != "[tx-0 tx-3]" || replica.LastLSN() != s.LastLSN() {
+		t.Errorf("Esperava a réplica com [tx-0 tx-3] no LSN %d, recebido %v no %d", s.LastLSN(), ids(replica), replica.LastLSN())
+	}
+
+	// A árvore de Merkle reflete o conteúdo vivo e os tombstones, igual nos dois lados
+	same := NewMemStore()
+	same.Put(makeTx(0))
+	same.Put(makeTx(3))
+	if same.Tree().Root() == s.Tree().Root() {
+		t.Error("Esperava raízes diferentes sem os tombstones de tx-1 e tx-2")
+	}
+	same.Tombstone(Tombstone{ID: "tx-1", Version: makeTx(1).Version})
+	same.Tombstone(Tombstone{ID: "tx-2", Version: makeTx(2).Version})
+	if replica.Tree().Root() != s.Tree().Root() || s.Tree().Root() != same.Tree().Root() {
+		t.Error("Esperava raízes Merkle iguais para conteúdos e tombstones iguais")
+	}
+	var tombs []Tombstone
+	s.Tombstones(func(tb Tombstone) bool { tombs = append(tombs, tb); return true })
+	if len(tombs) != 2 {
+		t.Errorf("Esperava 2 tombstones após a recuperação, recebido %v", tombs)
+	}
+}
+
+func TestExpireReachesReplicasAndRejectsLateWrites(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3000; i++ {
+		tx := makeTx(i)
+		if i%2 == 1 {
+			tx.Symbol = "VALE3"
+		}
+		s.Put(tx)
+	}
+	replica := NewMemStore()
+	for _, e := range s.Since(0, 5000) {
+		replica.Apply(e)
+	}
+
+	cut := makeTx(1000).Timestamp
+	if n, err := s.Expire("PETR4", cut); n != 500 || err != nil {
+		t.Fatalf("Esperava 500 transações expiradas, recebido %d %v", n, err)
+	}
+	if n, _ := s.Expire("PETR4", cut.Add(-time.Hour)); n != 0 || !s.Horizon("PETR4").Equal(cut) {
+		t.Errorf("Esperava que um limite mais antigo fosse ignorado, recebido %d expiradas e horizonte %v", n, s.Horizon("PETR4"))
+	}
+	if n, _ := s.Expire("PETR4", makeTx(2000).Timestamp); n != 500 {
+		t.Errorf("Esperava mais 500 transações expiradas, recebido %d", n)
+	}
+	if n, _ := s.Expire("VALE3", makeTx(1200).Timestamp); n != 600 {
+		t.Errorf("Esperava 600 transações de VALE3 expiradas, recebido %d", n)
+	}
+	if s.Len() != 1400 || fmt.Sprint(s.Symbols()) != "[PETR4 VALE3]" {
+		t.Errorf("Esperava 1400 transações restantes nos dois símbolos, recebido %d %v", s.Len(), s.Symbols())
+	}
+	if slots := len(s.mem.txs); slots != 1402 {
+		t.Errorf("Esperava as posições mortas descartadas da memória, recebido %d posições", slots)
+	}
+
+	// Gravação atrasada abaixo do limite é ignorada; outros símbolos não são afetados
+	if res, _ := s.Put(makeTx(10)); res.Applied {
+		t.Error("Esperava que uma gravação anterior ao limite de retenção fosse ignorada")
+	}
+	late := makeTx(1500)
+	late.Symbol, late.ID = "VALE3", "late"
+	if res, _ := s.Put(late); !res.Applied {
+		t.Error("Esperava que VALE3 aceitasse gravações após o próprio limite")
+	}
+
+	// Só a última expiração de cada símbolo vai para a réplica, que converge
+	var expires int
+	for _, e := range s.Since(3000, 100) {
+		if e.Op == OpExpire {
+			expires++
+		}
+		replica.Apply(e)
+	}
+	if expires != 2 || replica.Len() != s.Len() || replica.Tree().Root() != s.Tree().Root() {
+		t.Errorf("Esperava uma entrada expire e a réplica convergida, recebido %d entradas e %d registros", expires, replica.Len())
+	}
+
+	s.Snapshot()
+	s.Close()
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Len() != 1401 || !s.Horizon("PETR4").Equal(makeTx(2000).Timestamp) {
+		t.Errorf("Esperava que o limite de retenção sobrevivesse à recuperação, recebido %d registros e horizonte %v", s.Len(), s.Horizon("PETR4"))
+	}
+	if page, _ := s.Query(query.Query{Symbol: "PETR4", Limit: 1}); len(page.Transactions) != 1 || page.Transactions[0].ID != "tx-2000" {
+		t.Errorf("Esperava o histórico de PETR4 começando em tx-2000, recebido %+v", page.Transactions)
+	}
+	if res, _ := s.Put(makeTx(10)); res.Applied {
+		t.Error("Esperava que gravações antigas continuassem ignoradas após a recuperação")
+	}
+}
+
+func contents(s Store) map[string]model.Transaction {
+	out := make(map[string]model.Transaction)
+	s.Scan(func(tx model.Transaction) bool {
+		out[tx.ID] = tx
+		return true
+	})
+	return out
+}
+
+func TestBackupAndPointInTimeRestore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(filepath.Join(dir, "data"), Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		s.Put(makeTx(i))
+	}
+	// Pontos anteriores ao snapshot ficam fora do backup
+	if err := s.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	type point struct {
+		lsn   uint64
+		at    time.Time
+		state map[string]model.Transaction
+	}
+	var points []point
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			tx := makeTx(round*20 + i)
+			tx.Version = int64(round + 1) // Sobrescreve versões gravadas antes
+			s.Put(tx)
+		}
+		s.Delete(fmt.Sprintf("tx-%d", round))
+		if round == 3 {
+			s.Expire("PETR4", makeTx(10).Timestamp)
+		}
+		points = append(points, point{lsn: s.LastLSN(), at: time.Now(), state: contents(s)})
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// Gravações continuam durante o backup
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1000; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				s.Put(makeTx(i))
+			}
+		}
+	}()
+	info, err := s.Backup(filepath.Join(dir, "backup"))
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.BaseLSN != 50 || info.LSN < points[4].lsn || info.Entries != int(info.LSN-info.BaseLSN) {
+		t.Errorf("Faixa de backup inesperada: %+v", info)
+	}
+	if _, err := s.Backup(filepath.Join(dir, "backup")); err == nil {
+		t.Error("Backup em um diretório não vazio deveria ser recusado")
+	}
+	s.Close()
+
+	restore := func(name string, p RestorePoint) *DiskStore {
+		t.Helper()
+		target := filepath.Join(dir, name)
+		res, err := Restore(filepath.Join(dir, "backup"), target, p)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		r, err := Open(target, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close() })
+		if r.LastLSN() != res.LSN || r.Len() != res.Transactions {
+			t.Errorf("%s: store reaberto no LSN %d com %d transações, a restauração informou %+v", name, r.LastLSN(), r.Len(), res)
+		}
+		return r
+	}
+	same := func(name string, got Store, want map[string]model.Transaction) {
+		t.Helper()
+		have := contents(got)
+		if len(have) != len(want) {
+			t.Errorf("%s: %d transações, esperava %d", name, len(have), len(want))
+		}
+		for id, tx := range want {
+			if h, ok := have[id]; !ok || h.Version != tx.Version {
+				t.Errorf("%s: %s tem a versão %d (presente: %v), esperava %d", name, id, h.Version, ok, tx.Version)
+			}
+		}
+	}
+
+	byLSN := restore("by-lsn", RestorePoint{LSN: points[1].lsn})
+	same("by LSN", byLSN, points[1].state)
+	if byLSN.LastLSN() != points[1].lsn {
+		t.Errorf("Esperava o LSN %d após a restauração, recebido %d", points[1].lsn, byLSN.LastLSN())
+	}
+	same("by time", restore("by-time", RestorePoint{Time: points[3].at}), points[3].state)
+	if late, _ := byLSN.Put(makeTx(5)); late.Applied {
+		t.Error("O store restaurado deveria manter as versões do ponto escolhido")
+	}
+	full := restore("full", RestorePoint{})
+	if full.LastLSN() != info.LSN {
+		t.Errorf("A restauração completa deveria chegar ao LSN %d, recebido %d", info.LSN, full.LastLSN())
+	}
+	if full.Horizon("PETR4").IsZero() {
+		t.Error("A expiração deveria sobreviver à restauração")
+	}
+
+	if _, err := Restore(filepath.Join(dir, "backup"), filepath.Join(dir, "early"), RestorePoint{LSN: 10}); err == nil {
+		t.Error("Um ponto anterior à base do backup deveria ser recusado")
+	}
+	if _, err := Restore(filepath.Join(dir, "backup"), filepath.Join(dir, "full"), RestorePoint{}); err == nil {
+		t.Error("Restaurar sobre dados existentes deveria ser recusado")
+	}
+}
+
+func TestIdempotencyKeySurvivesRestartAndReachesReplicas(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := makeTx(1)
+	original.Version = 10
+	original.IdempotencyKey = "order-1"
+	if res, _ := s.Put(original); !res.Applied {
+		t.Fatal("A primeira gravação com uma chave deveria ser gravada")
+	}
+
+	// A mesma chave com outro ID, ou uma versão mais nova do mesmo ID: as duas são repetições
+	repeat := makeTx(2)
+	repeat.IdempotencyKey = "order-1"
+	newer := original
+	newer.Version, newer.Price = 20, 99
+	for _, tx := range []model.Transaction{repeat, newer} {
+		if res, _ := s.Put(tx); res.Applied || res.Stored.ID != original.ID || res.Stored.Version != 10 {
+			t.Errorf("A repetição %s deveria devolver a original, recebido %+v", tx.ID, res)
+		}
+	}
+	if s.Len() != 1 || s.LastLSN() != 1 {
+		t.Errorf("Repetições não deveriam chegar ao log: %d transações, LSN %d", s.Len(), s.LastLSN())
+	}
+
+	s.Snapshot()
+	s.Close()
+	if s, err = Open(dir, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if res, _ := s.Put(repeat); res.Applied || res.Stored.ID != original.ID {
+		t.Errorf("A chave deveria sobreviver ao restart, recebido %+v", res)
+	}
+
+	replica := NewMemStore()
+	for _, e := range s.Since(0, 10) {
+		replica.Apply(e)
+	}
+	if res, _ := replica.Put(repeat); res.Applied || res.Stored.ID != original.ID {
+		t.Errorf("A chave deveria chegar às réplicas pelo log, recebido %+v", res)
+	}
+
+	// Remover a transação libera a chave
+	s.Delete(original.ID)
+	if res, _ := s.Put(repeat); !res.Applied {
+		t.Error("A chave de uma transação removida deveria ficar livre de novo")
+	}
+}
+
+// TestResetSurvivesReopen valida que Reset esvazia o Store, volta ao LSN 0 e
+// continua assim depois de reabrir, aceitando o log de novo desde o início.
+func TestResetSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s.Put(makeTx(i))
+	}
+	s.Snapshot()
+	s.Put(makeTx(3))
+	if err := s.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 || s.LastLSN() != 0 || s.Tree().Root() != NewMemStore().Tree().Root() {
+		t.Errorf("Esperava o Store vazio no LSN 0, recebido %d transações no LSN %d", s.Len(), s.LastLSN())
+	}
+	s.Apply(Entry{LSN: 1, Op: OpPut, Tx: makeTx(9)})
+	s.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := ids(s); fmt.Sprint(got) != "[tx-9]" || s.LastLSN() != 1 {
+		t.Errorf("Esperava só tx-9 no LSN 1 após reabrir, recebido %v no %d", got, s.LastLSN())
+	}
+}
+
+// TestWritesCompactSupersededVersions valida que, sem retenção, os slots das
+// versões substituídas são descartados pelas próprias gravações.
+func TestWritesCompactSupersededVersions(t *testing.T) {
+	s := NewMemStore()
+	for v := 1; v <= 500; v++ {
+		for i := 0; i < 10; i++ {
+			tx := makeTx(i)
+			tx.Version = int64(v)
+			s.Put(tx)
+		}
+	}
+	if len(s.txs) > 2*compactMinSlots {
+		t.Errorf("Esperava no máximo %d slots após 5000 gravações de 10 IDs, recebido %d", 2*compactMinSlots, len(s.txs))
+	}
+	if s.Len() != 10 || s.LastLSN() != 5000 {
+		t.Fatalf("Esperava 10 transações no LSN 5000, recebido %d no %d", s.Len(), s.LastLSN())
+	}
+	page, _ := s.Query(query.Query{Symbol: "PETR4"})
+	for _, tx := range page.Transactions {
+		if tx.Version != 500 {
+			t.Errorf("Esperava a versão 500 de %s, recebido %d", tx.ID, tx.Version)
+		}
+	}
+	replica := NewMemStore()
+	for _, e := range s.Since(0, 100) {
+		replica.Apply(e)
+	}
+	if replica.Tree().Root() != s.Tree().Root() {
+		t.Error("Esperava a réplica igual ao primário com o log compactado")
+	}
+}
+
+// TestPruneTombstonesByAge valida que as remoções antigas saem da memória, da
+// árvore e do log, igual em dois nós com o mesmo tombstone, e que o ponto
+// descartado sobrevive ao snapshot.
+func TestPruneTombstonesByAge(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{Sync: SyncNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 3; i++ {
+		s.Put(makeTx(i))
+	}
+	s.Tombstone(Tombstone{ID: "tx-0", DeletedAt: old})
+	oldLSN := s.LastLSN()
+	s.Delete("tx-1")
+
+	// Um par que recebeu a mesma remoção antiga pela anti-entropia
+	peer := NewMemStore()
+	peer.Put(makeTx(1))
+	peer.Put(makeTx(2))
+	s.Tombstones(func(tb Tombstone) bool {
+		peer.Tombstone(tb)
+		return true
+	})
+
+	cutoff := time.Now().Add(-24 * time.Hour)
+	if n, _ := s.PruneTombstones(cutoff); n != 1 {
+		t.Fatalf("Esperava descartar 1 tombstone, descartou %d", n)
+	}
+	peer.PruneTombstones(cutoff)
+	if s.Tree().Root() != peer.Tree().Root() {
+		t.Error("Esperava raízes iguais depois de os dois nós descartarem o tombstone antigo")
+	}
+	var tombs []Tombstone
+	s.Tombstones(func(tb Tombstone) bool { tombs = append(tombs, tb); return true })
+	if len(tombs) != 1 || tombs[0].ID != "tx-1" {
+		t.Errorf("Esperava só o tombstone recente de tx-1, recebido %v", tombs)
+	}
+	for _, e := range s.Since(0, 100) {
+		if e.Op == OpDelete && e.Tx.ID == "tx-0" {
+			t.Errorf("Tombstone descartado ainda enviado pelo log: %+v", e)
+		}
+	}
+	if s.PrunedLSN() != oldLSN {
+		t.Errorf("Esperava o ponto descartado no LSN %d, recebido %d", oldLSN, s.PrunedLSN())
+	}
+
+	s.Snapshot()
+	s.Close()
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.PrunedLSN() != oldLSN || fmt.Sprint(ids(s)) != "[tx-2]" {
+		t.Errorf("Esperava [tx-2] e o ponto descartado %d após reabrir, recebido %v e %d", oldLSN, ids(s), s.PrunedLSN())
+	}
+}
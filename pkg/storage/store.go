@@ -0,0 +1,599 @@
+package storage
+
+import (
+	"distributed-system/pkg/merkle"
+	"distributed-system/pkg/model"
+	"distributed-system/pkg/query"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store é a camada de armazenamento de um shard.
+//
+// Cada transação é única pelo ID: gravar um ID existente só substitui o
+// registro se a nova versão (model.Transaction.Version) for maior; caso
+// contrário a gravação é ignorada e a versão armazenada é devolvida. Isso
+// torna reenvios idempotentes e resolve conflitos entre réplicas.
+//
+// MemStore mantém tudo em memória (testes e shards descartáveis); DiskStore
+// acrescenta um write-ahead log e snapshots para sobreviver a restarts.
+type Store interface {
+	// Put grava a transação, atribuindo a ela a próxima posição (LSN) do log.
+	Put(tx model.Transaction) (PutResult, error)
+	// Delete remove a transação do ID, registrando a remoção no log (que
+	// chega às réplicas). Devolve false se o ID não existia.
+	Delete(id string) (bool, error)
+	// Tombstone grava a remoção das versões até t.Version do ID vinda de um par
+	// (anti-entropia), mesmo que o ID não exista aqui. Uma versão viva mais
+	// nova não é removida. t.DeletedAt, o instante da remoção original, é
+	// mantido (zero = agora). Devolve false se nada mudou.
+	Tombstone(t Tombstone) (bool, error)
+	// Tombstones percorre as remoções dos IDs sem versão viva até fn devolver false.
+	Tombstones(fn func(t Tombstone) bool)
+	// PruneTombstones descarta as remoções feitas antes de before, que já
+	// tiveram tempo de chegar a todos os nós. Devolve quantas foram descartadas.
+	PruneTombstones(before time.Time) (int, error)
+	// PrunedLSN devolve o maior LSN de uma remoção descartada: uma réplica que
+	// ainda não chegou a ele não a recebe mais pelo log.
+	PrunedLSN() uint64
+	// Expire remove as transações do símbolo anteriores a before (retenção),
+	// com uma única entrada no log. Gravações anteriores ao limite passam a
+	// ser ignoradas. Devolve quantas transações foram removidas.
+	Expire(symbol string, before time.Time) (int, error)
+	// Horizon devolve o limite de retenção do símbolo (zero se nunca expirou).
+	Horizon(symbol string) time.Time
+	// Horizons devolve o limite de retenção de cada símbolo que já expirou.
+	Horizons() map[string]time.Time
+	// Symbols devolve os símbolos com transações armazenadas.
+	Symbols() []string
+	// Get busca uma transação pelo ID.
+	Get(id string) (model.Transaction, bool)
+	// Query avalia uma consulta de histórico, usando os índices quando possível.
+	Query(q query.Query) (query.Page, error)
+	// Scan percorre as transações em ordem de gravação até fn devolver false.
+	Scan(fn func(tx model.Transaction) bool)
+	// Len devolve o número de transações armazenadas.
+	Len() int
+	// LastLSN devolve a posição da última gravação (0 se vazio).
+	LastLSN() uint64
+	// Since devolve até max entradas com LSN maior que lsn (envio do log a réplicas).
+	Since(lsn uint64, max int) []Entry
+	// Apply grava uma entrada recebida do primário mantendo o LSN original.
+	// Entradas já aplicadas são ignoradas.
+	Apply(e Entry) error
+	// Tree devolve a árvore de Merkle do conteúdo, atualizada a cada gravação
+	// (comparação entre réplicas).
+	Tree() *merkle.Tree
+	// Backup grava em dir (novo ou vazio) uma cópia consistente, sem parar as
+	// gravações. Restore recria o Store a partir dela.
+	Backup(dir string) (BackupInfo, error)
+	// Reset descarta todo o conteúdo e volta ao LSN 0 (uma réplica que vai
+	// receber de novo o log inteiro do primário).
+	Reset() error
+	Close() error
+}
+
+// PutResult descreve o efeito de uma gravação.
+type PutResult struct {
+	LSN     uint64            `json:"lsn"`     // Posição da versão armazenada
+	Applied bool              `json:"applied"` // false: já havia uma versão igual ou mais nova
+	Stored  model.Transaction `json:"stored"`  // Versão que ficou armazenada
+}
+
+// Tombstone é a remoção das versões até Version de um ID.
+type Tombstone struct {
+	ID        string    `json:"id"`
+	Version   int64     `json:"version"`
+	DeletedAt time.Time `json:"deleted_at,omitempty"` // Instante da remoção original, igual em todos os nós
+}
+
+// MemStore é um Store apenas em memória.
+//
+// As transações ficam em slots na ordem do log; quando uma versão nova
+// substitui outra, o slot antigo é marcado como morto e a nova versão ocupa
+// um slot no fim, com o seu próprio LSN. Uma remoção ou expiração mata os
+// slots das transações e ocupa um slot morto próprio (tombstone), para que
+// Since a envie. Só a expiração mais recente de cada símbolo é mantida: ela
+// cobre as anteriores, e as remoções saem com PruneTombstones. Quando os
+// slots mortos passam a ser a maioria (a cada gravação que mata um slot),
+// eles são descartados da memória.
+type MemStore struct {
+	mu       sync.RWMutex // Leituras de histórico concorrem com gravações de novas transações
+	txs      []model.Transaction
+	lsns     []uint64 // LSN de cada slot (crescente), para Since
+	dead     []bool   // Slots substituídos por uma versão mais nova ou removidos
+	tomb     []string // Operação registrada pelo slot (OpDelete ou OpExpire); vazio nas gravações
+	tombs    int      // Slots com tomb preenchido
+	live     int
+	lsn      uint64
+	idx      *index
+	tree     *merkle.Tree
+	horizons map[string]uint64 // LSN da expiração mais recente de cada símbolo
+	deleted  map[string]Tombstone // Maior versão removida de cada ID sem versão viva (tombstone na árvore)
+	pruned   uint64               // Maior LSN de uma remoção descartada por PruneTombstones
+}
+
+// compactMinSlots evita reconstruir os slots de stores pequenos.
+const compactMinSlots = 1024
+
+func NewMemStore() *MemStore {
+	return &MemStore{idx: newIndex(), tree: merkle.New(merkle.DefaultDepth), horizons: make(map[string]uint64), deleted: make(map[string]Tombstone)}
+}
+
+func (m *MemStore) Put(tx model.Transaction) (PutResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if res, ok := m.rejectLocked(tx); ok {
+		return res, nil
+	}
+	return m.applyLocked(Entry{LSN: m.lsn + 1, Op: OpPut, Tx: tx}).PutResult, nil
+}
+
+func (m *MemStore) Delete(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.idx.byID[id]; !ok {
+		return false, nil
+	}
+	m.applyLocked(Entry{LSN: m.lsn + 1, Op: OpDelete, Tx: model.Transaction{ID: id, Timestamp: time.Now()}})
+	return true, nil
+}
+
+func (m *MemStore) Tombstone(t Tombstone) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.tombstoneAppliesLocked(t.ID, t.Version) {
+		return false, nil
+	}
+	m.applyLocked(tombstoneEntry(m.lsn+1, t))
+	return true, nil
+}
+
+// tombstoneEntry é a entrada do log de uma remoção vinda de um par.
+func tombstoneEntry(lsn uint64, t Tombstone) Entry {
+	if t.DeletedAt.IsZero() {
+		t.DeletedAt = time.Now()
+	}
+	return Entry{LSN: lsn, Op: OpDelete, Tx: model.Transaction{ID: t.ID, Version: t.Version, Timestamp: t.DeletedAt}}
+}
+
+// tombstoneAppliesLocked informa se a remoção das versões até version muda
+// algo: remove a versão viva ou cobre um tombstone mais antigo.
+func (m *MemStore) tombstoneAppliesLocked(id string, version int64) bool {
+	if pos, ok := m.idx.byID[id]; ok {
+		return m.txs[pos].Version <= version
+	}
+	if old, ok := m.deleted[id]; ok {
+		return old.Version < version
+	}
+	return true
+}
+
+func (m *MemStore) Tombstones(fn func(t Tombstone) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, t := range m.deleted {
+		if !fn(t) {
+			return
+		}
+	}
+}
+
+// PruneTombstones tira da árvore e dos slots as remoções anteriores a before.
+// Como o instante vem da remoção original, todos os nós descartam os mesmos
+// tombstones e as árvores voltam a coincidir.
+func (m *MemStore) PruneTombstones(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for id, t := range m.deleted {
+		if t.DeletedAt.Before(before) {
+			m.forgetDeletedLocked(id)
+			n++
+		}
+	}
+	for i, op := range m.tomb {
+		if op == OpDelete && m.txs[i].Timestamp.Before(before) {
+			m.tomb[i] = ""
+			m.tombs--
+			if m.lsns[i] > m.pruned {
+				m.pruned = m.lsns[i]
+			}
+		}
+	}
+	m.compactLocked()
+	return n, nil
+}
+
+func (m *MemStore) PrunedLSN() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pruned
+}
+
+func (m *MemStore) Expire(symbol string, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !before.After(m.horizonLocked(symbol)) {
+		return 0, nil
+	}
+	return m.applyLocked(Entry{LSN: m.lsn + 1, Op: OpExpire, Tx: model.Transaction{Symbol: symbol, Timestamp: before}}).expired, nil
+}
+
+func (m *MemStore) Horizon(symbol string) time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.horizonLocked(symbol)
+}
+
+func (m *MemStore) Horizons() map[string]time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	horizons := make(map[string]time.Time, len(m.horizons))
+	for symbol := range m.horizons {
+		horizons[symbol] = m.horizonLocked(symbol)
+	}
+	return horizons
+}
+
+func (m *MemStore) horizonLocked(symbol string) time.Time {
+	lsn, ok := m.horizons[symbol]
+	if !ok {
+		return time.Time{}
+	}
+	return m.txs[m.slotLocked(lsn)].Timestamp
+}
+
+// slotLocked devolve o slot com o LSN (que precisa existir).
+func (m *MemStore) slotLocked(lsn uint64) int {
+	return sort.Search(len(m.lsns), func(i int) bool { return m.lsns[i] >= lsn })
+}
+
+func (m *MemStore) Symbols() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	symbols := make([]string, 0, len(m.idx.bySymbol))
+	for symbol, positions := range m.idx.bySymbol {
+		if len(positions) > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func (m *MemStore) Apply(e Entry) error {
+	m.apply(e)
+	return nil
+}
+
+// rejectLocked devolve a versão existente quando tx não a substitui. Uma
+// transação anterior ao limite de retenção do símbolo é ignorada, para que
+// reenvios e a anti-entropia não tragam de volta o que já expirou.
+//
+// Uma chave de idempotência já gravada torna a escrita uma repetição, que
+// devolve a transação original. Se a repetição chegou a um nó antes da
+// original (mesmo ID, versão menor), a original a substitui: a escrita mais
+// antiga de cada chave prevalece, e as réplicas convergem para ela.
+func (m *MemStore) rejectLocked(tx model.Transaction) (PutResult, bool) {
+	if tx.Timestamp.Before(m.horizonLocked(tx.Symbol)) {
+		return PutResult{}, true
+	}
+	if pos, ok := m.idx.byKey[tx.IdempotencyKey]; ok && tx.IdempotencyKey != "" {
+		if m.txs[pos].ID != tx.ID || !model.Supersedes(tx, m.txs[pos]) {
+			return PutResult{LSN: m.lsns[pos], Stored: m.txs[pos]}, true
+		}
+		return PutResult{}, false
+	}
+	pos, ok := m.idx.byID[tx.ID]
+	if !ok || model.Supersedes(tx, m.txs[pos]) {
+		return PutResult{}, false
+	}
+	return PutResult{LSN: m.lsns[pos], Stored: m.txs[pos]}, true
+}
+
+// applied é o efeito de uma entrada aplicada à memória.
+type applied struct {
+	PutResult
+	expired int // Transações removidas por uma expiração
+}
+
+// apply reaplica uma entrada já registrada (recuperação ou réplica), mantendo o LSN original.
+func (m *MemStore) apply(e Entry) applied {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.applyLocked(e)
+}
+
+func (m *MemStore) applyLocked(e Entry) applied {
+	if e.LSN <= m.lsn {
+		return applied{PutResult: PutResult{LSN: e.LSN}}
+	}
+	m.lsn = e.LSN
+	switch e.Op {
+	case OpDelete:
+		// O tombstone registra a versão removida (a viva, numa remoção local)
+		t := Tombstone{ID: e.Tx.ID, Version: e.Tx.Version, DeletedAt: deletedAt(e.Tx)}
+		pos, existed := m.idx.byID[e.Tx.ID]
+		if existed && m.txs[pos].Version > t.Version {
+			t.Version = m.txs[pos].Version
+		}
+		m.killLocked(e.Tx.ID)
+		m.appendSlotLocked(model.Transaction{ID: t.ID, Version: t.Version, Timestamp: t.DeletedAt}, e.LSN, OpDelete)
+		m.markDeletedLocked(t)
+		m.compactLocked()
+		return applied{PutResult: PutResult{LSN: e.LSN, Applied: existed}}
+	case OpExpire:
+		return applied{PutResult: PutResult{LSN: e.LSN, Applied: true}, expired: m.expireLocked(e)}
+	}
+	if res, ok := m.rejectLocked(e.Tx); ok {
+		return applied{PutResult: res}
+	}
+	m.killLocked(e.Tx.ID)
+	m.forgetDeletedLocked(e.Tx.ID)
+	m.appendSlotLocked(e.Tx, e.LSN, "")
+	m.live++
+	m.idx.add(m.txs, len(m.txs)-1)
+	m.tree.Add(e.Tx.ID, e.Tx.Version)
+	m.compactLocked()
+	return applied{PutResult: PutResult{LSN: e.LSN, Applied: true, Stored: e.Tx}}
+}
+
+// deletedAt devolve o instante de uma remoção do log. Remoções gravadas antes
+// de o log registrar o instante começam a envelhecer agora.
+func deletedAt(tx model.Transaction) time.Time {
+	if tx.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return tx.Timestamp
+}
+
+// expireLocked remove as transações do símbolo anteriores ao limite da
+// entrada, que passa a ser a expiração registrada do símbolo.
+func (m *MemStore) expireLocked(e Entry) int {
+	positions := m.idx.bySymbol[e.Tx.Symbol]
+	n := sort.Search(len(positions), func(i int) bool { return !m.txs[positions[i]].Timestamp.Before(e.Tx.Timestamp) })
+	ids := make([]string, n)
+	for i, pos := range positions[:n] {
+		ids[i] = m.txs[pos].ID
+	}
+	for _, id := range ids {
+		m.killLocked(id)
+	}
+
+	// A expiração anterior do símbolo fica coberta por esta
+	if lsn, ok := m.horizons[e.Tx.Symbol]; ok {
+		m.tomb[m.slotLocked(lsn)] = ""
+		m.tombs--
+	}
+	m.appendSlotLocked(model.Transaction{Symbol: e.Tx.Symbol, Timestamp: e.Tx.Timestamp}, e.LSN, OpExpire)
+	m.horizons[e.Tx.Symbol] = e.LSN
+	m.compactLocked()
+	return n
+}
+
+// compactLocked descarta os slots mortos que não registram remoções quando
+// eles passam a ser a maioria, e reconstrói o índice sobre os restantes. É
+// chamado a cada gravação: o teste é barato e a reconstrução, amortizada.
+func (m *MemStore) compactLocked() {
+	if len(m.txs) < compactMinSlots || len(m.txs)-m.live-m.tombs < len(m.txs)/2 {
+		return
+	}
+	n := 0
+	for i := range m.txs {
+		if m.dead[i] && m.tomb[i] == "" {
+			continue
+		}
+		m.txs[n], m.lsns[n], m.dead[n], m.tomb[n] = m.txs[i], m.lsns[i], m.dead[i], m.tomb[i]
+		n++
+	}
+	m.txs = append([]model.Transaction(nil), m.txs[:n]...)
+	m.lsns = append([]uint64(nil), m.lsns[:n]...)
+	m.dead = append([]bool(nil), m.dead[:n]...)
+	m.tomb = append([]string(nil), m.tomb[:n]...)
+	m.idx.rebuild(m.txs, m.dead)
+}
+
+// killLocked marca como morto o slot vivo do ID, se houver.
+func (m *MemStore) killLocked(id string) {
+	if pos, ok := m.idx.byID[id]; ok {
+		m.idx.remove(m.txs, pos)
+		m.tree.Remove(id, m.txs[pos].Version)
+		m.dead[pos] = true
+		m.live--
+	}
+}
+
+// markDeletedLocked registra o tombstone do ID na árvore, mantendo a maior versão removida.
+func (m *MemStore) markDeletedLocked(t Tombstone) {
+	if old, ok := m.deleted[t.ID]; ok {
+		if old.Version >= t.Version {
+			return
+		}
+		m.tree.RemoveDeleted(t.ID, old.Version)
+	}
+	m.deleted[t.ID] = t
+	m.tree.AddDeleted(t.ID, t.Version)
+}
+
+// forgetDeletedLocked tira o tombstone do ID, recriado por uma gravação ou descartado pela idade.
+func (m *MemStore) forgetDeletedLocked(id string) {
+	if old, ok := m.deleted[id]; ok {
+		m.tree.RemoveDeleted(id, old.Version)
+		delete(m.deleted, id)
+	}
+}
+
+// appendSlotLocked acrescenta um slot; op preenchido (OpDelete ou OpExpire) cria um tombstone.
+func (m *MemStore) appendSlotLocked(tx model.Transaction, lsn uint64, op string) {
+	m.txs = append(m.txs, tx)
+	m.lsns = append(m.lsns, lsn)
+	m.dead = append(m.dead, op != "")
+	m.tomb = append(m.tomb, op)
+	if op != "" {
+		m.tombs++
+	}
+}
+
+// load substitui o conteúdo pelo de um snapshot (transações vivas, remoções e
+// expirações, intercaladas pelo LSN) e reconstrói os índices.
+func (m *MemStore) load(lsn uint64, txs []model.Transaction, lsns []uint64, deleted []Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lsn = lsn
+	m.txs = make([]model.Transaction, 0, len(txs)+len(deleted))
+	m.lsns = make([]uint64, 0, len(txs)+len(deleted))
+	m.dead = make([]bool, 0, len(txs)+len(deleted))
+	m.tomb = make([]string, 0, len(txs)+len(deleted))
+	m.tombs = 0
+	m.horizons = make(map[string]uint64)
+	m.deleted = make(map[string]Tombstone)
+	m.pruned = 0
+	m.tree = merkle.New(m.tree.Depth())
+	for i, j := 0, 0; i < len(txs) || j < len(deleted); {
+		if j == len(deleted) || (i < len(txs) && lsns[i] < deleted[j].LSN) {
+			m.forgetDeletedLocked(txs[i].ID)
+			m.appendSlotLocked(txs[i], lsns[i], "")
+			i++
+		} else {
+			e := deleted[j]
+			if e.Op == "" {
+				e.Op = OpDelete // Snapshots anteriores às expirações só tinham remoções
+			}
+			if e.Op == OpDelete {
+				e.Tx.Timestamp = deletedAt(e.Tx)
+			}
+			m.appendSlotLocked(e.Tx, e.LSN, e.Op)
+			if e.Op == OpExpire {
+				m.horizons[e.Tx.Symbol] = e.LSN
+			} else {
+				m.markDeletedLocked(Tombstone{ID: e.Tx.ID, Version: e.Tx.Version, DeletedAt: e.Tx.Timestamp})
+			}
+			j++
+		}
+	}
+	m.live = len(txs)
+	m.idx.rebuild(m.txs, m.dead)
+	for _, tx := range txs {
+		m.tree.Add(tx.ID, tx.Version)
+	}
+}
+
+// loadSnapshot carrega um snapshot lido do disco.
+func (m *MemStore) loadSnapshot(snap snapshot) {
+	m.load(snap.LSN, snap.Transactions, snap.LSNs, snap.Deleted)
+	m.mu.Lock()
+	m.pruned = snap.Pruned
+	m.mu.Unlock()
+}
+
+func (m *MemStore) Reset() error {
+	m.load(0, nil, nil, nil)
+	return nil
+}
+
+// liveLocked devolve as transações vivas e seus LSNs (conteúdo de um snapshot).
+func (m *MemStore) liveLocked() ([]model.Transaction, []uint64) {
+	txs := make([]model.Transaction, 0, m.live)
+	lsns := make([]uint64, 0, m.live)
+	for i, tx := range m.txs {
+		if !m.dead[i] {
+			txs = append(txs, tx)
+			lsns = append(lsns, m.lsns[i])
+		}
+	}
+	return txs, lsns
+}
+
+// tombstonesLocked devolve as remoções e expirações registradas, que o
+// snapshot guarda para que réplicas atrasadas ainda as recebam.
+func (m *MemStore) tombstonesLocked() []Entry {
+	var deleted []Entry
+	for i, op := range m.tomb {
+		if op != "" {
+			deleted = append(deleted, Entry{LSN: m.lsns[i], Op: op, Tx: m.txs[i]})
+		}
+	}
+	return deleted
+}
+
+func (m *MemStore) Since(lsn uint64, max int) []Entry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	i := sort.Search(len(m.lsns), func(i int) bool { return m.lsns[i] > lsn })
+	var entries []Entry
+	for ; i < len(m.lsns) && len(entries) < max; i++ {
+		// Versões substituídas não são enviadas: a versão nova vem depois no log
+		switch {
+		case m.tomb[i] != "":
+			entries = append(entries, Entry{LSN: m.lsns[i], Op: m.tomb[i], Tx: m.txs[i]})
+		case !m.dead[i]:
+			entries = append(entries, Entry{LSN: m.lsns[i], Op: OpPut, Tx: m.txs[i]})
+		}
+	}
+	return entries
+}
+
+func (m *MemStore) Get(id string) (model.Transaction, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pos, ok := m.idx.byID[id]
+	if !ok {
+		return model.Transaction{}, false
+	}
+	return m.txs[pos], true
+}
+
+// Query usa o índice por símbolo quando a consulta tem símbolo; sem ele,
+// percorre todas as transações.
+func (m *MemStore) Query(q query.Query) (query.Page, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return query.Page{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if q.Symbol != "" {
+		return m.idx.query(m.txs, q), nil
+	}
+	return query.Run(m.scanLocked, q)
+}
+
+func (m *MemStore) scanLocked(fn func(tx model.Transaction) bool) {
+	for i, tx := range m.txs {
+		if m.dead[i] {
+			continue
+		}
+		if !fn(tx) {
+			return
+		}
+	}
+}
+
+func (m *MemStore) Scan(fn func(tx model.Transaction) bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	m.scanLocked(fn)
+}
+
+func (m *MemStore) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.live
+}
+
+func (m *MemStore) LastLSN() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lsn
+}
+
+func (m *MemStore) Tree() *merkle.Tree {
+	return m.tree
+}
+
+func (m *MemStore) Close() error {
+	return nil
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"distributed-system/pkg/model"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// SyncPolicy define quando o log é forçado para o disco (fsync).
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // fsync a cada gravação: nada confirmado se perde
+	SyncInterval SyncPolicy = "interval" // fsync periódico: perde no máximo o último intervalo
+	SyncNone     SyncPolicy = "none"     // Deixa a cargo do sistema operacional
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(s); p {
+	case SyncAlways, SyncInterval, SyncNone:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown fsync policy %q (use always, interval or none)", s)
+}
+
+// Operações do log. Uma remoção leva em Tx o ID, a versão removida e o
+// instante da remoção (Timestamp); uma expiração leva o símbolo e o limite
+// de retenção (Timestamp).
+const (
+	OpPut    = "put"
+	OpDelete = "delete"
+	OpExpire = "expire"
+)
+
+// Entry é um registro do write-ahead log.
+type Entry struct {
+	LSN uint64            `json:"lsn"`
+	Op  string            `json:"op"`
+	Tx  model.Transaction `json:"tx"`
+	// Time é o instante (Unix nanos) em que a entrada foi gravada no log deste
+	// nó, usado na restauração por horário. Entradas enviadas a réplicas não o
+	// levam: cada réplica registra o instante em que recebeu a entrada.
+	Time int64 `json:"time,omitempty"`
+}
+
+// Formato de cada registro no arquivo: tamanho (4 bytes) + CRC32 do payload
+// (4 bytes) + payload JSON. Um registro incompleto ou com CRC inválido marca
+// o fim do trecho confiável do log (escrita interrompida por um crash).
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 16 << 20 // Tamanhos acima disso só aparecem em cabeçalhos corrompidos
+)
+
+type wal struct {
+	mu     sync.Mutex
+	f      *os.File
+	policy SyncPolicy
+	dirty  bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// openWAL lê os registros válidos de path, trunca uma cauda corrompida e
+// devolve o log pronto para novas gravações.
+func openWAL(path string, policy SyncPolicy, interval time.Duration) (*wal, []Entry, int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	entries, good, err := readEntries(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, nil, 0, err
+	}
+	truncated := size - good
+	if truncated > 0 {
+		if err := f.Truncate(good); err != nil {
+			f.Close()
+			return nil, nil, 0, err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, nil, 0, err
+		}
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, 0, err
+	}
+
+	w := &wal{f: f, policy: policy}
+	if policy == SyncInterval {
+		if interval <= 0 {
+			interval = 100 * time.Millisecond
+		}
+		w.stop = make(chan struct{})
+		w.done = make(chan struct{})
+		go w.syncLoop(interval)
+	}
+	return w, entries, truncated, nil
+}
+
+// readEntries lê registros do início do arquivo até o fim ou até o primeiro
+// registro inválido, devolvendo o offset do fim do último registro válido.
+func readEntries(r io.ReadSeeker) ([]Entry, int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	var entries []Entry
+	var good int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			// EOF limpo ou cabeçalho parcial: fim do trecho confiável
+			return entries, good, nil
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		if size > maxRecordSize {
+			return entries, good, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return entries, good, nil
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			return entries, good, nil
+		}
+		var e Entry
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return entries, good, nil
+		}
+		entries = append(entries, e)
+		good += int64(recordHeaderSize) + int64(size)
+	}
+}
+
+func encodeRecord(e Entry) ([]byte, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+	return record, nil
+}
+
+func (w *wal) append(e Entry) error {
+	record, err := encodeRecord(e)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.f.Write(record); err != nil {
+		return err
+	}
+	if w.policy == SyncAlways {
+		return w.f.Sync()
+	}
+	w.dirty = true
+	return nil
+}
+
+// reset descarta o conteúdo do log (já coberto por um snapshot).
+func (w *wal) reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.dirty = false
+	return w.f.Sync()
+}
+
+// size devolve o tamanho atual do log (o fim do último registro gravado).
+func (w *wal) size() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Seek(0, io.SeekCurrent)
+}
+
+func (w *wal) syncLoop(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			if w.dirty {
+				w.f.Sync()
+				w.dirty = false
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+func (w *wal) close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.f.Sync(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}